@@ -0,0 +1,54 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"lds.li/web/internal/ctxkeys"
+)
+
+// AbsoluteURL returns the full external URL for path, e.g. for links in
+// emails or OAuth callbacks. The path is joined to the Config.BaseURL of the
+// server handling the request, so the scheme and host are correct behind a
+// TLS terminating proxy. If the request is not being served by a Server, the
+// scheme and host are taken from the request. The path can include a query
+// and fragment.
+func AbsoluteURL(r *http.Request, path string) string {
+	base, ok := ctxkeys.BaseURLFromContext(r.Context())
+	if !ok {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = &url.URL{Scheme: scheme, Host: r.Host}
+	}
+	return absoluteURL(base, path)
+}
+
+// BaseURLFromContext returns the Config.BaseURL of the server handling the
+// request. It returns false if the request is not being served by a Server.
+func BaseURLFromContext(ctx context.Context) (*url.URL, bool) {
+	return ctxkeys.BaseURLFromContext(ctx)
+}
+
+// AbsoluteURL returns the full external URL for path. See AbsoluteURL.
+func (b *Request) AbsoluteURL(path string) string {
+	return AbsoluteURL(b.r, path)
+}
+
+func absoluteURL(base *url.URL, path string) string {
+	ref, err := url.Parse(path)
+	if err != nil {
+		ref = &url.URL{Path: path}
+	}
+	u := url.URL{
+		Scheme:   base.Scheme,
+		Host:     base.Host,
+		Path:     strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(ref.Path, "/"),
+		RawQuery: ref.RawQuery,
+		Fragment: ref.Fragment,
+	}
+	return u.String()
+}
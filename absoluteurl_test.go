@@ -0,0 +1,69 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestAbsoluteURL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		base string
+		path string
+		want string
+	}{
+		{name: "root", base: "https://example.com", path: "/login", want: "https://example.com/login"},
+		{name: "base path", base: "https://example.com/app/", path: "/login", want: "https://example.com/app/login"},
+		{name: "relative path", base: "https://example.com/app", path: "login", want: "https://example.com/app/login"},
+		{name: "query and fragment", base: "https://example.com", path: "/cb?code=1#top", want: "https://example.com/cb?code=1#top"},
+		{name: "port", base: "http://localhost:8080", path: "/", want: "http://localhost:8080/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, _ := url.Parse(tc.base)
+			if got := absoluteURL(base, tc.path); got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("server base URL", func(t *testing.T) {
+		base, _ := url.Parse("https://example.com")
+		svr, err := NewServer(&Config{
+			BaseURL: base,
+			Static:  os.DirFS("static/testdata"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			got    string
+			gotCtx *url.URL
+		)
+		svr.Handle("/", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+			got = br.AbsoluteURL("/callback")
+			gotCtx, _ = BaseURLFromContext(ctx)
+			return nil
+		}))
+
+		// the request arrived over plain HTTP from a TLS terminating proxy,
+		// on an internal host.
+		svr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://internal:8080/", nil))
+		if want := "https://example.com/callback"; got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+		if gotCtx != base {
+			t.Errorf("want base URL %v from context, got %v", base, gotCtx)
+		}
+	})
+
+	t.Run("request fallback", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "https://example.org/", nil)
+		if got, want := AbsoluteURL(r, "/callback"), "https://example.org/callback"; got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+	})
+}
@@ -2,16 +2,13 @@ package web
 
 import "net/http"
 
-// BaseHeaders sets basic security headers for all requests:
-// - X-Frame-Options: SAMEORIGIN
-// - X-XSS-Protection: 1; mode=block
-// - X-Content-Type-Options: nosniff
+// BaseHeaders sets the X-XSS-Protection: 1; mode=block header for all
+// requests. X-Frame-Options, X-Content-Type-Options and the other security
+// headers are set by the secheaders middleware, so they can be configured in
+// one place.
 func BaseHeaders(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set security headers
-		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
 
 		h.ServeHTTP(w, r)
 	})
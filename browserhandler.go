@@ -17,7 +17,7 @@ func (b BrowserHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rw = NewResponseWriter(w)
 	}
 	if err := r.ParseForm(); err != nil {
-		b.handleError(rw, r, fmt.Errorf("parsing form: %w", err))
+		b.handleError(rw, r, bodyLimitError(fmt.Errorf("parsing form: %w", err)))
 		return
 	}
 
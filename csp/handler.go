@@ -3,6 +3,7 @@ package csp
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,11 +11,16 @@ import (
 	"net/url"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
 type scriptNonceKey struct{}
 type styleNonceKey struct{}
 
+// wrappedKey flags that a Handler has already been applied to the request.
+type wrappedKey struct{ h *Handler }
+
 // Handler enforces Content-Security-Policy and also reports CSP errors to
 // a logger for analysis.
 //
@@ -23,6 +29,18 @@ type styleNonceKey struct{}
 type Handler struct {
 	baseURL    url.URL
 	reportsURL url.URL
+	// reportURI is the user-provided override for the reports URL.
+	reportURI string
+	// interceptReports indicates that Wrap should handle POSTs to the
+	// reportsURL path.
+	interceptReports bool
+	// disableReportEndpoint prevents Wrap from intercepting reports.
+	disableReportEndpoint bool
+	// maxReportBytes limits the size of intercepted report bodies.
+	maxReportBytes int64
+	// reportLimiter limits how many intercepted reports are logged, nil if
+	// unlimited.
+	reportLimiter *reportLimiter
 
 	reportOnly bool
 
@@ -50,6 +68,59 @@ func ReportOnly(reportOnly bool) HandlerOpt {
 	}
 }
 
+// ReportURI overrides the URL CSP violation reports are sent to. A relative
+// URL is resolved against the base URL. If the URL is on the same host as the
+// base URL, Wrap handles reports at its path. If it is on another host, reports
+// are left for that host to handle. NewHandler panics if the URL is invalid.
+func ReportURI(u string) HandlerOpt {
+	return func(h *Handler) {
+		h.reportURI = u
+	}
+}
+
+// DefaultMaxReportBytes is the default limit on the size of a violation report
+// body handled by Wrap. Reports are small, so this is generous.
+const DefaultMaxReportBytes = 64 << 10
+
+// MaxReportBytes limits the size of violation report bodies handled by Wrap,
+// so the endpoint can not be used to exhaust memory or flood the logs. Larger
+// reports are rejected with a 413. Defaults to DefaultMaxReportBytes.
+func MaxReportBytes(n int64) HandlerOpt {
+	return func(h *Handler) {
+		h.maxReportBytes = n
+	}
+}
+
+// DefaultReportRateLimit is the default number of violation reports Wrap logs
+// per minute.
+const DefaultReportRateLimit = 100
+
+// ReportRateLimit limits the violation reports handled by Wrap to n per
+// period, so a misconfigured policy or a malicious client can not flood the
+// logs. Reports over the limit are rejected with a 429 without being logged,
+// and the number dropped is logged when the next period starts. A limit of
+// zero or less disables it. Defaults to DefaultReportRateLimit per minute.
+func ReportRateLimit(n int, per time.Duration) HandlerOpt {
+	return func(h *Handler) {
+		h.reportLimiter = nil
+		if n > 0 && per > 0 {
+			h.reportLimiter = &reportLimiter{limit: n, per: per}
+		}
+	}
+}
+
+// DisableReportEndpoint stops Wrap from handling violation reports, so POSTs
+// to the report path are passed to the wrapped handler. This lets the app
+// register its own handler at the path, e.g. to forward reports to its own
+// pipeline. The policy still includes a report-uri directive for the reports
+// URL, which is /_/csp-reports under the base URL unless changed with
+// ReportURI.
+func DisableReportEndpoint() HandlerOpt {
+	return func(h *Handler) {
+		h.disableReportEndpoint = true
+	}
+}
+
 func DefaultSrc(src ...string) HandlerOpt {
 	return func(h *Handler) {
 		h.defaultSrc = append(h.defaultSrc, src...)
@@ -152,7 +223,10 @@ func WithStyleNonce() HandlerOpt {
 
 func NewHandler(baseURL url.URL, opts ...HandlerOpt) *Handler {
 	h := &Handler{
-		baseURL: baseURL,
+		baseURL:          baseURL,
+		interceptReports: true,
+		maxReportBytes:   DefaultMaxReportBytes,
+		reportLimiter:    &reportLimiter{limit: DefaultReportRateLimit, per: time.Minute},
 	}
 
 	reportsURL := baseURL // copy
@@ -163,6 +237,22 @@ func NewHandler(baseURL url.URL, opts ...HandlerOpt) *Handler {
 		opt(h)
 	}
 
+	if h.reportURI != "" {
+		ru, err := url.Parse(h.reportURI)
+		if err != nil {
+			panic(fmt.Sprintf("CSP: invalid report URI %q: %v", h.reportURI, err))
+		}
+		ru = baseURL.ResolveReference(ru)
+		if ru.Scheme != "http" && ru.Scheme != "https" {
+			panic(fmt.Sprintf("CSP: report URI %q must be http or https", h.reportURI))
+		}
+		h.reportsURL = *ru
+		h.interceptReports = ru.Host == baseURL.Host
+	}
+	if h.disableReportEndpoint {
+		h.interceptReports = false
+	}
+
 	return h
 }
 
@@ -190,25 +280,53 @@ var DefaultOpts = []HandlerOpt{
 }
 
 // Wrap wraps an existing http.Handler with the configured content security
-// policy. It also intercepts POST requests to /_/csp-reports and logs them as
-// CSP violations. Nonces are generated here if enabled.
+// policy. It also intercepts POST requests to the reports path (by default
+// /_/csp-reports) and logs them as CSP violations. Nonces are generated here if
+// enabled. If the same Handler is composed more than once, the inner
+// applications pass the request straight through.
 func (h *Handler) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		if h.enableScriptNonce {
+		if ctx.Value(wrappedKey{h}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx = context.WithValue(ctx, wrappedKey{h}, true)
+
+		// Nonces are only generated once per request. If they are already in
+		// the context the handler has been composed more than once, and
+		// replacing them would invalidate the nonces an outer handler has
+		// already put in the header.
+		if _, ok := GetScriptNonce(ctx); h.enableScriptNonce && !ok {
 			ctx = context.WithValue(ctx, scriptNonceKey{}, rand.Text())
 		}
 
-		if h.enableStyleNonce {
+		if _, ok := GetStyleNonce(ctx); h.enableStyleNonce && !ok {
 			ctx = context.WithValue(ctx, styleNonceKey{}, rand.Text())
 		}
 
 		r = r.WithContext(ctx)
 		h.addCSPHeaders(w, r)
 
-		if r.Method == http.MethodPost && r.URL.Path == h.reportsURL.Path {
-			violation, err := io.ReadAll(r.Body)
+		if h.interceptReports && r.Method == http.MethodPost && r.URL.Path == h.reportsURL.Path {
+			if h.reportLimiter != nil {
+				allowed, dropped := h.reportLimiter.allow(time.Now())
+				if dropped > 0 {
+					slog.WarnContext(r.Context(), "CSP violation reports dropped by rate limit", slog.Int("dropped", dropped))
+				}
+				if !allowed {
+					http.Error(w, "Too many CSP reports", http.StatusTooManyRequests)
+					return
+				}
+			}
+			violation, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxReportBytes))
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				slog.WarnContext(r.Context(), "CSP violation report too large", slog.Int64("limit", mbe.Limit))
+				http.Error(w, "CSP report too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			if err != nil {
 				slog.ErrorContext(r.Context(), "reading CSP violation body", "err", err) // Use original context for error reporting
 				http.Error(w, "Failed to read CSP report", http.StatusInternalServerError)
@@ -223,7 +341,57 @@ func (h *Handler) Wrap(next http.Handler) http.Handler {
 	})
 }
 
+// reportLimiter allows up to limit reports in each fixed period.
+type reportLimiter struct {
+	limit int
+	per   time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	dropped     int
+}
+
+// allow reports if a report at now is within the limit. When a new period
+// starts, it also returns the number of reports dropped in the previous one,
+// so they can be logged once.
+func (l *reportLimiter) allow(now time.Time) (allowed bool, previouslyDropped int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= l.per {
+		previouslyDropped = l.dropped
+		l.windowStart = now
+		l.count = 0
+		l.dropped = 0
+	}
+	if l.count >= l.limit {
+		l.dropped++
+		return false, previouslyDropped
+	}
+	l.count++
+	return true, previouslyDropped
+}
+
 func (h *Handler) addCSPHeaders(w http.ResponseWriter, r *http.Request) {
+	if p := h.PolicyString(r.Context()); p != "" {
+		w.Header().Set(h.HeaderName(), p)
+	}
+}
+
+// HeaderName returns the name of the header the policy is sent in, which
+// depends on if the policy is report only.
+func (h *Handler) HeaderName() string {
+	if h.reportOnly {
+		return "Content-Security-Policy-Report-Only"
+	}
+	return "Content-Security-Policy"
+}
+
+// PolicyString returns the policy for a request, including the nonces from
+// the context. It panics if nonces are enabled but not in the context, so
+// should be called from inside the handler returned by Wrap.
+func (h *Handler) PolicyString(ctx context.Context) string {
 	var elements []string
 
 	// Helper to build a directive string
@@ -241,7 +409,7 @@ func (h *Handler) addCSPHeaders(w http.ResponseWriter, r *http.Request) {
 	scriptSrcValues := make([]string, len(h.scriptSrc))
 	copy(scriptSrcValues, h.scriptSrc)
 	if h.enableScriptNonce {
-		nonce, ok := GetScriptNonce(r.Context())
+		nonce, ok := GetScriptNonce(ctx)
 		if !ok {
 			// If WithScriptNonce() was called, a nonce should always be in the context.
 			// Panicking here indicates an unexpected internal state.
@@ -256,7 +424,7 @@ func (h *Handler) addCSPHeaders(w http.ResponseWriter, r *http.Request) {
 	styleSrcValues := make([]string, len(h.styleSrc))
 	copy(styleSrcValues, h.styleSrc)
 	if h.enableStyleNonce {
-		nonce, ok := GetStyleNonce(r.Context())
+		nonce, ok := GetStyleNonce(ctx)
 		if !ok {
 			// If WithStyleNonce() was called, a nonce should always be in the context.
 			panic("CSP: style nonce enabled but not found in context")
@@ -294,13 +462,7 @@ func (h *Handler) addCSPHeaders(w http.ResponseWriter, r *http.Request) {
 
 	elements = append(elements, fmt.Sprintf("report-uri %s", h.reportsURL.String()))
 
-	headerName := "Content-Security-Policy"
-	if h.reportOnly {
-		headerName = "Content-Security-Policy-Report-Only"
-	}
-	if len(elements) > 0 {
-		w.Header().Set(headerName, strings.Join(elements, "; "))
-	}
+	return strings.Join(elements, "; ")
 }
 
 // GetScriptNonce retrieves the script nonce from the context, if available.
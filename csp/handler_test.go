@@ -7,7 +7,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandler(t *testing.T) {
@@ -132,6 +135,92 @@ func TestHandler(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			name: "custom report URI",
+			opts: []HandlerOpt{
+				DefaultSrc("'self'"),
+				ReportURI("/app/csp"),
+			},
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("OK"))
+			}),
+			req: httptest.NewRequest(http.MethodPost, "http://example.com/app/csp", bytes.NewReader([]byte("{}"))),
+			checkResponse: func(resp *http.Response) error {
+				want := `default-src 'self'; report-uri http://example.com/app/csp`
+				if got := resp.Header.Get("Content-Security-Policy"); want != got {
+					return fmt.Errorf("Content-Security-Policy: want: %q, got %q", want, got)
+				}
+				if resp.StatusCode != http.StatusNoContent {
+					return fmt.Errorf("want status %d, got %d", http.StatusNoContent, resp.StatusCode)
+				}
+				return nil
+			},
+		},
+		{
+			name: "external report URI is not intercepted",
+			opts: []HandlerOpt{
+				DefaultSrc("'self'"),
+				ReportURI("https://reports.example.net/csp"),
+			},
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("OK"))
+			}),
+			req: httptest.NewRequest(http.MethodPost, "http://example.com/csp", bytes.NewReader([]byte("{}"))),
+			checkResponse: func(resp *http.Response) error {
+				want := `default-src 'self'; report-uri https://reports.example.net/csp`
+				if got := resp.Header.Get("Content-Security-Policy"); want != got {
+					return fmt.Errorf("Content-Security-Policy: want: %q, got %q", want, got)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				} else if !bytes.Equal([]byte("OK"), body) {
+					return fmt.Errorf("body: want %v, got %v", []byte("OK"), body)
+				}
+				return nil
+			},
+		},
+		{
+			name: "report too large",
+			opts: []HandlerOpt{
+				DefaultSrc("'self'"),
+				MaxReportBytes(8),
+			},
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("OK"))
+			}),
+			req: httptest.NewRequest(http.MethodPost, "http://example.com/_/csp-reports", bytes.NewReader([]byte(`{"csp-report":{}}`))),
+			checkResponse: func(resp *http.Response) error {
+				if resp.StatusCode != http.StatusRequestEntityTooLarge {
+					return fmt.Errorf("status: want %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+				}
+				return nil
+			},
+		},
+		{
+			name: "report endpoint disabled",
+			opts: []HandlerOpt{
+				DefaultSrc("'self'"),
+				DisableReportEndpoint(),
+			},
+			wrapped: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("OK"))
+			}),
+			req: httptest.NewRequest(http.MethodPost, "http://example.com/_/csp-reports", bytes.NewReader([]byte("{}"))),
+			checkResponse: func(resp *http.Response) error {
+				want := `default-src 'self'; report-uri http://example.com/_/csp-reports`
+				if got := resp.Header.Get("Content-Security-Policy"); want != got {
+					return fmt.Errorf("Content-Security-Policy: want: %q, got %q", want, got)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				} else if !bytes.Equal([]byte("OK"), body) {
+					return fmt.Errorf("body: want %v, got %v", []byte("OK"), body)
+				}
+				return nil
+			},
+		},
 		{
 			name: "multiple sources",
 			opts: []HandlerOpt{
@@ -225,3 +314,127 @@ func TestHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestReportURIInvalid(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("want panic for invalid report URI")
+		}
+	}()
+	NewHandler(url.URL{Scheme: "https", Host: "example.com"}, ReportURI("ftp://example.com/csp"))
+}
+
+func TestNonceMatchesHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		wraps int
+	}{
+		{name: "single", wraps: 1},
+		{name: "composed twice", wraps: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandler(url.URL{Scheme: "https", Host: "example.com"}, DefaultSrc("'self'"), WithScriptNonce(), WithStyleNonce())
+
+			var scriptNonce, styleNonce string
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				scriptNonce, _ = GetScriptNonce(r.Context())
+				styleNonce, _ = GetStyleNonce(r.Context())
+			})
+			for range tc.wraps {
+				handler = h.Wrap(handler)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if scriptNonce == "" || styleNonce == "" {
+				t.Fatalf("want nonces in context, got script %q style %q", scriptNonce, styleNonce)
+			}
+			header := rec.Header().Values("Content-Security-Policy")
+			if len(header) != 1 {
+				t.Fatalf("want 1 CSP header, got %d", len(header))
+			}
+			if !strings.Contains(header[0], fmt.Sprintf("script-src 'nonce-%s'", scriptNonce)) {
+				t.Errorf("header %q does not contain script nonce %q", header[0], scriptNonce)
+			}
+			if !strings.Contains(header[0], fmt.Sprintf("style-src 'nonce-%s'", styleNonce)) {
+				t.Errorf("header %q does not contain style nonce %q", header[0], styleNonce)
+			}
+		})
+	}
+}
+
+func TestWrapTwice(t *testing.T) {
+	h := NewHandler(url.URL{Scheme: "https", Host: "example.com"}, DefaultSrc("'self'"))
+
+	// the handler between the two wraps replaces the header, which an inner
+	// application of the policy would overwrite.
+	inner := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler := h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "sentinel")
+		inner.ServeHTTP(w, r)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sentinel" {
+		t.Errorf("want inner wrap skipped, got header %q", got)
+	}
+}
+
+func TestReportRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		opts       []HandlerOpt
+		wantStatus []int
+	}{
+		{
+			name:       "limited",
+			opts:       []HandlerOpt{ReportRateLimit(2, time.Hour)},
+			wantStatus: []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests},
+		},
+		{
+			name:       "disabled",
+			opts:       []HandlerOpt{ReportRateLimit(0, 0)},
+			wantStatus: []int{http.StatusNoContent, http.StatusNoContent, http.StatusNoContent},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandler(url.URL{Scheme: "http", Host: "example.com"}, tc.opts...)
+			wrapped := h.Wrap(http.NotFoundHandler())
+
+			var got []int
+			for range tc.wantStatus {
+				rr := httptest.NewRecorder()
+				wrapped.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "http://example.com/_/csp-reports", bytes.NewReader([]byte(`{"csp-report":{}}`))))
+				got = append(got, rr.Code)
+			}
+			if !slices.Equal(tc.wantStatus, got) {
+				t.Errorf("want statuses %v, got %v", tc.wantStatus, got)
+			}
+		})
+	}
+}
+
+func TestReportLimiter(t *testing.T) {
+	l := &reportLimiter{limit: 1, per: time.Minute}
+	start := time.Now()
+
+	for _, step := range []struct {
+		at          time.Duration
+		wantAllowed bool
+		wantDropped int
+	}{
+		{at: 0, wantAllowed: true},
+		{at: time.Second, wantAllowed: false},
+		{at: 2 * time.Second, wantAllowed: false},
+		{at: time.Minute, wantAllowed: true, wantDropped: 2},
+		{at: time.Minute + time.Second, wantAllowed: false},
+	} {
+		allowed, dropped := l.allow(start.Add(step.at))
+		if allowed != step.wantAllowed || dropped != step.wantDropped {
+			t.Errorf("at %v: want allowed %v dropped %d, got %v %d", step.at, step.wantAllowed, step.wantDropped, allowed, dropped)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package web
+
+import "context"
+
+// dependencyKey keys a dependency in the context by its type.
+type dependencyKey[T any] struct{}
+
+// WithDependency returns a copy of ctx carrying dep, keyed by its type T. This
+// is typically used with Config.BaseContext to provide shared dependencies,
+// e.g. a database pool, to every handler.
+func WithDependency[T any](ctx context.Context, dep T) context.Context {
+	return context.WithValue(ctx, dependencyKey[T]{}, dep)
+}
+
+// DependencyFromContext returns the dependency of type T added with
+// WithDependency. It returns false if there is none.
+func DependencyFromContext[T any](ctx context.Context) (T, bool) {
+	dep, ok := ctx.Value(dependencyKey[T]{}).(T)
+	return dep, ok
+}
+
+// baseValuesContext is the request context, falling back to the values of
+// the server's base context. Only values are taken from the base, the
+// deadline and cancellation are the request's.
+type baseValuesContext struct {
+	context.Context
+	base context.Context
+}
+
+func (c *baseValuesContext) Value(key any) any {
+	if v := c.Context.Value(key); v != nil {
+		return v
+	}
+	return c.base.Value(key)
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"lds.li/web/session"
+)
+
+type testDB struct{ name string }
+
+func TestServerBaseContext(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+	smgr, err := session.NewKVManager(session.NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr, err := NewServer(&Config{
+		BaseURL:        base,
+		Static:         os.DirFS("static/testdata"),
+		SessionManager: smgr,
+		BaseContext: func() context.Context {
+			return WithDependency(context.Background(), &testDB{name: "base"})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		gotDB       *testDB
+		gotSession  bool
+		gotCanceled bool
+	)
+	svr.Handle("/", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		gotDB, _ = DependencyFromContext[*testDB](ctx)
+		_, gotSession = session.FromContext(ctx)
+		gotCanceled = ctx.Err() != nil
+		return nil
+	}))
+
+	t.Run("from base", func(t *testing.T) {
+		svr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if gotDB == nil || gotDB.name != "base" {
+			t.Errorf("want base dependency, got %v", gotDB)
+		}
+		if !gotSession {
+			t.Error("want session in context alongside base values")
+		}
+	})
+
+	t.Run("request takes precedence", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(WithDependency(req.Context(), &testDB{name: "request"}))
+		svr.ServeHTTP(httptest.NewRecorder(), req)
+		if gotDB == nil || gotDB.name != "request" {
+			t.Errorf("want request dependency, got %v", gotDB)
+		}
+	})
+
+	t.Run("request cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		svr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+		if !gotCanceled {
+			t.Error("want the request's cancellation in the handler context")
+		}
+	})
+}
+
+func TestDependencyFromContext(t *testing.T) {
+	ctx := WithDependency(context.Background(), &testDB{name: "db"})
+
+	if db, ok := DependencyFromContext[*testDB](ctx); !ok || db.name != "db" {
+		t.Errorf("want db dependency, got %v (ok: %t)", db, ok)
+	}
+	if _, ok := DependencyFromContext[testDB](ctx); ok {
+		t.Error("want dependencies keyed by exact type")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -39,6 +40,40 @@ func Decode(values url.Values, into any) error {
 			continue // Skip fields without form tag
 		}
 
+		fieldValue := v.Field(i)
+
+		// Slices collect every value for the key, e.g. from a multi-select or
+		// checkbox group. The name[] convention is also accepted.
+		if fieldValue.Kind() == reflect.Slice && !isTextUnmarshaler(fieldValue) {
+			var formValues []string
+			for _, fv := range slices.Concat(values[formTag], values[formTag+"[]"]) {
+				if fv != "" {
+					formValues = append(formValues, fv)
+				}
+			}
+
+			if validateTag == "required" && len(formValues) == 0 {
+				if errInvalidForm == nil {
+					errInvalidForm = &ErrInvalidForm{}
+				}
+				errInvalidForm.MissingFields = append(errInvalidForm.MissingFields, field.Name)
+				continue
+			}
+
+			if len(formValues) == 0 {
+				continue
+			}
+
+			slice := reflect.MakeSlice(fieldValue.Type(), len(formValues), len(formValues))
+			for j, fv := range formValues {
+				if err := setValue(slice.Index(j), field.Name, fv); err != nil {
+					return err
+				}
+			}
+			fieldValue.Set(slice)
+			continue
+		}
+
 		formValue := values.Get(formTag)
 
 		if validateTag == "required" && formValue == "" {
@@ -53,37 +88,77 @@ func Decode(values url.Values, into any) error {
 			continue // Skip empty values if not required
 		}
 
-		fieldValue := v.Field(i)
+		if err := setValue(fieldValue, field.Name, formValue); err != nil {
+			return err
+		}
+	}
 
-		// Check for TextUnmarshaler interface
-		if unmarshaler, ok := fieldValue.Addr().Interface().(encoding.TextUnmarshaler); ok {
-			err := unmarshaler.UnmarshalText([]byte(formValue))
-			if err != nil {
-				return fmt.Errorf("error unmarshaling field '%s': %v", field.Name, err)
-			}
-			continue // Move to the next field
+	if errInvalidForm != nil {
+		return errInvalidForm
+	}
+	return nil
+}
+
+// UnknownFields returns the sorted keys in values that do not map to a form
+// tagged field of into, which must be a pointer to a struct. Slice fields also
+// claim the name[] form of their key.
+func UnknownFields(values url.Values, into any) []string {
+	t := reflect.TypeOf(into).Elem()
+
+	known := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		formTag := field.Tag.Get("form")
+		if formTag == "" || formTag == "-" {
+			continue
+		}
+		known[formTag] = true
+		if field.Type.Kind() == reflect.Slice {
+			known[formTag+"[]"] = true
 		}
+	}
 
-		switch fieldValue.Kind() {
-		case reflect.String:
-			fieldValue.SetString(formValue)
-		case reflect.Int:
-			intValue, err := strconv.Atoi(formValue)
-			if err != nil {
-				return fmt.Errorf("invalid integer value for field '%s': %v", field.Name, err)
-			}
-			fieldValue.SetInt(int64(intValue))
-		case reflect.Bool:
-			boolValue := strings.ToLower(formValue) == "true" || formValue == "1" || strings.ToLower(formValue) == "on"
-			fieldValue.SetBool(boolValue)
-		// Add more cases for other types as needed
-		default:
-			return fmt.Errorf("unsupported field type for field '%s'", field.Name)
+	var unknown []string
+	for k := range values {
+		if !known[k] {
+			unknown = append(unknown, k)
 		}
 	}
+	slices.Sort(unknown)
+	return unknown
+}
 
-	if errInvalidForm != nil {
-		return errInvalidForm
+func isTextUnmarshaler(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+// setValue parses formValue in to fieldValue, which must be addressable.
+func setValue(fieldValue reflect.Value, fieldName, formValue string) error {
+	// Check for TextUnmarshaler interface
+	if unmarshaler, ok := fieldValue.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		err := unmarshaler.UnmarshalText([]byte(formValue))
+		if err != nil {
+			return fmt.Errorf("error unmarshaling field '%s': %v", fieldName, err)
+		}
+		return nil
+	}
+
+	switch fieldValue.Kind() {
+	case reflect.String:
+		fieldValue.SetString(formValue)
+	case reflect.Int:
+		intValue, err := strconv.Atoi(formValue)
+		if err != nil {
+			return fmt.Errorf("invalid integer value for field '%s': %v", fieldName, err)
+		}
+		fieldValue.SetInt(int64(intValue))
+	case reflect.Bool:
+		boolValue := strings.ToLower(formValue) == "true" || formValue == "1" || strings.ToLower(formValue) == "on"
+		fieldValue.SetBool(boolValue)
+	// Add more cases for other types as needed
+	default:
+		return fmt.Errorf("unsupported field type for field '%s'", fieldName)
 	}
 	return nil
 }
@@ -103,3 +103,114 @@ func TestParseForm(t *testing.T) {
 		})
 	}
 }
+
+type Filters struct {
+	// Tags is a multi-select
+	Tags []string `form:"tags" validate:"required"`
+	// Sizes is a checkbox group, submitted with the name[] convention
+	Sizes   []int          `form:"sizes"`
+	Customs []MyCustomType `form:"customs"`
+}
+
+func TestParseFormSlices(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    Filters
+		wantErr bool
+	}{
+		{
+			name: "repeated keys",
+			values: url.Values{
+				"tags":    {"a", "b"},
+				"sizes[]": {"1", "3"},
+				"customs": {"x", "y"},
+			},
+			want: Filters{
+				Tags:    []string{"a", "b"},
+				Sizes:   []int{1, 3},
+				Customs: []MyCustomType{{Value: "x"}, {Value: "y"}},
+			},
+		},
+		{
+			name: "single value",
+			values: url.Values{
+				"tags":  {"a"},
+				"sizes": {"2"},
+			},
+			want: Filters{
+				Tags:  []string{"a"},
+				Sizes: []int{2},
+			},
+		},
+		{
+			name: "empty values skipped",
+			values: url.Values{
+				"tags":  {"", "a"},
+				"sizes": {""},
+			},
+			want: Filters{
+				Tags: []string{"a"},
+			},
+		},
+		{
+			name: "missing required",
+			values: url.Values{
+				"tags":  {""},
+				"sizes": {"1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid integer",
+			values: url.Values{
+				"tags":  {"a"},
+				"sizes": {"1", "large"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Filters{}
+			err := Decode(tt.values, &got)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, got); !tt.wantErr && diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		want   []string
+	}{
+		{
+			name:   "all known",
+			values: url.Values{"tags": {"a"}, "sizes[]": {"1"}, "customs": {"x"}},
+		},
+		{
+			name:   "unknown",
+			values: url.Values{"tags": {"a"}, "tagz": {"b"}, "extra": {""}},
+			want:   []string{"extra", "tagz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := UnknownFields(tt.values, &Filters{})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
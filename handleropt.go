@@ -1,14 +1,97 @@
 package web
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"lds.li/web/csrf"
+)
 
 // HandlerOpt are functions that can be used to provide options to middleware
 // serving a request. When registered on a handler, they will be called before
 // the request hits the middleware stack.
 type HandlerOpt func(r *http.Request) *http.Request
 
-// nolint:unused // TODO - either use or drop this.
+// handlerWithOpts is registered on the browser mux, so the options for the
+// matched handler can be applied before the middleware is run.
 type handlerWithOpts struct {
 	http.Handler
 	Opts []HandlerOpt
 }
+
+type skipMiddlewareCtxKey struct{}
+
+// WithoutMiddleware removes the named middleware from the browser middleware
+// chain for this handler.
+func WithoutMiddleware(names ...string) HandlerOpt {
+	return func(r *http.Request) *http.Request {
+		skipped := slices.Concat(skippedMiddleware(r.Context()), names)
+		return r.WithContext(context.WithValue(r.Context(), skipMiddlewareCtxKey{}, skipped))
+	}
+}
+
+// WithCSRFExempt disables CSRF protection for this handler, while keeping the
+// rest of the browser middleware. This is intended for things like webhooks,
+// that are called cross-origin but still want sessions and logging.
+func WithCSRFExempt() HandlerOpt {
+	return func(r *http.Request) *http.Request {
+		// mark the request as skipped as well, in case the CSRF handler has
+		// been installed under a different name.
+		return WithoutMiddleware(MiddlewareCSRFName)(csrf.Skip(r))
+	}
+}
+
+func skippedMiddleware(ctx context.Context) []string {
+	skipped, _ := ctx.Value(skipMiddlewareCtxKey{}).([]string)
+	return skipped
+}
+
+type maxFormBytesCtxKey struct{}
+
+// WithMaxFormBytes overrides Config.MaxFormBytes for this handler, e.g. to
+// allow larger uploads. A negative value disables the limit.
+func WithMaxFormBytes(n int64) HandlerOpt {
+	return func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), maxFormBytesCtxKey{}, n))
+	}
+}
+
+type maxStreamBytesCtxKey struct{}
+
+// WithMaxStreamBytes overrides DefaultMaxStreamBytes for
+// Request.StreamJSONArray and Request.DecodeEvents in this handler. A negative
+// value disables the limit.
+func WithMaxStreamBytes(n int64) HandlerOpt {
+	return func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), maxStreamBytesCtxKey{}, n))
+	}
+}
+
+type secureContextCtxKey struct{}
+
+// RequireSecureContext guards the handler with Config.SecureContext, for the
+// most sensitive routes like payments. All requests must be over TLS, and
+// state changing requests must be same-origin by Sec-Fetch-Site, Origin and
+// cross-origin protection checks. Requests missing the information needed for
+// a check are rejected. The check runs after the browser middleware, and still
+// applies if the handler is CSRF exempt.
+func RequireSecureContext() HandlerOpt {
+	return func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), secureContextCtxKey{}, true))
+	}
+}
+
+func requiresSecureContext(ctx context.Context) bool {
+	v, _ := ctx.Value(secureContextCtxKey{}).(bool)
+	return v
+}
+
+type secureContextNextCtxKey struct{}
+
+// serveSecureContextNext serves the handler guarded by the secure context
+// check. serveBrowser passes it in the request context, so the guard only
+// needs to be built once.
+func serveSecureContextNext(w http.ResponseWriter, r *http.Request) {
+	r.Context().Value(secureContextNextCtxKey{}).(http.Handler).ServeHTTP(w, r)
+}
@@ -1,6 +1,7 @@
 package httperror
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,8 @@ import (
 	"net/http"
 	"runtime/debug"
 	"strings"
+
+	"lds.li/web/vary"
 )
 
 // ErrorHandler defines the interface for handling errors
@@ -23,11 +26,52 @@ func (f ErrorHandlerFunc) HandleError(w http.ResponseWriter, r *http.Request, er
 
 // DefaultErrorHandler provides a basic implementation of ErrorHandler
 func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	(&DefaultHandler{}).HandleError(w, r, err)
+}
+
+// ErrorDetailPolicy controls when the message of a HTTPError is included in
+// the response body.
+type ErrorDetailPolicy int
+
+const (
+	// IncludeErrorDetailAlways includes the error message for all HTTPErrors.
+	IncludeErrorDetailAlways ErrorDetailPolicy = iota
+	// IncludeErrorDetailClientErrors only includes the error message for 4xx
+	// errors. 5xx errors respond with the generic status text.
+	IncludeErrorDetailClientErrors
+	// IncludeErrorDetailNever always responds with the generic status text.
+	IncludeErrorDetailNever
+)
+
+// DefaultHandler is the configurable implementation behind
+// DefaultErrorHandler.
+type DefaultHandler struct {
+	// IncludeErrorDetail controls if the HTTPError message is included in JSON
+	// responses. Defaults to IncludeErrorDetailAlways.
+	IncludeErrorDetail ErrorDetailPolicy
+	// IncludeReference adds the request's Reference to error responses, as a
+	// "Reference: <id>" line in text responses and a reference field in JSON
+	// responses. Users can quote it to support, to find the request in logs.
+	IncludeReference bool
+}
+
+var _ ErrorHandler = (*DefaultHandler)(nil)
+
+// HandleError implements ErrorHandler.
+func (d *DefaultHandler) HandleError(w http.ResponseWriter, r *http.Request, err error) {
 	var he HTTPError
 	isHttpError := errors.As(err, &he)
 
 	slog.ErrorContext(r.Context(), "error in web handler", "err", err, "path", r.URL.Path)
 
+	// the response format is negotiated on Accept
+	vary.Add(w.Header(), "Accept")
+
+	var ref string
+	if d.IncludeReference {
+		ref, _ = Reference(r.Context())
+	}
+
 	if strings.Contains(r.Header.Get("Accept"), "application/json") {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		code := http.StatusInternalServerError
@@ -35,7 +79,10 @@ func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 
 		if isHttpError {
 			code = he.Code()
-			errMsg = he.Error()
+			errMsg = http.StatusText(code)
+			if d.includeDetail(code) {
+				errMsg = he.Error()
+			}
 		} else {
 			slog.Error("internal error in web handler", "err", err, "path", r.URL.Path)
 		}
@@ -43,12 +90,14 @@ func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 		w.WriteHeader(code)
 		jsonErr := struct {
 			Error struct {
-				Code    int    `json:"code"`
-				Message string `json:"message"`
+				Code      int    `json:"code"`
+				Message   string `json:"message"`
+				Reference string `json:"reference,omitempty"`
 			} `json:"error"`
 		}{}
 		jsonErr.Error.Code = code
 		jsonErr.Error.Message = errMsg
+		jsonErr.Error.Reference = ref
 
 		if err := json.NewEncoder(w).Encode(jsonErr); err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -56,12 +105,26 @@ func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 		return
 	}
 
+	code := http.StatusInternalServerError
 	if isHttpError {
-		http.Error(w, http.StatusText(he.Code()), he.Code())
-		return
+		code = he.Code()
+	}
+	msg := http.StatusText(code)
+	if ref != "" {
+		msg += "\nReference: " + ref
 	}
+	http.Error(w, msg, code)
+}
 
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+func (d *DefaultHandler) includeDetail(code int) bool {
+	switch d.IncludeErrorDetail {
+	case IncludeErrorDetailNever:
+		return false
+	case IncludeErrorDetailClientErrors:
+		return code < 500
+	default:
+		return true
+	}
 }
 
 // Handler provides HTTP error handling middleware
@@ -70,12 +133,23 @@ type Handler struct {
 	// RecoverPanic causes panics in wrapped handler to be recovered, and
 	// reported as errors.
 	RecoverPanic bool
+	// BufferResponse holds successful response bodies in memory until the
+	// wrapped handler returns. This allows an error reported after the
+	// handler has started writing to fully replace the response. Without it,
+	// an error reported once the response has started is only logged, as it
+	// can not be rendered. Handlers that stream can opt out with
+	// DisableBuffering, or by flushing the response.
+	BufferResponse bool
+	// OnPanic is called with recovered panics when RecoverPanic is set, before
+	// the error is rendered. This can be used to forward panics to an error
+	// tracking service. The stack is the stack of the panicking goroutine.
+	OnPanic func(ctx context.Context, recovered any, stack []byte, r *http.Request)
 }
 
 // Handle wraps an http.Handler to provide centralized error handling
 func (h *Handler) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rw := newResponseWriter(w)
+		rw := newResponseWriter(w, h.BufferResponse)
 		ctx := r.Context()
 
 		defer func() {
@@ -97,31 +171,39 @@ func (h *Handler) Handle(next http.Handler) http.Handler {
 						"path", r.URL.Path,
 						"stack", string(stack))
 
-					if h.ErrorHandler != nil {
-						h.ErrorHandler.HandleError(w, r, err)
-					} else {
-						DefaultErrorHandler(w, r, err)
+					if h.OnPanic != nil {
+						h.OnPanic(r.Context(), p, stack, r)
 					}
+
+					h.handleError(w, r, rw, err)
 					return
 				}
 			}
 
 			if rw.err != nil {
-				if h.ErrorHandler != nil {
-					h.ErrorHandler.HandleError(w, r, rw.err)
-				} else {
-					DefaultErrorHandler(w, r, rw.err)
-				}
+				h.handleError(w, r, rw, rw.err)
 			} else if rw.code >= 400 {
-				err := New(rw.code, rw.buffer.String())
-				if h.ErrorHandler != nil {
-					h.ErrorHandler.HandleError(w, r, err)
-				} else {
-					DefaultErrorHandler(w, r, err)
-				}
+				h.handleError(w, r, rw, New(rw.code, rw.buffer.String()))
+			} else {
+				rw.stopBuffering()
 			}
 		}()
 
 		next.ServeHTTP(rw, r.WithContext(ctx))
 	})
 }
+
+// handleError renders the error with the configured handler. If the response
+// has already started, the error can not be rendered without corrupting it,
+// so it is only logged.
+func (h *Handler) handleError(w http.ResponseWriter, r *http.Request, rw *responseWriter, err error) {
+	if rw.headerWritten {
+		slog.ErrorContext(r.Context(), "error in web handler after response started", "err", err, "path", r.URL.Path)
+		return
+	}
+	if h.ErrorHandler != nil {
+		h.ErrorHandler.HandleError(w, r, err)
+	} else {
+		DefaultErrorHandler(w, r, err)
+	}
+}
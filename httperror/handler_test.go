@@ -1,12 +1,15 @@
 package httperror
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"lds.li/web/internal"
+	"lds.li/web/requestid"
 )
 
 func TestHTTPError(t *testing.T) {
@@ -176,3 +179,257 @@ type wrapRW struct {
 func (w *wrapRW) Unwrap() http.ResponseWriter {
 	return w.ResponseWriter
 }
+
+func TestDefaultHandler_IncludeErrorDetail(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ErrorDetailPolicy
+		err      error
+		wantBody string
+	}{
+		{
+			name:     "always 4xx",
+			policy:   IncludeErrorDetailAlways,
+			err:      BadRequestErrf("missing field"),
+			wantBody: `{"error":{"code":400,"message":"http error 400: missing field"}}` + "\n",
+		},
+		{
+			name:     "always 5xx",
+			policy:   IncludeErrorDetailAlways,
+			err:      Newf(http.StatusServiceUnavailable, "db down"),
+			wantBody: `{"error":{"code":503,"message":"http error 503: db down"}}` + "\n",
+		},
+		{
+			name:     "client errors 4xx",
+			policy:   IncludeErrorDetailClientErrors,
+			err:      BadRequestErrf("missing field"),
+			wantBody: `{"error":{"code":400,"message":"http error 400: missing field"}}` + "\n",
+		},
+		{
+			name:     "client errors 5xx",
+			policy:   IncludeErrorDetailClientErrors,
+			err:      Newf(http.StatusServiceUnavailable, "db down"),
+			wantBody: `{"error":{"code":503,"message":"Service Unavailable"}}` + "\n",
+		},
+		{
+			name:     "never",
+			policy:   IncludeErrorDetailNever,
+			err:      BadRequestErrf("missing field"),
+			wantBody: `{"error":{"code":400,"message":"Bad Request"}}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", "application/json")
+			rec := httptest.NewRecorder()
+
+			(&DefaultHandler{IncludeErrorDetail: tt.policy}).HandleError(rec, req, tt.err)
+
+			if diff := cmp.Diff(tt.wantBody, rec.Body.String()); diff != "" {
+				t.Error(diff)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want %q", got, "Accept")
+			}
+		})
+	}
+}
+
+func TestDefaultHandler_IncludeReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		accept    string
+		requestID string
+		include   bool
+		wantBody  string
+	}{
+		{
+			name:      "text",
+			requestID: "abc123",
+			include:   true,
+			wantBody:  "Internal Server Error\nReference: abc123\n",
+		},
+		{
+			name:      "json",
+			accept:    "application/json",
+			requestID: "abc123",
+			include:   true,
+			wantBody:  `{"error":{"code":500,"message":"Internal Server Error","reference":"abc123"}}` + "\n",
+		},
+		{
+			name:      "disabled",
+			requestID: "abc123",
+			wantBody:  "Internal Server Error\n",
+		},
+		{
+			name:     "no request ID",
+			include:  true,
+			wantBody: "Internal Server Error\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			if tt.requestID != "" {
+				req = req.WithContext(requestid.ContextWithRequestID(req.Context(), tt.requestID))
+			}
+			rec := httptest.NewRecorder()
+
+			(&DefaultHandler{IncludeReference: tt.include}).HandleError(rec, req, errors.New("boom"))
+
+			if diff := cmp.Diff(tt.wantBody, rec.Body.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestHandler_BufferResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		buffer     bool
+		handler    http.HandlerFunc
+		wantCode   int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name:   "partial write then error, unbuffered",
+			buffer: false,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("partial"))
+				w.(ResponseWriter).WriteError(errors.New("boom"))
+			},
+			wantCode: http.StatusOK,
+			wantBody: "partial",
+		},
+		{
+			name:   "partial write then error, buffered",
+			buffer: true,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("partial"))
+				w.(ResponseWriter).WriteError(errors.New("boom"))
+			},
+			wantCode: http.StatusInternalServerError,
+			wantBody: "Internal Server Error\n",
+		},
+		{
+			name:   "success, buffered",
+			buffer: true,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "value")
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("created"))
+			},
+			wantCode:   http.StatusCreated,
+			wantBody:   "created",
+			wantHeader: http.Header{"X-Test": []string{"value"}},
+		},
+		{
+			name:   "disable buffering streams",
+			buffer: true,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("before"))
+				DisableBuffering(w)
+				_, _ = w.Write([]byte("after"))
+				w.(ResponseWriter).WriteError(errors.New("boom"))
+			},
+			wantCode: http.StatusOK,
+			wantBody: "beforeafter",
+		},
+		{
+			name:   "explicit header then error, unbuffered",
+			buffer: false,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				w.(ResponseWriter).WriteError(errors.New("boom"))
+			},
+			wantCode: http.StatusAccepted,
+			wantBody: "",
+		},
+		{
+			name:   "flush streams",
+			buffer: true,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("before"))
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					panic(err)
+				}
+				_, _ = w.Write([]byte("after"))
+			},
+			wantCode: http.StatusOK,
+			wantBody: "beforeafter",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{BufferResponse: tt.buffer}
+
+			rec := httptest.NewRecorder()
+			h.Handle(tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if diff := cmp.Diff(tt.wantBody, rec.Body.String()); diff != "" {
+				t.Error(diff)
+			}
+			for k, v := range tt.wantHeader {
+				if diff := cmp.Diff(v, rec.Header()[k]); diff != "" {
+					t.Errorf("header %s: %s", k, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_OnPanic(t *testing.T) {
+	var (
+		gotRecovered any
+		gotStack     []byte
+		gotPath      string
+		handledErr   error
+	)
+	h := &Handler{
+		RecoverPanic: true,
+		ErrorHandler: ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request, err error) {
+			if gotRecovered == nil {
+				t.Error("error handler called before OnPanic")
+			}
+			handledErr = err
+			w.WriteHeader(http.StatusInternalServerError)
+		}),
+		OnPanic: func(ctx context.Context, recovered any, stack []byte, r *http.Request) {
+			gotRecovered = recovered
+			gotStack = stack
+			gotPath = r.URL.Path
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	h.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("kaboom")
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/panics", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status code = %v, want %v", rec.Code, http.StatusInternalServerError)
+	}
+	if gotRecovered != "kaboom" {
+		t.Errorf("recovered = %v, want kaboom", gotRecovered)
+	}
+	if len(gotStack) == 0 {
+		t.Error("want stack to be passed")
+	}
+	if gotPath != "/panics" {
+		t.Errorf("path = %q, want /panics", gotPath)
+	}
+	if handledErr == nil {
+		t.Error("want error handler to be called")
+	}
+}
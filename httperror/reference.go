@@ -0,0 +1,14 @@
+package httperror
+
+import (
+	"context"
+
+	"lds.li/web/requestid"
+)
+
+// Reference returns an identifier for the request that a user can quote when
+// reporting an error, so it can be found in the logs. This is the request ID.
+// It returns false if the request has no ID.
+func Reference(ctx context.Context) (string, bool) {
+	return requestid.FromContext(ctx)
+}
@@ -17,6 +17,7 @@ type ResponseWriter interface {
 var (
 	_ internal.UnwrappableResponseWriter = (*responseWriter)(nil)
 	_ ResponseWriter                     = (*responseWriter)(nil)
+	_ http.Flusher                       = (*responseWriter)(nil)
 )
 
 // responseWriter wraps an http.ResponseWriter to intercept error responses
@@ -27,12 +28,19 @@ type responseWriter struct {
 	headerWritten bool
 
 	buffer bytes.Buffer
+
+	// buffering holds successful responses in body until the handler
+	// returns.
+	buffering  bool
+	body       bytes.Buffer
+	headerSent bool // tracks if a buffered WriteHeader call was made
 }
 
-func newResponseWriter(w http.ResponseWriter) *responseWriter {
+func newResponseWriter(w http.ResponseWriter, buffering bool) *responseWriter {
 	return &responseWriter{
 		ResponseWriter: w,
 		code:           http.StatusOK,
+		buffering:      buffering,
 	}
 }
 
@@ -40,6 +48,10 @@ func (w *responseWriter) WriteHeader(code int) {
 	w.code = code
 
 	if code < 400 && !w.headerWritten {
+		if w.buffering {
+			w.headerSent = true
+			return
+		}
 		w.ResponseWriter.WriteHeader(code)
 		w.headerWritten = true
 	}
@@ -49,6 +61,10 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 	if w.code >= 400 {
 		return w.buffer.Write(p)
 	}
+	if w.buffering {
+		return w.body.Write(p)
+	}
+	w.headerWritten = true
 	return w.ResponseWriter.Write(p)
 }
 
@@ -56,6 +72,49 @@ func (w *responseWriter) WriteError(err error) {
 	w.err = err
 }
 
+// Flush sends any buffered response to the client, and disables buffering for
+// the rest of the response.
+func (w *responseWriter) Flush() {
+	w.stopBuffering()
+	if w.code >= 400 {
+		return
+	}
+	w.headerWritten = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// stopBuffering writes any buffered response to the underlying writer, and
+// passes further writes directly through.
+func (w *responseWriter) stopBuffering() {
+	if !w.buffering {
+		return
+	}
+	w.buffering = false
+	if w.code >= 400 {
+		return
+	}
+	if w.headerSent && !w.headerWritten {
+		w.ResponseWriter.WriteHeader(w.code)
+		w.headerWritten = true
+	}
+	if w.body.Len() > 0 {
+		w.headerWritten = true
+		_, _ = w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+}
+
 func (w *responseWriter) Unwrap() http.ResponseWriter {
 	return w.ResponseWriter
 }
+
+// DisableBuffering turns off response buffering for the remainder of the
+// request, sending anything already buffered. This should be called by
+// handlers that stream their response. Once the response is streaming, an
+// error can no longer replace the response. It is a no-op if the request is
+// not being buffered.
+func DisableBuffering(w http.ResponseWriter) {
+	if rw, ok := internal.UnwrapResponseWriterTo[*responseWriter](w); ok {
+		rw.stopBuffering()
+	}
+}
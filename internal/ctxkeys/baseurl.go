@@ -0,0 +1,17 @@
+package ctxkeys
+
+import (
+	"context"
+	"net/url"
+)
+
+type baseURLCtxKey struct{}
+
+func ContextWithBaseURL(ctx context.Context, u *url.URL) context.Context {
+	return context.WithValue(ctx, baseURLCtxKey{}, u)
+}
+
+func BaseURLFromContext(ctx context.Context) (*url.URL, bool) {
+	u, ok := ctx.Value(baseURLCtxKey{}).(*url.URL)
+	return u, ok && u != nil
+}
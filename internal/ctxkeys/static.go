@@ -2,17 +2,21 @@ package ctxkeys
 
 import (
 	"context"
-
-	"lds.li/web/static"
 )
 
 type staticHandlerCtxKey struct{}
 
-func ContextWithStaticHandler(ctx context.Context, sh *static.FileHandler) context.Context {
+// StaticPather resolves the served path for a static file. This is
+// implemented by static.FileHandler.
+type StaticPather interface {
+	PathFor(filePath string) (string, error)
+}
+
+func ContextWithStaticHandler(ctx context.Context, sh StaticPather) context.Context {
 	return context.WithValue(ctx, staticHandlerCtxKey{}, sh)
 }
 
-func StaticHandlerFromContext(ctx context.Context) (*static.FileHandler, bool) {
-	sh, ok := ctx.Value(staticHandlerCtxKey{}).(*static.FileHandler)
+func StaticHandlerFromContext(ctx context.Context) (StaticPather, bool) {
+	sh, ok := ctx.Value(staticHandlerCtxKey{}).(StaticPather)
 	return sh, ok
 }
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SameOrigin reports if an Origin header value is the same origin as base,
+// comparing the scheme, host and port. Hosts are compared case-insensitively,
+// and an omitted port matches the scheme's default port. An empty or opaque
+// ("null") origin never matches.
+func SameOrigin(origin string, base *url.URL) bool {
+	if origin == "" || origin == "null" || base == nil {
+		return false
+	}
+	o, err := url.Parse(origin)
+	if err != nil || o.Opaque != "" || o.User != nil || (o.Path != "" && o.Path != "/") || o.RawQuery != "" || o.Fragment != "" {
+		return false
+	}
+	if !strings.EqualFold(o.Scheme, base.Scheme) {
+		return false
+	}
+	if !strings.EqualFold(o.Hostname(), base.Hostname()) {
+		return false
+	}
+	return originPort(o) == originPort(base)
+}
+
+// originPort returns the URL's port, or the default port for its scheme.
+func originPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IsHTTPS reports if the client made the request over HTTPS. This is true for
+// requests with a direct TLS connection, or if forwardedProtoHeader is set and
+// the trusted proxy that sets it reports https.
+func IsHTTPS(r *http.Request, forwardedProtoHeader string) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return forwardedProtoHeader != "" &&
+		strings.EqualFold(r.Header.Get(forwardedProtoHeader), "https")
+}
@@ -0,0 +1,20 @@
+package internal
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// ParseRemoteAddr parses a http.Request RemoteAddr in to an address. It
+// handles both the host:port form set by the http server, and the bare address
+// form set by proxyhdrs.RemoteIP. IPv4-mapped IPv6 addresses are unmapped.
+func ParseRemoteAddr(remoteAddr string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parsing remote address %q: %w", remoteAddr, err)
+	}
+	return addr.Unmap(), nil
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"lds.li/web/internal"
+)
+
+// WWWMode controls how CanonicalHost treats the www. subdomain.
+type WWWMode int
+
+const (
+	// WWWUnchanged does not add or remove www.
+	WWWUnchanged WWWMode = iota
+	// WWWAdd redirects hosts without a www. prefix to the host with one.
+	WWWAdd
+	// WWWStrip redirects hosts with a www. prefix to the host without it.
+	WWWStrip
+)
+
+// CanonicalHost is a middleware that redirects requests to a single canonical
+// host, preserving the scheme, path and query. Redirects use a 308, so the
+// method and body are preserved. If the app is served behind a proxy,
+// proxyhdrs should run before this so the Host and scheme are the ones the
+// client used.
+type CanonicalHost struct {
+	// Host is the canonical host, e.g. example.com. It should include the
+	// port, if it is not the default for the scheme. Requests for any other
+	// host are redirected to it.
+	Host string
+	// WWW adds or strips the www. prefix of the requested host. It is only
+	// used if Host is not set, for apps served under multiple domains.
+	WWW WWWMode
+	// ForwardedProtoHeader, e.g. X-Forwarded-Proto, is checked to preserve an
+	// https scheme in the redirect when a trusted proxy terminates TLS. If not
+	// set, the scheme is https only for direct TLS connections.
+	ForwardedProtoHeader string
+
+	bypassMux *http.ServeMux
+}
+
+// AllowBypass registers a http.ServeMux pattern that will not be redirected.
+// This is useful for things like health checks, that are made directly to an
+// instance.
+func (c *CanonicalHost) AllowBypass(pattern string) {
+	if c.bypassMux == nil {
+		c.bypassMux = http.NewServeMux()
+	}
+	c.bypassMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		// This handler is never actually called, we just use it for pattern matching
+	})
+}
+
+// Handle wraps the handler, redirecting requests for other hosts to the
+// canonical host.
+func (c *CanonicalHost) Handle(next http.Handler) http.Handler {
+	if c.Host == "" && c.WWW == WWWUnchanged {
+		panic("CanonicalHost requires a Host or WWW mode")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonical := c.canonicalHost(r.Host)
+		if strings.EqualFold(canonical, r.Host) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.bypassMux != nil {
+			if _, pattern := c.bypassMux.Handler(r); pattern != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		scheme := "http"
+		if internal.IsHTTPS(r, c.ForwardedProtoHeader) {
+			scheme = "https"
+		}
+
+		target := scheme + "://" + canonical + r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+func (c *CanonicalHost) canonicalHost(host string) string {
+	if c.Host != "" {
+		return c.Host
+	}
+
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, ""
+	}
+	hasWWW := strings.HasPrefix(strings.ToLower(hostname), "www.")
+	switch {
+	case c.WWW == WWWAdd && !hasWWW:
+		hostname = "www." + hostname
+	case c.WWW == WWWStrip && hasWWW:
+		hostname = hostname[len("www."):]
+	default:
+		return host
+	}
+	if port != "" {
+		return net.JoinHostPort(hostname, port)
+	}
+	return hostname
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		ch           *CanonicalHost
+		bypass       string
+		target       string
+		headers      map[string]string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:       "canonical host is untouched",
+			ch:         &CanonicalHost{Host: "example.com"},
+			target:     "https://example.com/page",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "other host is redirected",
+			ch:           &CanonicalHost{Host: "example.com"},
+			target:       "https://www.example.com/page?q=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/page?q=1",
+		},
+		{
+			name:         "scheme is preserved",
+			ch:           &CanonicalHost{Host: "example.com"},
+			target:       "http://www.example.com/",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "http://example.com/",
+		},
+		{
+			name:         "forwarded scheme",
+			ch:           &CanonicalHost{Host: "example.com", ForwardedProtoHeader: "X-Forwarded-Proto"},
+			target:       "http://www.example.com/",
+			headers:      map[string]string{"X-Forwarded-Proto": "https"},
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/",
+		},
+		{
+			name:         "add www",
+			ch:           &CanonicalHost{WWW: WWWAdd},
+			target:       "https://example.com:8443/page",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://www.example.com:8443/page",
+		},
+		{
+			name:       "add www already present",
+			ch:         &CanonicalHost{WWW: WWWAdd},
+			target:     "https://www.example.com/page",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "strip www",
+			ch:           &CanonicalHost{WWW: WWWStrip},
+			target:       "https://www.example.org/page",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.org/page",
+		},
+		{
+			name:       "bypass",
+			ch:         &CanonicalHost{Host: "example.com"},
+			bypass:     "/healthz",
+			target:     "http://10.0.0.1:8080/healthz",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.bypass != "" {
+				tt.ch.AllowBypass(tt.bypass)
+			}
+			h := tt.ch.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("want location %q, got %q", tt.wantLocation, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
@@ -81,6 +82,26 @@ func (c *Chain) List() []string {
 	return names
 }
 
+// Slice returns a new chain containing the middleware from the one named from
+// through the one named to, inclusive. This can be used to apply a segment of
+// the chain on its own, e.g. to wrap it in a tracing span. Changes to the
+// returned chain do not affect this one.
+func (c *Chain) Slice(from, to string) (*Chain, error) {
+	names := c.List()
+	fi := slices.Index(names, from)
+	if fi == -1 {
+		return nil, &ErrHandlerNotFound{Name: from}
+	}
+	ti := slices.Index(names, to)
+	if ti == -1 {
+		return nil, &ErrHandlerNotFound{Name: to}
+	}
+	if fi > ti {
+		return nil, fmt.Errorf("middleware %s is after %s", from, to)
+	}
+	return &Chain{handlers: slices.Clone(c.handlers[fi : ti+1])}, nil
+}
+
 // Handler returns a new handler that applies the middleware chain to the
 // provided handler.
 func (c *Chain) Handler(h http.Handler) http.Handler {
@@ -92,3 +113,64 @@ func (c *Chain) Handler(h http.Handler) http.Handler {
 	}
 	return h
 }
+
+// HandlerExcluding returns a new handler that applies the middleware chain to
+// the provided handler, omitting any middleware with one of the given names.
+func (c *Chain) HandlerExcluding(h http.Handler, names ...string) http.Handler {
+	if len(names) == 0 {
+		return c.Handler(h)
+	}
+	if c == nil || len(c.handlers) == 0 {
+		return h
+	}
+	for i := len(c.handlers) - 1; i >= 0; i-- {
+		if slices.Contains(names, c.handlers[i].Name) {
+			continue
+		}
+		h = c.handlers[i].Handler(h)
+	}
+	return h
+}
+
+// OrderRule requires that one named middleware runs before another in a
+// chain. The rule only applies if both are present, so middleware can still be
+// removed.
+type OrderRule struct {
+	// Before is the name of the middleware that must run first.
+	Before string
+	// After is the name of the middleware that must run after Before.
+	After string
+	// Reason explains why the order matters, and is included in the error.
+	Reason string
+}
+
+// ErrOrderViolation is returned by Validate when a chain breaks a rule.
+type ErrOrderViolation struct {
+	Rule OrderRule
+}
+
+func (e *ErrOrderViolation) Error() string {
+	msg := fmt.Sprintf("middleware %s must run before %s", e.Rule.Before, e.Rule.After)
+	if e.Rule.Reason != "" {
+		msg += ": " + e.Rule.Reason
+	}
+	return msg
+}
+
+// Validate checks the chain against the rules, returning an error for each
+// rule that is broken. This can be called at startup to catch misconfigured
+// chains early.
+func (c *Chain) Validate(rules ...OrderRule) error {
+	names := c.List()
+	var errs []error
+	for _, r := range rules {
+		bi, ai := slices.Index(names, r.Before), slices.Index(names, r.After)
+		if bi == -1 || ai == -1 {
+			continue
+		}
+		if bi > ai {
+			errs = append(errs, &ErrOrderViolation{Rule: r})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -678,3 +681,194 @@ func TestChain_ComplexOperations(t *testing.T) {
 		})
 	}
 }
+
+func TestChain_HandlerExcluding(t *testing.T) {
+	tests := []struct {
+		name      string
+		chain     []string
+		exclude   []string
+		wantOrder []string
+	}{
+		{
+			name:      "no exclusions",
+			chain:     []string{"first", "second", "third"},
+			wantOrder: []string{"first", "second", "third", "final"},
+		},
+		{
+			name:      "exclude middle",
+			chain:     []string{"first", "second", "third"},
+			exclude:   []string{"second"},
+			wantOrder: []string{"first", "third", "final"},
+		},
+		{
+			name:      "exclude multiple",
+			chain:     []string{"first", "second", "third"},
+			exclude:   []string{"first", "third"},
+			wantOrder: []string{"second", "final"},
+		},
+		{
+			name:      "exclude unknown",
+			chain:     []string{"first"},
+			exclude:   []string{"other"},
+			wantOrder: []string{"first", "final"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &Chain{}
+			executionOrder := []string{}
+
+			for _, name := range tt.chain {
+				chain.Append(name, func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						executionOrder = append(executionOrder, name)
+						next.ServeHTTP(w, r)
+					})
+				})
+			}
+
+			finalHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				executionOrder = append(executionOrder, "final")
+			})
+
+			chain.HandlerExcluding(finalHandler, tt.exclude...).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			if diff := cmp.Diff(tt.wantOrder, executionOrder); diff != "" {
+				t.Errorf("Handler execution order mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChain_Slice(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  string
+		wantNames []string
+		wantErr   bool
+	}{
+		{
+			name:      "middle",
+			from:      "second",
+			to:        "third",
+			wantNames: []string{"second", "third"},
+		},
+		{
+			name:      "single",
+			from:      "first",
+			to:        "first",
+			wantNames: []string{"first"},
+		},
+		{
+			name:      "whole chain",
+			from:      "first",
+			to:        "fourth",
+			wantNames: []string{"first", "second", "third", "fourth"},
+		},
+		{
+			name:    "from not found",
+			from:    "other",
+			to:      "third",
+			wantErr: true,
+		},
+		{
+			name:    "to not found",
+			from:    "first",
+			to:      "other",
+			wantErr: true,
+		},
+		{
+			name:    "reversed",
+			from:    "third",
+			to:      "second",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &Chain{}
+			for _, name := range []string{"first", "second", "third", "fourth"} {
+				chain.Append(name, nil)
+			}
+
+			got, err := chain.Slice(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Slice() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.wantNames, got.List()); diff != "" {
+				t.Errorf("Slice() mismatch (-want +got):\n%s", diff)
+			}
+
+			// the slice is independent of the original chain.
+			got.Append("added", nil)
+			if slices.Contains(chain.List(), "added") {
+				t.Error("appending to the slice modified the original chain")
+			}
+		})
+	}
+}
+
+func TestChain_Validate(t *testing.T) {
+	rules := []OrderRule{
+		{Before: "requestid", After: "error"},
+		{Before: "error", After: "session", Reason: "panics in the session middleware are not recovered"},
+	}
+
+	tests := []struct {
+		name       string
+		handlers   []string
+		wantErrors []string
+	}{
+		{
+			name:     "valid",
+			handlers: []string{"requestid", "error", "csrf", "session"},
+		},
+		{
+			name:     "missing middleware is ignored",
+			handlers: []string{"session", "requestid"},
+		},
+		{
+			name:     "single violation",
+			handlers: []string{"requestid", "session", "error"},
+			wantErrors: []string{
+				"middleware error must run before session: panics in the session middleware are not recovered",
+			},
+		},
+		{
+			name:     "multiple violations",
+			handlers: []string{"session", "error", "requestid"},
+			wantErrors: []string{
+				"middleware requestid must run before error",
+				"middleware error must run before session: panics in the session middleware are not recovered",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &Chain{}
+			for _, name := range tt.handlers {
+				chain.Append(name, nil)
+			}
+
+			err := chain.Validate(rules...)
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if diff := cmp.Diff(tt.wantErrors, got); diff != "" {
+				t.Errorf("Validate() mismatch (-want +got):\n%s", diff)
+			}
+
+			var ov *ErrOrderViolation
+			if (err != nil) != errors.As(err, &ov) {
+				t.Errorf("want errors to be ErrOrderViolation, got %T", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"lds.li/web/httperror"
+	"lds.li/web/internal"
+)
+
+// ConcurrencyLimitOpts configures a ConcurrencyLimiter.
+type ConcurrencyLimitOpts struct {
+	// Wait is how long a request waits for a slot to become free, before it is
+	// rejected. If zero, requests over the limit are rejected immediately.
+	Wait time.Duration
+	// RetryAfter is sent in the Retry-After header of rejected requests,
+	// rounded up to whole seconds. If zero, the header is not sent.
+	RetryAfter time.Duration
+}
+
+// ConcurrencyLimiter is a middleware that limits the number of requests that
+// are handled simultaneously. Requests over the limit are rejected with a 503.
+// This differs from rate limiting, in that it caps the number of in-flight
+// requests rather than the number of requests over time. It can be used
+// globally, or on individual routes.
+type ConcurrencyLimiter struct {
+	sem      chan struct{}
+	opts     ConcurrencyLimitOpts
+	inFlight atomic.Int64
+}
+
+// ConcurrencyLimit returns a limiter that allows at most max requests to be
+// handled at once. opts may be nil.
+func ConcurrencyLimit(max int, opts *ConcurrencyLimitOpts) *ConcurrencyLimiter {
+	if max < 1 {
+		panic("concurrency limit must be at least 1")
+	}
+	l := &ConcurrencyLimiter{
+		sem: make(chan struct{}, max),
+	}
+	if opts != nil {
+		l.opts = *opts
+	}
+	return l
+}
+
+// InFlight returns the number of requests currently being handled.
+func (l *ConcurrencyLimiter) InFlight() int {
+	return int(l.inFlight.Load())
+}
+
+// Handle wraps the handler, applying the limit.
+func (l *ConcurrencyLimiter) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			l.writeUnavailable(w)
+			return
+		}
+		l.inFlight.Add(1)
+		defer func() {
+			l.inFlight.Add(-1)
+			<-l.sem
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *ConcurrencyLimiter) acquire(r *http.Request) bool {
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.opts.Wait <= 0 {
+		return false
+	}
+
+	t := time.NewTimer(l.opts.Wait)
+	defer t.Stop()
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// writeUnavailable sends the request to the error handler if one is in the
+// chain, otherwise it writes a plain 503.
+func (l *ConcurrencyLimiter) writeUnavailable(w http.ResponseWriter) {
+	if l.opts.RetryAfter > 0 {
+		secs := (l.opts.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+	}
+	if errh, ok := internal.UnwrapResponseWriterTo[httperror.ResponseWriter](w); ok {
+		errh.WriteError(httperror.New(http.StatusServiceUnavailable, "too many concurrent requests"))
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           *ConcurrencyLimitOpts
+		releaseAfter   time.Duration
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:           "reject immediately",
+			opts:           &ConcurrencyLimitOpts{RetryAfter: 1500 * time.Millisecond},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "2",
+		},
+		{
+			name:       "wait times out",
+			opts:       &ConcurrencyLimitOpts{Wait: 10 * time.Millisecond},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "wait succeeds",
+			opts:         &ConcurrencyLimitOpts{Wait: 5 * time.Second},
+			releaseAfter: 10 * time.Millisecond,
+			wantStatus:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := ConcurrencyLimit(1, tt.opts)
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			h := l.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/block" {
+					close(started)
+					<-release
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/block", nil))
+			}()
+			<-started
+
+			if got := l.InFlight(); got != 1 {
+				t.Errorf("in flight = %d, want 1", got)
+			}
+
+			if tt.releaseAfter > 0 {
+				time.AfterFunc(tt.releaseAfter, func() { close(release) })
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if tt.releaseAfter == 0 {
+				close(release)
+			}
+			wg.Wait()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("retry-after = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if got := l.InFlight(); got != 0 {
+				t.Errorf("in flight after completion = %d, want 0", got)
+			}
+		})
+	}
+}
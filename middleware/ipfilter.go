@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+
+	"lds.li/web/httperror"
+	"lds.li/web/internal"
+)
+
+// IPFilterMode controls how the allow and deny lists of an IPFilter are
+// evaluated.
+type IPFilterMode int
+
+const (
+	// IPFilterModeDenyPrecedence rejects any request matching a deny prefix,
+	// even if it also matches an allow prefix. If allow prefixes are
+	// configured, the request must also match one of them.
+	IPFilterModeDenyPrecedence IPFilterMode = iota
+	// IPFilterModeAllowListOnly only permits requests that match an allow
+	// prefix. The deny list is ignored.
+	IPFilterModeAllowListOnly
+)
+
+// IPFilter is a middleware that restricts requests based on the client IP
+// address. The address is taken from the request's RemoteAddr, so if the app
+// is served behind a proxy proxyhdrs.RemoteIP should run before this to ensure
+// the checked address is the real client, and not the proxy.
+type IPFilter struct {
+	// Allow is the list of prefixes that are permitted.
+	Allow []netip.Prefix
+	// Deny is the list of prefixes that are rejected.
+	Deny []netip.Prefix
+	// Mode controls how the lists are evaluated. Defaults to
+	// IPFilterModeDenyPrecedence.
+	Mode IPFilterMode
+}
+
+// Handle wraps the handler, rejecting requests from addresses that are not
+// permitted with a 403. Requests with an unparseable address are rejected.
+func (f *IPFilter) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := internal.ParseRemoteAddr(r.RemoteAddr)
+		if err != nil {
+			slog.WarnContext(r.Context(), "IP filter rejecting request with invalid remote address", "err", err)
+			writeForbidden(w, r)
+			return
+		}
+
+		if !f.Permitted(addr) {
+			writeForbidden(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Permitted indicates if the given address is allowed by the filter.
+func (f *IPFilter) Permitted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	switch f.Mode {
+	case IPFilterModeAllowListOnly:
+		return prefixesContain(f.Allow, addr)
+	default:
+		if prefixesContain(f.Deny, addr) {
+			return false
+		}
+		if len(f.Allow) > 0 {
+			return prefixesContain(f.Allow, addr)
+		}
+		return true
+	}
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeForbidden sends the request to the error handler if one is in the
+// chain, otherwise it writes a plain 403.
+func writeForbidden(w http.ResponseWriter, r *http.Request) {
+	if errh, ok := internal.UnwrapResponseWriterTo[httperror.ResponseWriter](w); ok {
+		errh.WriteError(httperror.ForbiddenErrf("address %s not permitted", r.RemoteAddr))
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"lds.li/web/httperror"
+)
+
+func TestIPFilter(t *testing.T) {
+	prefixes := func(ps ...string) []netip.Prefix {
+		var ret []netip.Prefix
+		for _, p := range ps {
+			ret = append(ret, netip.MustParsePrefix(p))
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name       string
+		filter     *IPFilter
+		remoteAddr string
+		wantStatus int
+	}{
+		{
+			name:       "no lists permits",
+			filter:     &IPFilter{},
+			remoteAddr: "192.0.2.1:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "allowed",
+			filter:     &IPFilter{Allow: prefixes("10.0.0.0/8")},
+			remoteAddr: "10.1.2.3:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "not in allow list",
+			filter:     &IPFilter{Allow: prefixes("10.0.0.0/8")},
+			remoteAddr: "192.0.2.1:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "denied",
+			filter:     &IPFilter{Deny: prefixes("192.0.2.0/24")},
+			remoteAddr: "192.0.2.1:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "deny takes precedence",
+			filter:     &IPFilter{Allow: prefixes("10.0.0.0/8"), Deny: prefixes("10.1.0.0/16")},
+			remoteAddr: "10.1.2.3:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allow list only ignores deny",
+			filter:     &IPFilter{Allow: prefixes("10.0.0.0/8"), Deny: prefixes("10.1.0.0/16"), Mode: IPFilterModeAllowListOnly},
+			remoteAddr: "10.1.2.3:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "allow list only with empty list rejects",
+			filter:     &IPFilter{Mode: IPFilterModeAllowListOnly},
+			remoteAddr: "10.1.2.3:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "proxyhdrs rewritten address",
+			filter:     &IPFilter{Allow: prefixes("203.0.113.0/24")},
+			remoteAddr: "203.0.113.7",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ipv6",
+			filter:     &IPFilter{Allow: prefixes("2001:db8::/32")},
+			remoteAddr: "[2001:db8::1]:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ipv4 mapped ipv6",
+			filter:     &IPFilter{Allow: prefixes("10.0.0.0/8")},
+			remoteAddr: "[::ffff:10.1.2.3]:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid address",
+			filter:     &IPFilter{},
+			remoteAddr: "not-an-ip",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.filter.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIPFilter_ErrorHandler(t *testing.T) {
+	var gotErr error
+	eh := &httperror.Handler{
+		ErrorHandler: httperror.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	}
+	f := &IPFilter{Deny: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+
+	h := eh.Handle(f.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusTeapot)
+	}
+	he, ok := gotErr.(httperror.HTTPError)
+	if !ok {
+		t.Fatalf("error handler got %T, want httperror.HTTPError", gotErr)
+	}
+	if he.Code() != http.StatusForbidden {
+		t.Errorf("error code = %d, want %d", he.Code(), http.StatusForbidden)
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// onceKey is unique per call to Once. It is not zero sized, so each pointer
+// is distinct.
+type onceKey struct{ _ byte }
+
+// Once returns middleware that applies handler at most once per request. If
+// the returned middleware is composed more than once, e.g. by a router and a
+// route both adding it, the inner applications pass the request straight
+// through to the next handler.
+func Once(handler func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	key := &onceKey{}
+	return func(next http.Handler) http.Handler {
+		wrapped := handler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(key) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), key, true))
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
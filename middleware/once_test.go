@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOnce(t *testing.T) {
+	var calls int
+	counting := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	for _, tc := range []struct {
+		name      string
+		wrap      func(http.Handler) http.Handler
+		wantCalls int
+	}{
+		{
+			name: "same middleware twice",
+			wrap: func(h http.Handler) http.Handler {
+				once := Once(counting)
+				return once(once(h))
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "separate middleware",
+			wrap: func(h http.Handler) http.Handler {
+				return Once(counting)(Once(counting)(h))
+			},
+			wantCalls: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = 0
+			var reached bool
+			h := tc.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !reached {
+				t.Error("wrapped handler not called")
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("want %d calls, got %d", tc.wantCalls, calls)
+			}
+		})
+	}
+}
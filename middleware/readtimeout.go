@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"lds.li/web/httperror"
+)
+
+// ReadTimeout is a middleware that bounds how long a client can take to send
+// the request body, to mitigate slow clients tying up connections. This
+// complements limits on handler time, by bounding the time spent waiting on
+// the client. It can be used globally, or on individual routes with different
+// timeouts, e.g. a longer one for uploads.
+//
+// The deadline is set on the connection with http.ResponseController, so the
+// ResponseWriter must support it. If it does not, the request is served
+// without a deadline. When the deadline passes, body reads fail with an
+// httperror.HTTPError with a 408 status, which handlers can return to send a
+// 408 to the client.
+type ReadTimeout struct {
+	// Timeout is how long the whole body can take to read, from when the
+	// middleware is reached. It must be positive.
+	Timeout time.Duration
+}
+
+// Handle wraps the handler, applying the timeout.
+func (rt *ReadTimeout) Handle(next http.Handler) http.Handler {
+	if rt.Timeout <= 0 {
+		panic("read timeout must be positive")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if r.Body == nil || r.Body == http.NoBody || rc.SetReadDeadline(time.Now().Add(rt.Timeout)) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// clear the deadline, so it does not apply to the next request on the
+		// connection.
+		defer func() { _ = rc.SetReadDeadline(time.Time{}) }()
+
+		r.Body = &readTimeoutBody{ReadCloser: r.Body}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readTimeoutBody maps deadline errors from reading the body to a 408.
+type readTimeoutBody struct {
+	io.ReadCloser
+}
+
+func (b *readTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, httperror.Newf(http.StatusRequestTimeout, "timed out reading request body: %w", err)
+	}
+	return n, err
+}
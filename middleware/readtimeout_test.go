@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lds.li/web/httperror"
+)
+
+func TestReadTimeout(t *testing.T) {
+	rt := &ReadTimeout{Timeout: 50 * time.Millisecond}
+	svr := httptest.NewServer(rt.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			var he httperror.HTTPError
+			if errors.As(err, &he) {
+				w.WriteHeader(he.Code())
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+	t.Cleanup(svr.Close)
+
+	tests := []struct {
+		name       string
+		body       string
+		pause      time.Duration
+		wantStatus int
+	}{
+		{
+			name:       "fast client",
+			body:       "hello",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "slow client",
+			body:       "hello",
+			pause:      500 * time.Millisecond,
+			wantStatus: http.StatusRequestTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", svr.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// send the headers and the first byte of the body, then the rest
+			// after the pause.
+			if _, err := fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: %d\r\n\r\n%s", len(tt.body), tt.body[:1]); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tt.pause)
+			_, _ = conn.Write([]byte(tt.body[1:]))
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReadTimeout_InvalidTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		t.Run(timeout.String(), func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("want panic for timeout %s", timeout)
+				}
+			}()
+			(&ReadTimeout{Timeout: timeout}).Handle(http.NotFoundHandler())
+		})
+	}
+}
+
+func TestReadTimeout_Unsupported(t *testing.T) {
+	// httptest.ResponseRecorder does not support deadlines, so the request is
+	// served without one.
+	rt := &ReadTimeout{Timeout: time.Millisecond}
+	var body string
+	h := rt.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if body != "hello" {
+		t.Errorf("want body hello, got %q", body)
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"lds.li/web/httperror"
+	"lds.li/web/internal"
+)
+
+// SecureContext is a middleware for the most sensitive routes, like payments,
+// that combines several checks in to one guard. It fails closed, rejecting
+// requests where the information needed for a check is missing. All requests
+// must be made over TLS. State changing requests (anything but GET, HEAD and
+// OPTIONS) must also:
+//
+//   - Have a Sec-Fetch-Site header of same-origin
+//   - Have an Origin header matching Origin
+//   - Pass http.CrossOriginProtection
+//
+// This is in addition to the CSRF middleware, and still applies if the route
+// is exempted from it.
+type SecureContext struct {
+	// Origin is the origin requests must come from, e.g.
+	// https://example.com. Required.
+	Origin string
+	// ForwardedProtoHeader is the trusted proxy header to check for https,
+	// e.g. X-Forwarded-Proto. If not set, only requests with a direct TLS
+	// connection are accepted.
+	ForwardedProtoHeader string
+	// OnReject is called when a request is rejected, with the reason. If not
+	// set, a 403 is sent to the error handler if one is in the chain,
+	// otherwise a plain 403 is written.
+	OnReject func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Handle wraps the handler, rejecting requests that are not from a secure
+// context.
+func (s *SecureContext) Handle(next http.Handler) http.Handler {
+	if s.Origin == "" {
+		panic("SecureContext requires an Origin")
+	}
+	origin, err := url.Parse(s.Origin)
+	if err != nil {
+		panic(fmt.Sprintf("SecureContext: invalid Origin %q: %v", s.Origin, err))
+	}
+	cop := http.NewCrossOriginProtection()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.check(cop, origin, r); err != nil {
+			s.reject(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *SecureContext) check(cop *http.CrossOriginProtection, origin *url.URL, r *http.Request) error {
+	if !internal.IsHTTPS(r, s.ForwardedProtoHeader) {
+		return errors.New("request not made over TLS")
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	if sfs := r.Header.Get("Sec-Fetch-Site"); sfs != "same-origin" {
+		return fmt.Errorf("Sec-Fetch-Site %q is not same-origin", sfs)
+	}
+	if o := r.Header.Get("Origin"); !internal.SameOrigin(o, origin) {
+		return fmt.Errorf("origin %q does not match %q", o, s.Origin)
+	}
+	if err := cop.Check(r); err != nil {
+		return fmt.Errorf("cross origin protection: %w", err)
+	}
+	return nil
+}
+
+func (s *SecureContext) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if s.OnReject != nil {
+		s.OnReject(w, r, err)
+		return
+	}
+	if errh, ok := internal.UnwrapResponseWriterTo[httperror.ResponseWriter](w); ok {
+		errh.WriteError(httperror.ForbiddenErrf("insecure context: %v", err))
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecureContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		sc         *SecureContext
+		method     string
+		url        string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "safe method over tls",
+			sc:         &SecureContext{Origin: "https://example.com"},
+			method:     http.MethodGet,
+			url:        "https://example.com/pay",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "safe method without tls",
+			sc:         &SecureContext{Origin: "https://example.com"},
+			method:     http.MethodGet,
+			url:        "http://example.com/pay",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "same origin post",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "https://example.com/pay",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+				"Origin":         "https://example.com",
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "forwarded proto",
+			sc:     &SecureContext{Origin: "https://example.com", ForwardedProtoHeader: "X-Forwarded-Proto"},
+			method: http.MethodPost,
+			url:    "http://example.com/pay",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "https",
+				"Sec-Fetch-Site":    "same-origin",
+				"Origin":            "https://example.com",
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "forwarded proto not trusted",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "http://example.com/pay",
+			headers: map[string]string{
+				"X-Forwarded-Proto": "https",
+				"Sec-Fetch-Site":    "same-origin",
+				"Origin":            "https://example.com",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "missing sec-fetch-site",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "https://example.com/pay",
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "same site is not enough",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "https://example.com/pay",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-site",
+				"Origin":         "https://example.com",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "missing origin",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "https://example.com/pay",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "mismatched origin",
+			sc:     &SecureContext{Origin: "https://example.com"},
+			method: http.MethodPost,
+			url:    "https://example.com/pay",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+				"Origin":         "https://evil.example",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "custom rejection",
+			sc: &SecureContext{
+				Origin: "https://example.com",
+				OnReject: func(w http.ResponseWriter, r *http.Request, err error) {
+					http.Error(w, err.Error(), http.StatusTeapot)
+				},
+			},
+			method:     http.MethodPost,
+			url:        "https://example.com/pay",
+			wantStatus: http.StatusTeapot,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.sc.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RedirectSlashes is a middleware that redirects requests to a canonical form
+// of the path, either with or without a trailing slash. This avoids the same
+// content being served under two URLs. GET and HEAD requests are redirected
+// with a 301, other methods are redirected with a 308 so the method and body
+// are preserved.
+type RedirectSlashes struct {
+	// AddSlash redirects paths without a trailing slash to the path with one.
+	// By default, trailing slashes are removed.
+	AddSlash bool
+
+	bypassMux *http.ServeMux
+}
+
+// AllowBypass registers a http.ServeMux pattern that will not be redirected.
+// This is useful for things like static files when AddSlash is set.
+func (s *RedirectSlashes) AllowBypass(pattern string) {
+	if s.bypassMux == nil {
+		s.bypassMux = http.NewServeMux()
+	}
+	s.bypassMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		// This handler is never actually called, we just use it for pattern matching
+	})
+}
+
+// Handle wraps the handler, redirecting paths that are not in the canonical
+// form.
+func (s *RedirectSlashes) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.EscapedPath()
+		if p == "" || p == "/" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var canonical string
+		if s.AddSlash {
+			canonical = p
+			if !strings.HasSuffix(p, "/") {
+				canonical = p + "/"
+			}
+		} else {
+			canonical = strings.TrimRight(p, "/")
+			if canonical == "" {
+				canonical = "/"
+			}
+		}
+
+		if canonical == p {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.bypassMux != nil {
+			if _, pattern := s.bypassMux.Handler(r); pattern != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Collapse leading slashes, so we never emit a protocol-relative
+		// redirect to another host.
+		canonical = "/" + strings.TrimLeft(canonical, "/")
+		if r.URL.RawQuery != "" {
+			canonical += "?" + r.URL.RawQuery
+		}
+
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, canonical, code)
+	})
+}
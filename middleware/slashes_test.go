@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectSlashes(t *testing.T) {
+	tests := []struct {
+		name         string
+		addSlash     bool
+		bypass       string
+		method       string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:       "root is untouched",
+			method:     http.MethodGet,
+			target:     "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "canonical path is untouched",
+			method:     http.MethodGet,
+			target:     "/users",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "remove slash",
+			method:       http.MethodGet,
+			target:       "/users/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/users",
+		},
+		{
+			name:         "remove multiple slashes preserving query",
+			method:       http.MethodGet,
+			target:       "/users//?page=2",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/users?page=2",
+		},
+		{
+			name:         "post uses 308",
+			method:       http.MethodPost,
+			target:       "/users/",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "/users",
+		},
+		{
+			name:         "add slash",
+			addSlash:     true,
+			method:       http.MethodGet,
+			target:       "/users?page=2",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/users/?page=2",
+		},
+		{
+			name:       "add slash canonical is untouched",
+			addSlash:   true,
+			method:     http.MethodGet,
+			target:     "/users/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "bypass",
+			addSlash:   true,
+			bypass:     "/static/",
+			method:     http.MethodGet,
+			target:     "/static/app.js",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "no protocol relative redirect",
+			method:       http.MethodGet,
+			target:       "//evil.example.com/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/evil.example.com",
+		},
+		{
+			name:         "escaped path preserved",
+			method:       http.MethodGet,
+			target:       "/a%2Fb/",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "/a%2Fb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &RedirectSlashes{AddSlash: tt.addSlash}
+			if tt.bypass != "" {
+				rs.AllowBypass(tt.bypass)
+			}
+
+			h := rs.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.target, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
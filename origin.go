@@ -0,0 +1,20 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+
+	"lds.li/web/internal"
+)
+
+// IsSameOrigin reports if the request's Origin header matches the app's
+// origin, the scheme, host and port of base, e.g. Config.BaseURL. Comparing
+// against the configured URL rather than the request's Host keeps the result
+// the same behind proxies that rewrite the host or terminate TLS. Default ports
+// are normalized, so https://example.com matches https://example.com:443.
+//
+// Browsers omit the Origin header for some requests, e.g. same-origin GET
+// navigations, so this returns false if it is missing.
+func IsSameOrigin(r *http.Request, base *url.URL) bool {
+	return internal.SameOrigin(r.Header.Get("Origin"), base)
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestIsSameOrigin(t *testing.T) {
+	base, _ := url.Parse("https://example.com/app/")
+
+	for _, tc := range []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "match", origin: "https://example.com", want: true},
+		{name: "default port", origin: "https://example.com:443", want: true},
+		{name: "host case", origin: "https://EXAMPLE.com", want: true},
+		{name: "missing", origin: ""},
+		{name: "null", origin: "null"},
+		{name: "scheme", origin: "http://example.com"},
+		{name: "port", origin: "https://example.com:8443"},
+		{name: "subdomain", origin: "https://www.example.com"},
+		{name: "suffix", origin: "https://example.com.evil.com"},
+		{name: "userinfo", origin: "https://user@example.com"},
+		{name: "invalid", origin: "https://exa mple.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if got := IsSameOrigin(r, base); got != tc.want {
+				t.Errorf("IsSameOrigin(%q) = %v, want %v", tc.origin, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("non-default port", func(t *testing.T) {
+		base, _ := url.Parse("http://localhost:8080")
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Origin", "http://localhost:8080")
+		if !IsSameOrigin(r, base) {
+			t.Error("want same origin")
+		}
+		r.Header.Set("Origin", "http://localhost")
+		if IsSameOrigin(r, base) {
+			t.Error("want different origin")
+		}
+	})
+}
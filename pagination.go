@@ -0,0 +1,88 @@
+package web
+
+import (
+	"math"
+	"strconv"
+
+	"lds.li/web/httperror"
+)
+
+// Defaults for PaginationOpts.
+const (
+	DefaultPerPage    = 20
+	DefaultMaxPerPage = 100
+)
+
+// PaginationOpts configures how Request.Pagination parses the query.
+type PaginationOpts struct {
+	// DefaultPerPage is used when the request does not specify a page size.
+	// Defaults to DefaultPerPage.
+	DefaultPerPage int
+	// MaxPerPage is the largest page size a request can ask for, larger
+	// values are clamped to it. Defaults to DefaultMaxPerPage.
+	MaxPerPage int
+	// PageParam is the query parameter for the page number. Defaults to
+	// "page".
+	PageParam string
+	// PerPageParam is the query parameter for the page size. Defaults to
+	// "per_page".
+	PerPageParam string
+}
+
+// Pagination is a page of results requested by the client. Pages are numbered
+// from 1.
+type Pagination struct {
+	Page    int
+	PerPage int
+	// Offset is the number of results to skip, for use in e.g. a SQL OFFSET.
+	Offset int
+	// Limit is the number of results to return, for use in e.g. a SQL LIMIT.
+	Limit int
+}
+
+// Pagination parses the page and page size from the request query. Values
+// out of range are clamped, e.g. a page of 0 is treated as the first page. A
+// value that is not a number returns a 400 error.
+func (b *Request) Pagination(opts PaginationOpts) (Pagination, error) {
+	if opts.DefaultPerPage <= 0 {
+		opts.DefaultPerPage = DefaultPerPage
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = DefaultMaxPerPage
+	}
+	if opts.PageParam == "" {
+		opts.PageParam = "page"
+	}
+	if opts.PerPageParam == "" {
+		opts.PerPageParam = "per_page"
+	}
+
+	q := b.r.URL.Query()
+
+	page, err := queryInt(q.Get(opts.PageParam), 1)
+	if err != nil {
+		return Pagination{}, httperror.BadRequestErrf("invalid %s: %v", opts.PageParam, err)
+	}
+	perPage, err := queryInt(q.Get(opts.PerPageParam), min(opts.DefaultPerPage, opts.MaxPerPage))
+	if err != nil {
+		return Pagination{}, httperror.BadRequestErrf("invalid %s: %v", opts.PerPageParam, err)
+	}
+
+	perPage = min(max(perPage, 1), opts.MaxPerPage)
+	// bound the page so the offset can not overflow.
+	page = min(max(page, 1), math.MaxInt/perPage)
+
+	return Pagination{
+		Page:    page,
+		PerPage: perPage,
+		Offset:  (page - 1) * perPage,
+		Limit:   perPage,
+	}, nil
+}
+
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
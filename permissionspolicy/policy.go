@@ -0,0 +1,189 @@
+// Package permissionspolicy builds Permissions-Policy headers, which control
+// the browser features a page and its frames can use.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Permissions-Policy
+package permissionspolicy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Allowlist members that have a special meaning. Other members are treated as
+// origins, e.g. https://example.com.
+const (
+	// Self allows the feature for the page's own origin.
+	Self = "self"
+	// All allows the feature for all origins.
+	All = "*"
+)
+
+// Default disables powerful features that most apps do not use. Apps that
+// need one can re-enable it with With, e.g.
+// permissionspolicy.Default.With(permissionspolicy.Camera(permissionspolicy.Self))
+var Default = Policy{
+	Accelerometer(),
+	Camera(),
+	DisplayCapture(),
+	Geolocation(),
+	Gyroscope(),
+	HID(),
+	Magnetometer(),
+	Microphone(),
+	MIDI(),
+	Payment(),
+	Serial(),
+	USB(),
+}
+
+// Directive sets the allowlist for a single feature. An empty allowlist
+// disables the feature.
+type Directive struct {
+	feature   string
+	allowlist []string
+}
+
+// Feature creates a directive for the named feature, for features that do not
+// have a helper.
+func Feature(name string, allowlist ...string) Directive {
+	return Directive{feature: name, allowlist: allowlist}
+}
+
+// Accelerometer creates a directive for the accelerometer feature.
+func Accelerometer(allowlist ...string) Directive {
+	return Feature("accelerometer", allowlist...)
+}
+
+// Autoplay creates a directive for the autoplay feature.
+func Autoplay(allowlist ...string) Directive {
+	return Feature("autoplay", allowlist...)
+}
+
+// Camera creates a directive for the camera feature.
+func Camera(allowlist ...string) Directive {
+	return Feature("camera", allowlist...)
+}
+
+// DisplayCapture creates a directive for the display-capture feature.
+func DisplayCapture(allowlist ...string) Directive {
+	return Feature("display-capture", allowlist...)
+}
+
+// Fullscreen creates a directive for the fullscreen feature.
+func Fullscreen(allowlist ...string) Directive {
+	return Feature("fullscreen", allowlist...)
+}
+
+// Geolocation creates a directive for the geolocation feature.
+func Geolocation(allowlist ...string) Directive {
+	return Feature("geolocation", allowlist...)
+}
+
+// Gyroscope creates a directive for the gyroscope feature.
+func Gyroscope(allowlist ...string) Directive {
+	return Feature("gyroscope", allowlist...)
+}
+
+// HID creates a directive for the hid feature.
+func HID(allowlist ...string) Directive {
+	return Feature("hid", allowlist...)
+}
+
+// Magnetometer creates a directive for the magnetometer feature.
+func Magnetometer(allowlist ...string) Directive {
+	return Feature("magnetometer", allowlist...)
+}
+
+// Microphone creates a directive for the microphone feature.
+func Microphone(allowlist ...string) Directive {
+	return Feature("microphone", allowlist...)
+}
+
+// MIDI creates a directive for the midi feature.
+func MIDI(allowlist ...string) Directive {
+	return Feature("midi", allowlist...)
+}
+
+// Payment creates a directive for the payment feature.
+func Payment(allowlist ...string) Directive {
+	return Feature("payment", allowlist...)
+}
+
+// PictureInPicture creates a directive for the picture-in-picture feature.
+func PictureInPicture(allowlist ...string) Directive {
+	return Feature("picture-in-picture", allowlist...)
+}
+
+// PublicKeyCredentialsGet creates a directive for the publickey-credentials-get feature.
+func PublicKeyCredentialsGet(allowlist ...string) Directive {
+	return Feature("publickey-credentials-get", allowlist...)
+}
+
+// Serial creates a directive for the serial feature.
+func Serial(allowlist ...string) Directive {
+	return Feature("serial", allowlist...)
+}
+
+// USB creates a directive for the usb feature.
+func USB(allowlist ...string) Directive {
+	return Feature("usb", allowlist...)
+}
+
+func (d Directive) String() string {
+	if len(d.allowlist) == 1 && d.allowlist[0] == All {
+		return d.feature + "=*"
+	}
+	members := make([]string, len(d.allowlist))
+	for i, m := range d.allowlist {
+		switch m {
+		case Self, All, "src":
+			members[i] = m
+		default:
+			members[i] = `"` + m + `"`
+		}
+	}
+	return d.feature + "=(" + strings.Join(members, " ") + ")"
+}
+
+// Policy is a set of directives, that can be rendered as a Permissions-Policy
+// header.
+type Policy []Directive
+
+// With returns a copy of the policy with the directives added, replacing any
+// existing directives for the same features.
+func (p Policy) With(directives ...Directive) Policy {
+	ret := make(Policy, 0, len(p)+len(directives))
+	for _, d := range p {
+		replaced := false
+		for _, nd := range directives {
+			if nd.feature == d.feature {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ret = append(ret, d)
+		}
+	}
+	return append(ret, directives...)
+}
+
+// String renders the policy as a header value.
+func (p Policy) String() string {
+	ds := make([]string, len(p))
+	for i, d := range p {
+		ds[i] = d.String()
+	}
+	return strings.Join(ds, ", ")
+}
+
+// Handle wraps the handler, setting the Permissions-Policy header on all
+// responses. When using the web server, set the policy on
+// secheaders.Handler.PermissionsPolicy instead.
+func (p Policy) Handle(next http.Handler) http.Handler {
+	v := p.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Permissions-Policy", v)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package permissionspolicy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{
+			name:   "disabled",
+			policy: Policy{Camera(), Microphone()},
+			want:   "camera=(), microphone=()",
+		},
+		{
+			name:   "allowlists",
+			policy: Policy{Geolocation(Self, "https://maps.example.com"), Fullscreen(All)},
+			want:   `geolocation=(self "https://maps.example.com"), fullscreen=*`,
+		},
+		{
+			name:   "custom feature",
+			policy: Policy{Feature("compute-pressure", Self)},
+			want:   "compute-pressure=(self)",
+		},
+		{
+			name:   "with replaces",
+			policy: Policy{Camera(), Microphone()}.With(Camera(Self), Payment(Self)),
+			want:   "microphone=(), camera=(self), payment=(self)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.String(); got != tc.want {
+				t.Errorf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultWithDoesNotModify(t *testing.T) {
+	before := Default.String()
+	_ = Default.With(Camera(Self))
+	if Default.String() != before {
+		t.Error("With modified the default policy")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	h := Policy{Camera()}.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Permissions-Policy"); got != "camera=()" {
+		t.Errorf("want camera=(), got %q", got)
+	}
+}
@@ -1,13 +1,20 @@
 package web
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"lds.li/web/form"
+	"lds.li/web/httperror"
+	"lds.li/web/internal"
 	"lds.li/web/session"
 )
 
@@ -40,6 +47,42 @@ func (b *Request) PathValue(name string) string {
 	return b.r.PathValue(name)
 }
 
+// PathInt returns the named path value parsed as an int. If it can not be
+// parsed, a 400 error is returned.
+func (b *Request) PathInt(name string) (int, error) {
+	return PathValueAs(b, name, strconv.Atoi)
+}
+
+// PathInt64 returns the named path value parsed as an int64. If it can not be
+// parsed, a 400 error is returned.
+func (b *Request) PathInt64(name string) (int64, error) {
+	return PathValueAs(b, name, func(s string) (int64, error) {
+		return strconv.ParseInt(s, 10, 64)
+	})
+}
+
+// PathValueAs returns the named path value converted with parse, e.g.
+// PathValueAs(br, "id", uuid.Parse). If parse fails, a 400 error is returned.
+func PathValueAs[T any](b *Request, name string, parse func(string) (T, error)) (T, error) {
+	v, err := parse(b.r.PathValue(name))
+	if err != nil {
+		var zero T
+		return zero, httperror.BadRequestErrf("invalid path value %s: %v", name, err)
+	}
+	return v, nil
+}
+
+// ClientIP returns the address of the client making the request. If the
+// request was re-written by proxyhdrs.RemoteIP, this is the forwarded address.
+// If the address can not be parsed, an invalid netip.Addr is returned.
+func (b *Request) ClientIP() netip.Addr {
+	addr, err := internal.ParseRemoteAddr(b.r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
 func (b *Request) UnmarshalJSONBody(target any) error {
 	if !isJSONContentType(b.r.Header.Get("content-type")) {
 		return fmt.Errorf("can not unmarshal non-json content type %s body", b.r.Header.Get("content-type"))
@@ -50,16 +93,179 @@ func (b *Request) UnmarshalJSONBody(target any) error {
 	return nil
 }
 
+// StreamJSONArray decodes a body containing a top-level JSON array one element
+// at a time, calling fn with each element. Only one element is held in memory
+// at a time, so large arrays can be processed with bounded memory. The body is
+// limited to DefaultMaxStreamBytes, or the limit set with WithMaxStreamBytes,
+// and larger bodies fail with a 413. Data after the array is an error. If fn
+// returns an error, decoding stops and the error is returned.
+func (b *Request) StreamJSONArray(fn func(json.RawMessage) error) error {
+	if !isJSONContentType(b.r.Header.Get("content-type")) {
+		return fmt.Errorf("can not unmarshal non-json content type %s body", b.r.Header.Get("content-type"))
+	}
+
+	return bodyLimitError(streamJSONArray(json.NewDecoder(b.streamBody()), fn))
+}
+
+// streamJSONArray decodes a top-level JSON array from dec, calling fn with
+// each element.
+func streamJSONArray(dec *json.Decoder, fn func(json.RawMessage) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("reading start of array: %w", err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("body is not a JSON array, starts with %v", tok)
+	}
+
+	for i := 0; dec.More(); i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("decoding array element %d: %w", i, err)
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("reading end of array: %w", err)
+	}
+
+	switch _, err := dec.Token(); {
+	case err == io.EOF:
+		return nil
+	case err != nil:
+		return fmt.Errorf("reading after end of array: %w", err)
+	default:
+		return errors.New("body contains data after the JSON array")
+	}
+}
+
+// DefaultMaxStreamBytes is the default limit on the size of bodies decoded
+// with StreamJSONArray and DecodeEvents.
+const DefaultMaxStreamBytes = 10 << 20
+
+// streamBody returns the request body, limited for the streaming decoders.
+func (b *Request) streamBody() io.Reader {
+	limit := int64(DefaultMaxStreamBytes)
+	if l, ok := b.r.Context().Value(maxStreamBytesCtxKey{}).(int64); ok {
+		limit = l
+	}
+	if limit <= 0 {
+		return b.r.Body
+	}
+	return http.MaxBytesReader(nil, b.r.Body, limit)
+}
+
+// DecodeEvents decodes a body of one or more JSON events, calling fn with
+// each. The format is chosen by the content type: application/x-ndjson bodies
+// yield each newline delimited value, and JSON bodies yield each element of a
+// top-level array, or the body itself if it is a single value. This suits
+// webhooks that send either a single event or a batch. Events are decoded one
+// at a time, and the body is limited to DefaultMaxStreamBytes, or the limit
+// set with WithMaxStreamBytes. Larger bodies fail with a 413. If fn returns an
+// error, decoding stops and the error is returned.
+func (b *Request) DecodeEvents(fn func(json.RawMessage) error) error {
+	ct := b.r.Header.Get("content-type")
+	body := b.streamBody()
+
+	var err error
+	switch {
+	case isNDJSONContentType(ct):
+		err = decodeNDJSON(json.NewDecoder(body), fn)
+	case isJSONContentType(ct):
+		err = decodeJSONEvents(bufio.NewReader(body), fn)
+	default:
+		return fmt.Errorf("can not decode events from content type %s body", ct)
+	}
+	return bodyLimitError(err)
+}
+
+// decodeNDJSON calls fn with each value in a stream of newline delimited JSON.
+func decodeNDJSON(dec *json.Decoder, fn func(json.RawMessage) error) error {
+	for i := 0; ; i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding event %d: %w", i, err)
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeJSONEvents calls fn with each element of a top-level JSON array, or
+// with the single value if it is not an array.
+func decodeJSONEvents(br *bufio.Reader, fn func(json.RawMessage) error) error {
+	// peek at the first value byte, leaving it for the decoder.
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("reading event body: %w", err)
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		if err := br.UnreadByte(); err != nil {
+			return err
+		}
+		if c == '[' {
+			return streamJSONArray(json.NewDecoder(br), fn)
+		}
+		break
+	}
+
+	dec := json.NewDecoder(br)
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return fmt.Errorf("decoding event: %w", err)
+	}
+	switch _, err := dec.Token(); {
+	case err == io.EOF:
+		return fn(raw)
+	case err != nil:
+		return fmt.Errorf("reading after event: %w", err)
+	default:
+		return errors.New("body contains data after the JSON event")
+	}
+}
+
+func isNDJSONContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "application/x-ndjson") ||
+		strings.HasPrefix(contentType, "application/ndjson")
+}
+
 // DecodeForm unpacks the POST form into the target. The target type should be
-// tagged appropriately.
+// tagged appropriately. Form fields that do not map to the target are ignored.
 func (b *Request) DecodeForm(target any) error {
+	return b.decodeForm(target, false)
+}
+
+// DecodeFormStrict is like DecodeForm, but returns a bad request error listing
+// any form fields that do not map to a field on the target. This catches
+// misspelled or renamed fields, like json.Decoder's DisallowUnknownFields.
+func (b *Request) DecodeFormStrict(target any) error {
+	return b.decodeForm(target, true)
+}
+
+func (b *Request) decodeForm(target any, strict bool) error {
 	if !strings.HasPrefix(b.r.Header.Get("content-type"), "application/x-www-form-urlencoded") &&
 		!strings.HasPrefix(b.r.Header.Get("content-type"), "multipart/form-data") {
 		return fmt.Errorf("request is not form data")
 	}
 
 	if err := b.r.ParseForm(); err != nil {
-		return fmt.Errorf("parsing request form: %w", err)
+		return bodyLimitError(fmt.Errorf("parsing request form: %w", err))
+	}
+
+	if strict {
+		if unknown := form.UnknownFields(b.r.PostForm, target); len(unknown) > 0 {
+			return httperror.BadRequestErrf("unknown form fields: %s", strings.Join(unknown, ", "))
+		}
 	}
 
 	if err := form.Decode(b.r.PostForm, target); err != nil {
@@ -73,3 +279,31 @@ func (b *Request) DecodeForm(target any) error {
 func (b *Request) RawRequest() *http.Request {
 	return b.r
 }
+
+// limitFormBody limits the size of form request bodies, so they are not read
+// in to memory unbounded when parsed. The limit from WithMaxFormBytes takes
+// precedence over the default.
+func limitFormBody(w http.ResponseWriter, r *http.Request, defaultLimit int64) {
+	limit := defaultLimit
+	if l, ok := r.Context().Value(maxFormBytesCtxKey{}).(int64); ok {
+		limit = l
+	}
+	if limit <= 0 || r.Body == nil {
+		return
+	}
+	ct := r.Header.Get("content-type")
+	if !strings.HasPrefix(ct, "application/x-www-form-urlencoded") &&
+		!strings.HasPrefix(ct, "multipart/form-data") {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+}
+
+// bodyLimitError maps errors from reading an oversized body to a 413.
+func bodyLimitError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return httperror.Newf(http.StatusRequestEntityTooLarge, "request body exceeds %d bytes", mbe.Limit)
+	}
+	return err
+}
@@ -0,0 +1,457 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"lds.li/web/httperror"
+	"lds.li/web/proxyhdrs"
+)
+
+func TestRequestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       netip.Addr
+	}{
+		{
+			name:       "host and port",
+			remoteAddr: "192.0.2.1:1234",
+			want:       netip.MustParseAddr("192.0.2.1"),
+		},
+		{
+			name:       "ipv6 host and port",
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       netip.MustParseAddr("2001:db8::1"),
+		},
+		{
+			name:       "proxyhdrs rewritten",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        "203.0.113.9",
+			want:       netip.MustParseAddr("203.0.113.9"),
+		},
+		{
+			name:       "malformed",
+			remoteAddr: "garbage",
+			want:       netip.Addr{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set(proxyhdrs.ForwardedIPHeaderXFF, tt.xff)
+			}
+
+			var got netip.Addr
+			rip := &proxyhdrs.RemoteIP{ForwardedIPHeader: proxyhdrs.ForwardedIPHeaderXFF}
+			rip.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = NewRequestFrom(r).ClientIP()
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestStreamJSONArray(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int64
+		want        []string
+		wantErr     bool
+		wantCode    int
+	}{
+		{
+			name:        "array",
+			contentType: "application/json",
+			body:        `[{"id":1}, {"id":2}, "three"]`,
+			want:        []string{`{"id":1}`, `{"id":2}`, `"three"`},
+		},
+		{
+			name:        "empty array",
+			contentType: "application/json",
+			body:        `[]`,
+		},
+		{
+			name:        "not an array",
+			contentType: "application/json",
+			body:        `{"id":1}`,
+			wantErr:     true,
+		},
+		{
+			name:        "truncated",
+			contentType: "application/json",
+			body:        `[{"id":1}, {"id":`,
+			want:        []string{`{"id":1}`},
+			wantErr:     true,
+		},
+		{
+			name:        "data after array",
+			contentType: "application/json",
+			body:        `[1] [2]`,
+			want:        []string{`1`},
+			wantErr:     true,
+		},
+		{
+			name:        "extra closing bracket",
+			contentType: "application/json",
+			body:        `[1]]`,
+			want:        []string{`1`},
+			wantErr:     true,
+		},
+		{
+			name:        "trailing whitespace",
+			contentType: "application/json",
+			body:        "[1]\n",
+			want:        []string{`1`},
+		},
+		{
+			name:        "too large",
+			contentType: "application/json",
+			body:        `[{"id":1}, {"id":2}]`,
+			maxBytes:    12,
+			want:        []string{`{"id":1}`},
+			wantErr:     true,
+			wantCode:    http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			body:        `[]`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.maxBytes != 0 {
+				req = WithMaxStreamBytes(tt.maxBytes)(req)
+			}
+
+			var got []string
+			err := NewRequestFrom(req).StreamJSONArray(func(raw json.RawMessage) error {
+				got = append(got, string(raw))
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want err %t, got: %v", tt.wantErr, err)
+			}
+			if tt.wantCode != 0 {
+				var he httperror.HTTPError
+				if !errors.As(err, &he) || he.Code() != tt.wantCode {
+					t.Errorf("want code %d, got: %v", tt.wantCode, err)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRequestPathValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantInt  int
+		wantCode int
+	}{
+		{
+			name:    "valid",
+			path:    "/users/42",
+			wantInt: 42,
+		},
+		{
+			name:     "invalid",
+			path:     "/users/abc",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotInt   int
+				gotInt64 int64
+				gotErr   error
+			)
+			mux := http.NewServeMux()
+			mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				br := NewRequestFrom(r)
+				gotInt, gotErr = br.PathInt("id")
+				var err error
+				gotInt64, err = br.PathInt64("id")
+				if (err != nil) != (gotErr != nil) {
+					t.Errorf("PathInt64 error %v does not match PathInt error %v", err, gotErr)
+				}
+			})
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if tt.wantCode == 0 {
+				if gotErr != nil {
+					t.Fatal(gotErr)
+				}
+				if gotInt != tt.wantInt || gotInt64 != int64(tt.wantInt) {
+					t.Errorf("want %d, got int %d int64 %d", tt.wantInt, gotInt, gotInt64)
+				}
+				return
+			}
+			var he httperror.HTTPError
+			if !errors.As(gotErr, &he) || he.Code() != tt.wantCode {
+				t.Errorf("want HTTP error with code %d, got: %v", tt.wantCode, gotErr)
+			}
+		})
+	}
+}
+
+func TestRequestPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		opts     PaginationOpts
+		want     Pagination
+		wantCode int
+	}{
+		{
+			name: "defaults",
+			want: Pagination{Page: 1, PerPage: DefaultPerPage, Offset: 0, Limit: DefaultPerPage},
+		},
+		{
+			name:  "page and size",
+			query: "page=3&per_page=10",
+			want:  Pagination{Page: 3, PerPage: 10, Offset: 20, Limit: 10},
+		},
+		{
+			name:  "clamped",
+			query: "page=-1&per_page=1000",
+			want:  Pagination{Page: 1, PerPage: DefaultMaxPerPage, Offset: 0, Limit: DefaultMaxPerPage},
+		},
+		{
+			name:  "zero size",
+			query: "per_page=0",
+			want:  Pagination{Page: 1, PerPage: 1, Offset: 0, Limit: 1},
+		},
+		{
+			name:  "custom opts",
+			query: "p=2&n=60",
+			opts:  PaginationOpts{DefaultPerPage: 5, MaxPerPage: 50, PageParam: "p", PerPageParam: "n"},
+			want:  Pagination{Page: 2, PerPage: 50, Offset: 50, Limit: 50},
+		},
+		{
+			name:     "non-numeric page",
+			query:    "page=abc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "non-numeric size",
+			query:    "per_page=ten",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := NewRequestFrom(httptest.NewRequest(http.MethodGet, "/items?"+tt.query, nil))
+			got, err := br.Pagination(tt.opts)
+
+			if tt.wantCode != 0 {
+				var he httperror.HTTPError
+				if !errors.As(err, &he) || he.Code() != tt.wantCode {
+					t.Errorf("want HTTP error with code %d, got: %v", tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRequestDecodeFormStrict(t *testing.T) {
+	type target struct {
+		Name string `form:"name"`
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		strict     bool
+		want       target
+		wantStatus int
+	}{
+		{
+			name: "lenient ignores unknown",
+			body: "name=a&nmae=b",
+			want: target{Name: "a"},
+		},
+		{
+			name:   "strict known",
+			body:   "name=a",
+			strict: true,
+			want:   target{Name: "a"},
+		},
+		{
+			name:       "strict unknown",
+			body:       "name=a&nmae=b",
+			strict:     true,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			var got target
+			var err error
+			if tt.strict {
+				err = NewRequestFrom(req).DecodeFormStrict(&got)
+			} else {
+				err = NewRequestFrom(req).DecodeForm(&got)
+			}
+			if tt.wantStatus != 0 {
+				var herr httperror.HTTPError
+				if !errors.As(err, &herr) || herr.Code() != tt.wantStatus {
+					t.Fatalf("want status %d, got: %v", tt.wantStatus, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRequestDecodeEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		maxBytes    int64
+		want        []string
+		wantErr     bool
+		wantCode    int
+	}{
+		{
+			name:        "single object",
+			contentType: "application/json",
+			body:        ` {"id":1}`,
+			want:        []string{`{"id":1}`},
+		},
+		{
+			name:        "array",
+			contentType: "application/json; charset=utf-8",
+			body:        "\n[{\"id\":1}, {\"id\":2}]",
+			want:        []string{`{"id":1}`, `{"id":2}`},
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        "{\"id\":1}\n\n{\"id\":2}\n",
+			want:        []string{`{"id":1}`, `{"id":2}`},
+		},
+		{
+			name:        "empty ndjson",
+			contentType: "application/x-ndjson",
+		},
+		{
+			name:        "data after object",
+			contentType: "application/json",
+			body:        `{"id":1} {"id":2}`,
+			wantErr:     true,
+		},
+		{
+			name:        "closing bracket after object",
+			contentType: "application/json",
+			body:        `{"id":1}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "closing brace after object",
+			contentType: "application/json",
+			body:        `{"id":1}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "whitespace after object",
+			contentType: "application/json",
+			body:        "{\"id\":1}\n ",
+			want:        []string{`{"id":1}`},
+		},
+		{
+			name:        "invalid ndjson line",
+			contentType: "application/x-ndjson",
+			body:        "{\"id\":1}\n{\"id\":\n",
+			want:        []string{`{"id":1}`},
+			wantErr:     true,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			body:        `{}`,
+			wantErr:     true,
+		},
+		{
+			name:        "too large",
+			contentType: "application/x-ndjson",
+			body:        "{\"id\":1}\n{\"id\":2}\n",
+			maxBytes:    12,
+			want:        []string{`{"id":1}`},
+			wantErr:     true,
+			wantCode:    http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.maxBytes != 0 {
+				req = WithMaxStreamBytes(tt.maxBytes)(req)
+			}
+
+			var got []string
+			err := NewRequestFrom(req).DecodeEvents(func(raw json.RawMessage) error {
+				got = append(got, string(raw))
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want err %t, got: %v", tt.wantErr, err)
+			}
+			if tt.wantCode != 0 {
+				var he httperror.HTTPError
+				if !errors.As(err, &he) || he.Code() != tt.wantCode {
+					t.Errorf("want code %d, got: %v", tt.wantCode, err)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -1,5 +1,10 @@
 // Package requestid allows the generation and propagation of request ID's via
 // context, and HTTP calls.
+//
+// Middleware ensures inbound requests have an ID in their context. Outbound
+// calls made with a client using Transport (see HTTPClientWithRequestID) carry
+// the ID from the request context in the X-Request-ID header, so a service
+// that trusts that header continues with the same ID.
 package requestid
 
 // RequestIDHeader is the HTTP header name that we pass the request ID in.
@@ -73,6 +73,56 @@ func TestUntrustedHTTP(t *testing.T) {
 	}
 }
 
+func TestHTTPCallChain(t *testing.T) {
+	// downstream echoes the request ID it sees.
+	downstream := httptest.NewServer((&Middleware{TrustedHeaders: []string{RequestIDHeader}}).Handler(http.HandlerFunc(echoRid)))
+	t.Cleanup(downstream.Close)
+
+	client := &http.Client{Transport: &Transport{}}
+
+	// upstream calls downstream with the inbound request's context, returning
+	// both its own ID and the one downstream saw.
+	upstream := httptest.NewServer((&Middleware{}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+		if err != nil {
+			panic(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		var dr ridResp
+		if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+			panic(err)
+		}
+		id, _ := FromContext(r.Context())
+		if err := json.NewEncoder(w).Encode(map[string]string{
+			"upstream":   id,
+			"downstream": dr.RequestID,
+		}); err != nil {
+			panic(err)
+		}
+	})))
+	t.Cleanup(upstream.Close)
+
+	resp, err := http.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["upstream"] == "" {
+		t.Fatal("wanted upstream id, but got none")
+	}
+	if got["downstream"] != got["upstream"] {
+		t.Errorf("wanted id %s propagated downstream, got: %s", got["upstream"], got["downstream"])
+	}
+}
+
 type ridResp struct {
 	RequestID string `json:"requestID,omitempty"`
 }
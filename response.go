@@ -1,9 +1,12 @@
 package web
 
 import (
+	"context"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type BrowserResponse interface {
@@ -11,6 +14,15 @@ type BrowserResponse interface {
 	getSettableCookies() []*http.Cookie
 }
 
+// ResponseWriterTo is a BrowserResponse that knows how to render itself. This
+// allows apps to add their own response types, e.g. for CSV or protobuf
+// responses. Implementations should embed CommonResponse to satisfy
+// BrowserResponse, and WriteTo is called after any cookies have been set.
+type ResponseWriterTo interface {
+	BrowserResponse
+	WriteTo(ctx context.Context, w http.ResponseWriter, r *Request) error
+}
+
 type CommonResponse struct {
 	Cookies []*http.Cookie
 }
@@ -36,12 +48,25 @@ type TemplateResponse struct {
 	// on the server are used.
 	Templates *template.Template
 	Data      any
+
+	// Stream renders the template directly to the client, rather than in to a
+	// buffer first. This avoids holding very large pages in memory, but an
+	// error part way through rendering leaves the client with a truncated
+	// page and the already sent status, rather than a clean error response.
+	Stream bool
 }
 
 type JSONResponse struct {
 	CommonResponse
 	// Data to be marshaled to JSON
 	Data any
+	// Buffered keeps the encoded response in the error middleware's buffer,
+	// when Config.BufferResponses is set, so an error reported after it is
+	// written still replaces it. By default the response bypasses the buffer
+	// and is written directly to the client, avoiding holding a second copy of
+	// large payloads in memory. Either way, an error encoding Data is returned
+	// before anything is written.
+	Buffered bool
 }
 
 type RedirectResponse struct {
@@ -52,6 +77,147 @@ type RedirectResponse struct {
 	URL string
 }
 
+// StreamResponse writes the response directly to the client as it is
+// produced, bypassing any response buffering in the error middleware. Because
+// the response is sent as it is written, an error returned after writing has
+// started can not replace the partial response.
+type StreamResponse struct {
+	CommonResponse
+	// ContentType of the response. If not set, it is sniffed from the first
+	// write.
+	ContentType string
+	// Stream is called to write the response body.
+	Stream func(w http.ResponseWriter) error
+}
+
+// NDJSONResponse streams values as newline delimited JSON, e.g. for tailing
+// events. Each value is written on its own line and flushed to the client as
+// it is produced. Like StreamResponse, it bypasses response buffering. The
+// response ends when Values returns.
+type NDJSONResponse struct {
+	CommonResponse
+	// Values calls yield with each value to write, and should return when
+	// yield returns false. ctx is the request's context, and producers that
+	// block must return when it is done, so they do not outlive a client that
+	// has gone away. For example, to write values from a channel:
+	//
+	//	func(ctx context.Context, yield func(any) bool) {
+	//		for {
+	//			select {
+	//			case v, ok := <-ch:
+	//				if !ok || !yield(v) {
+	//					return
+	//				}
+	//			case <-ctx.Done():
+	//				return
+	//			}
+	//		}
+	//	}
+	Values func(ctx context.Context, yield func(any) bool)
+}
+
+// CachedResponse renders Response only if the client does not already have the
+// current version. If the request's If-None-Match header matches ETag, a 304
+// Not Modified is sent instead, skipping the render. Otherwise Response is
+// written with the ETag header set.
+type CachedResponse struct {
+	CommonResponse
+	// ETag identifies the version of the response, e.g. a content hash or
+	// revision number. It is quoted if it is not already.
+	ETag string
+	// Weak marks the ETag as weak, for responses that are semantically but
+	// not byte-for-byte equivalent.
+	Weak bool
+	// Response is rendered if the client's version does not match.
+	Response BrowserResponse
+}
+
+func (c *CachedResponse) etag() string {
+	etag := c.ETag
+	if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	if c.Weak && !strings.HasPrefix(etag, "W/") {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// etagMatches checks an If-None-Match header value against the etag, using the
+// weak comparison RFC 9110 requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for cand := range strings.SplitSeq(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(cand), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheOpts describes the Cache-Control header for a response.
+type CacheOpts struct {
+	// MaxAge is how long the response can be cached for. Durations are
+	// truncated to whole seconds, zero omits the directive.
+	MaxAge time.Duration
+	// SMaxAge overrides MaxAge for shared caches, e.g. a CDN.
+	SMaxAge time.Duration
+	// Public allows shared caches to store the response, even if it would
+	// otherwise not be cacheable, e.g. it has an Authorization header.
+	Public bool
+	// Private limits caching to the user's browser. It takes precedence over
+	// Public.
+	Private bool
+	// NoStore prevents any caching. All other options are ignored.
+	NoStore bool
+	// Immutable indicates the response will not change while it is fresh, so
+	// browsers need not revalidate it on reload.
+	Immutable bool
+}
+
+// String returns the Cache-Control header value.
+func (c CacheOpts) String() string {
+	if c.NoStore {
+		return "no-store"
+	}
+	var directives []string
+	switch {
+	case c.Private:
+		directives = append(directives, "private")
+	case c.Public:
+		directives = append(directives, "public")
+	}
+	if c.MaxAge > 0 {
+		directives = append(directives, "max-age="+strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	if c.SMaxAge > 0 && !c.Private {
+		directives = append(directives, "s-maxage="+strconv.Itoa(int(c.SMaxAge.Seconds())))
+	}
+	if c.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// cacheableResponse sets the Cache-Control header before rendering its
+// response.
+type cacheableResponse struct {
+	CommonResponse
+	opts     CacheOpts
+	response BrowserResponse
+}
+
+// Cacheable wraps a response, setting its Cache-Control header from opts.
+func Cacheable(response BrowserResponse, opts CacheOpts) BrowserResponse {
+	return &cacheableResponse{opts: opts, response: response}
+}
+
 func isJSONContentType(contentType string) bool {
 	if contentType == "" {
 		return false
@@ -3,10 +3,12 @@ package web
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 
+	"lds.li/web/httperror"
 	"lds.li/web/internal"
 )
 
@@ -37,7 +39,10 @@ func (w *responseWriter) WriteResponse(r *Request, resp BrowserResponse) error {
 		return fmt.Errorf("response already written")
 	}
 	w.handled = true
+	return w.writeResponse(r, resp)
+}
 
+func (w *responseWriter) writeResponse(r *Request, resp BrowserResponse) error {
 	// Set any cookies from the response
 	for _, c := range resp.getSettableCookies() {
 		http.SetCookie(w, c)
@@ -54,6 +59,16 @@ func (w *responseWriter) WriteResponse(r *Request, resp BrowserResponse) error {
 		return nil
 	case *RedirectResponse:
 		return w.writeRedirectResponse(r, resp)
+	case *StreamResponse:
+		return w.writeStreamResponse(resp)
+	case *NDJSONResponse:
+		return w.writeNDJSONResponse(r, resp)
+	case *CachedResponse:
+		return w.writeCachedResponse(r, resp)
+	case *cacheableResponse:
+		return w.writeCacheableResponse(r, resp)
+	case ResponseWriterTo:
+		return resp.WriteTo(r.r.Context(), w, r)
 	default:
 		return fmt.Errorf("unhandled browser response type: %T", resp)
 	}
@@ -66,6 +81,11 @@ func (w *responseWriter) Unwrap() http.ResponseWriter {
 func (w *responseWriter) writeTemplateResponse(req *Request, resp *TemplateResponse) error {
 	t := resp.Templates.Funcs(TemplateFuncs(req.r.Context(), resp.Funcs))
 
+	if resp.Stream {
+		httperror.DisableBuffering(w)
+		return t.ExecuteTemplate(w, resp.Name, resp.Data)
+	}
+
 	// Buffer the render to capture errors before writing
 	var buf bytes.Buffer
 	err := t.ExecuteTemplate(&buf, resp.Name, resp.Data)
@@ -79,6 +99,11 @@ func (w *responseWriter) writeTemplateResponse(req *Request, resp *TemplateRespo
 
 func (w *responseWriter) writeJSONResponse(resp *JSONResponse) error {
 	w.Header().Set("Content-Type", "application/json")
+	if !resp.Buffered {
+		// Encode marshals the whole value before writing, so an encoding
+		// error can still be reported cleanly.
+		httperror.DisableBuffering(w)
+	}
 	return json.NewEncoder(w).Encode(resp.Data)
 }
 
@@ -90,3 +115,62 @@ func (w *responseWriter) writeRedirectResponse(req *Request, resp *RedirectRespo
 	http.Redirect(w, req.r, resp.URL, code)
 	return nil
 }
+
+func (w *responseWriter) writeStreamResponse(resp *StreamResponse) error {
+	httperror.DisableBuffering(w)
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	return resp.Stream(w)
+}
+
+func (w *responseWriter) writeNDJSONResponse(req *Request, resp *NDJSONResponse) error {
+	httperror.DisableBuffering(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	ctx := req.r.Context()
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var err error
+	resp.Values(ctx, func(v any) bool {
+		if ctx.Err() != nil {
+			// the client has gone away
+			return false
+		}
+		// Encode writes the trailing newline
+		if eerr := enc.Encode(v); eerr != nil {
+			err = fmt.Errorf("encoding value: %w", eerr)
+			return false
+		}
+		if ferr := rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+			err = fmt.Errorf("flushing response: %w", ferr)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+func (w *responseWriter) writeCacheableResponse(req *Request, resp *cacheableResponse) error {
+	if resp.response == nil {
+		return fmt.Errorf("cacheable response has no inner response")
+	}
+	if cc := resp.opts.String(); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+	}
+	return w.writeResponse(req, resp.response)
+}
+
+func (w *responseWriter) writeCachedResponse(req *Request, resp *CachedResponse) error {
+	if resp.Response == nil {
+		return fmt.Errorf("cached response has no inner response")
+	}
+	etag := resp.etag()
+	w.Header().Set("ETag", etag)
+	if (req.r.Method == http.MethodGet || req.r.Method == http.MethodHead) &&
+		etagMatches(req.r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return w.writeResponse(req, resp.Response)
+}
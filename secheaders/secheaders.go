@@ -0,0 +1,82 @@
+// Package secheaders sets baseline security headers on responses, that
+// complement the Content-Security-Policy set by the csp package.
+package secheaders
+
+import (
+	"net/http"
+
+	"lds.li/web/internal"
+	"lds.li/web/permissionspolicy"
+)
+
+// Defaults for the Handler fields.
+const (
+	DefaultStrictTransportSecurity = "max-age=63072000"
+	DefaultContentTypeOptions      = "nosniff"
+	DefaultReferrerPolicy          = "strict-origin-when-cross-origin"
+	DefaultFrameOptions            = "SAMEORIGIN"
+)
+
+// DefaultPermissionsPolicy disables powerful browser features, see
+// permissionspolicy.Default.
+var DefaultPermissionsPolicy = permissionspolicy.Default.String()
+
+// Omit can be set as the value of a header field to not send the header.
+const Omit = "-"
+
+// Handler sets security headers on responses. Fields that are not set use
+// their default, and can be set to Omit to not send the header.
+type Handler struct {
+	// StrictTransportSecurity is the value of the Strict-Transport-Security
+	// header. It is only sent for requests made over HTTPS, so local
+	// development over plain HTTP is not locked out. Defaults to
+	// DefaultStrictTransportSecurity, which only covers the host serving the
+	// response. Add includeSubDomains once every subdomain is served over
+	// HTTPS, as browsers will refuse plain HTTP to them for two years.
+	StrictTransportSecurity string
+	// ContentTypeOptions is the value of the X-Content-Type-Options header.
+	// Defaults to DefaultContentTypeOptions.
+	ContentTypeOptions string
+	// ReferrerPolicy is the value of the Referrer-Policy header. Defaults to
+	// DefaultReferrerPolicy.
+	ReferrerPolicy string
+	// FrameOptions is the value of the X-Frame-Options header. This is
+	// superseded by the CSP frame-ancestors directive, but is set for older
+	// browsers. Defaults to DefaultFrameOptions.
+	FrameOptions string
+	// PermissionsPolicy is the value of the Permissions-Policy header, which
+	// can be built with the permissionspolicy package. Defaults to
+	// DefaultPermissionsPolicy.
+	PermissionsPolicy string
+	// ForwardedProtoHeader names the header a trusted proxy uses to report
+	// the client's protocol, e.g. X-Forwarded-Proto, so HSTS is sent when the
+	// proxy terminates TLS. If not set, HSTS is only sent for direct TLS
+	// connections.
+	ForwardedProtoHeader string
+}
+
+// Handle wraps the handler, setting the headers on all responses.
+func (h *Handler) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if internal.IsHTTPS(r, h.ForwardedProtoHeader) {
+			setHeader(w, "Strict-Transport-Security", h.StrictTransportSecurity, DefaultStrictTransportSecurity)
+		}
+		setHeader(w, "X-Content-Type-Options", h.ContentTypeOptions, DefaultContentTypeOptions)
+		setHeader(w, "Referrer-Policy", h.ReferrerPolicy, DefaultReferrerPolicy)
+		setHeader(w, "X-Frame-Options", h.FrameOptions, DefaultFrameOptions)
+		setHeader(w, "Permissions-Policy", h.PermissionsPolicy, DefaultPermissionsPolicy)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setHeader(w http.ResponseWriter, name, value, def string) {
+	if value == "" {
+		value = def
+	}
+	if value == "" || value == Omit {
+		w.Header().Del(name)
+		return
+	}
+	w.Header().Set(name, value)
+}
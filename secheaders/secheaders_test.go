@@ -0,0 +1,94 @@
+package secheaders
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		handler *Handler
+		url     string
+		headers map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "defaults over https",
+			handler: &Handler{},
+			url:     "https://example.com/",
+			want: map[string]string{
+				"Strict-Transport-Security": DefaultStrictTransportSecurity,
+				"X-Content-Type-Options":    DefaultContentTypeOptions,
+				"Referrer-Policy":           DefaultReferrerPolicy,
+				"X-Frame-Options":           DefaultFrameOptions,
+				"Permissions-Policy":        DefaultPermissionsPolicy,
+			},
+		},
+		{
+			name:    "no hsts over http",
+			handler: &Handler{},
+			url:     "http://localhost/",
+			want: map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Content-Type-Options":    DefaultContentTypeOptions,
+			},
+		},
+		{
+			name:    "hsts via forwarded proto",
+			handler: &Handler{ForwardedProtoHeader: "X-Forwarded-Proto"},
+			url:     "http://example.com/",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+			want: map[string]string{
+				"Strict-Transport-Security": DefaultStrictTransportSecurity,
+			},
+		},
+		{
+			name:    "forwarded proto not trusted",
+			handler: &Handler{},
+			url:     "http://example.com/",
+			headers: map[string]string{"X-Forwarded-Proto": "https"},
+			want: map[string]string{
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "overrides",
+			handler: &Handler{
+				StrictTransportSecurity: "max-age=300",
+				ReferrerPolicy:          "no-referrer",
+				FrameOptions:            Omit,
+				PermissionsPolicy:       "camera=()",
+			},
+			url: "https://example.com/",
+			want: map[string]string{
+				"Strict-Transport-Security": "max-age=300",
+				"Referrer-Policy":           "no-referrer",
+				"X-Frame-Options":           "",
+				"Permissions-Policy":        "camera=()",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := tc.handler.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := map[string]string{}
+			for k := range tc.want {
+				got[k] = rec.Header().Get(k)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -14,12 +16,16 @@ import (
 	"lds.li/web/middleware"
 	"lds.li/web/requestid"
 	"lds.li/web/requestlog"
+	"lds.li/web/secheaders"
 	"lds.li/web/session"
 	"lds.li/web/static"
 )
 
 const staticPrefix = "/static/"
 
+// DefaultMaxFormBytes is the default for Config.MaxFormBytes.
+const DefaultMaxFormBytes = 10 << 20
+
 const (
 	MiddlewareCSPName         = "csp"
 	MiddlewareCSRFName        = "csrf"
@@ -29,8 +35,24 @@ const (
 	MiddlewareErrorName       = "error"
 	MiddlewareStaticName      = "static"
 	MiddlewareBaseHeadersName = "baseheaders"
+	MiddlewareSecHeadersName  = "secheaders"
 )
 
+// DefaultBaseMiddlewareRules are the ordering requirements for the base
+// middleware the server installs. They are checked by ValidateMiddleware.
+var DefaultBaseMiddlewareRules = []middleware.OrderRule{
+	{Before: MiddlewareRequestIDName, After: MiddlewareRequestLogName, Reason: "logs should include the request ID"},
+	{Before: MiddlewareRequestIDName, After: MiddlewareErrorName, Reason: "errors should include the request ID"},
+	{Before: MiddlewareRequestLogName, After: MiddlewareErrorName, Reason: "the logged status should be the rendered error"},
+}
+
+// DefaultBrowserMiddlewareRules are the ordering requirements for the browser
+// middleware the server installs. They are checked by ValidateMiddleware.
+var DefaultBrowserMiddlewareRules = []middleware.OrderRule{
+	{Before: MiddlewareCSPName, After: MiddlewareSessionName, Reason: "session errors should be rendered with the CSP applied"},
+	{Before: MiddlewareCSRFName, After: MiddlewareSessionName, Reason: "cross-origin requests should be rejected before the session is loaded"},
+}
+
 var DefaultCSPOpts = []csp.HandlerOpt{
 	csp.DefaultSrc(`'none'`),
 	csp.WithScriptNonce(),
@@ -62,11 +84,108 @@ type Config struct {
 	// AdditionalBrowserMiddleware is a set of middleware that will be added to
 	// all browser handlers, after the base middleware.
 	AdditionalBrowserMiddleware []func(http.Handler) http.Handler
+	// BufferResponses holds response bodies in memory until the handler
+	// returns, so an error can cleanly replace a partially written response.
+	// Handlers that need to stream should use StreamResponse.
+	BufferResponses bool
+	// ErrorHandlerRoutes selects a different error handler for some requests,
+	// e.g. problem+json for an API and branded HTML for browser routes. The
+	// first matching route is used, falling back to ErrorHandler if none
+	// match.
+	ErrorHandlerRoutes []ErrorHandlerRoute
+	// StaticMounts serves additional static file systems, keyed by the URL
+	// prefix they are served at, e.g. "/vendor/". The prefix must start and
+	// end with a /. Templates resolve paths starting with the prefix against
+	// the mount, e.g. {{StaticPath "/vendor/app.js"}}. Other paths resolve
+	// against Static, which is served at /static/.
+	StaticMounts map[string]fs.FS
+	// StaticCacheControl is the Cache-Control header for static files
+	// requested without a version, i.e. not via StaticPath. See
+	// static.FileHandler.DefaultCacheControl.
+	StaticCacheControl string
+	// MaxFormBytes limits the size of url-encoded and multipart form request
+	// bodies to browser handlers. Larger requests fail with a 413 when the
+	// form is parsed. Defaults to DefaultMaxFormBytes, a negative value
+	// disables the limit. It can be overridden per-handler with
+	// WithMaxFormBytes.
+	MaxFormBytes int64
+	// DevMode enables routes that help with development, but expose
+	// information that should not be available in production. Currently this
+	// is /_/debug/csp, which shows the content security policy and nonces
+	// computed for the request.
+	DevMode bool
+	// SecureContext configures the check applied to handlers registered with
+	// RequireSecureContext, e.g. to trust a proxy's forwarded protocol header
+	// or to customize the rejection. If the Origin is not set, it is derived
+	// from BaseURL.
+	SecureContext *middleware.SecureContext
+	// SecurityHeaders configures the Strict-Transport-Security,
+	// Referrer-Policy and related headers set on all responses, including raw
+	// handlers and static files. If nil, the secheaders defaults are used.
+	SecurityHeaders *secheaders.Handler
+	// NotFoundHandler serves requests that match no route, e.g. to render a
+	// styled 404 page, or serve a single page app's index for client side
+	// routes. It is served like a browser handler, so runs through the browser
+	// middleware and can be a BrowserHandlerFunc. If nil, a 404 error is sent
+	// through the error handler.
+	NotFoundHandler http.Handler
+	// DisableOptionsHandling turns off the automatic response to OPTIONS
+	// requests. By default an OPTIONS request for a path with method specific
+	// routes is answered with a 204 and an Allow header listing the methods
+	// the path accepts, through the base middleware. Routes that match OPTIONS
+	// requests, e.g. "OPTIONS /api/" or a method-less "/api/", handle their
+	// own requests, and CORS pre-flight requests are never answered
+	// automatically, so CORS handling sees them either way.
+	DisableOptionsHandling bool
+	// BaseContext returns a context whose values are available to every
+	// request, like http.Server's BaseContext. It is called once when the
+	// server is created. This is typically used to provide shared
+	// dependencies added with WithDependency, e.g. a database pool. Values
+	// set on the request context take precedence over it, and its deadline
+	// and cancellation are not used.
+	BaseContext func() context.Context
 
 	/* start new section */
 	CSRFHandler func(http.Handler) http.Handler
 }
 
+// ErrorHandlerRoute is an error handler used for matching requests.
+type ErrorHandlerRoute struct {
+	// PathPrefix matches requests whose path starts with this prefix.
+	PathPrefix string
+	// Match matches requests the func returns true for. If both PathPrefix and
+	// Match are set, both must match.
+	Match   func(r *http.Request) bool
+	Handler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+func (e *ErrorHandlerRoute) matches(r *http.Request) bool {
+	if e.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, e.PathPrefix) {
+		return false
+	}
+	if e.Match != nil && !e.Match(r) {
+		return false
+	}
+	return true
+}
+
+// routeErrorHandler returns an error handler that dispatches to the first
+// matching route, or the default handler.
+func routeErrorHandler(routes []ErrorHandlerRoute, def func(w http.ResponseWriter, r *http.Request, err error)) httperror.ErrorHandler {
+	if len(routes) == 0 {
+		return httperror.ErrorHandlerFunc(def)
+	}
+	return httperror.ErrorHandlerFunc(func(w http.ResponseWriter, r *http.Request, err error) {
+		for i := range routes {
+			if routes[i].matches(r) {
+				routes[i].Handler(w, r, err)
+				return
+			}
+		}
+		def(w, r, err)
+	})
+}
+
 func NewServer(c *Config) (*Server, error) {
 	if c.CSPOpts == nil {
 		c.CSPOpts = DefaultCSPOpts
@@ -74,11 +193,37 @@ func NewServer(c *Config) (*Server, error) {
 	if c.ErrorHandler == nil {
 		c.ErrorHandler = httperror.DefaultErrorHandler
 	}
+	if c.MaxFormBytes == 0 {
+		c.MaxFormBytes = DefaultMaxFormBytes
+	}
+	for i, r := range c.ErrorHandlerRoutes {
+		if r.Handler == nil {
+			return nil, fmt.Errorf("error handler route %d has no handler", i)
+		}
+	}
 
 	sh, err := static.NewFileHandler(c.Static, staticPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("creating static handler: %w", err)
 	}
+	sh.DefaultCacheControl = c.StaticCacheControl
+
+	var mounts []staticMount
+	for prefix, mfs := range c.StaticMounts {
+		if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+			return nil, fmt.Errorf("static mount prefix %q must start and end with /", prefix)
+		}
+		if prefix == staticPrefix {
+			return nil, fmt.Errorf("static mount prefix %q conflicts with the default static handler", prefix)
+		}
+		mh, err := static.NewFileHandler(mfs, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("creating static handler for %s: %w", prefix, err)
+		}
+		mh.DefaultCacheControl = c.StaticCacheControl
+		mounts = append(mounts, staticMount{prefix: prefix, handler: mh})
+	}
+	staticPaths := newStaticMounts(sh, mounts)
 
 	csrfHandler := c.CSRFHandler
 	if csrfHandler == nil {
@@ -87,6 +232,14 @@ func NewServer(c *Config) (*Server, error) {
 
 	cspHandler := csp.NewHandler(*c.BaseURL, c.CSPOpts...)
 
+	var secureContext middleware.SecureContext
+	if c.SecureContext != nil {
+		secureContext = *c.SecureContext
+	}
+	if secureContext.Origin == "" {
+		secureContext.Origin = (&url.URL{Scheme: c.BaseURL.Scheme, Host: c.BaseURL.Host}).String()
+	}
+
 	loghandler := &requestlog.RequestLogger{
 		// TODO - pass in something?
 	}
@@ -94,27 +247,37 @@ func NewServer(c *Config) (*Server, error) {
 	svr := &Server{
 		config:            c,
 		staticHandler:     sh,
+		secureContext:     secureContext.Handle(http.HandlerFunc(serveSecureContextNext)),
 		BrowserMux:        http.NewServeMux(),
 		RawMux:            http.NewServeMux(),
 		BrowserMiddleware: &middleware.Chain{},
 		BaseMiddleware:    &middleware.Chain{},
 	}
+	if c.BaseContext != nil {
+		svr.baseCtx = c.BaseContext()
+	}
 
 	svr.BaseMiddleware.Append(MiddlewareRequestIDName, func(h http.Handler) http.Handler {
 		return (&requestid.Middleware{}).Handler(h)
 	})
 	svr.BaseMiddleware.Append(MiddlewareBaseHeadersName, BaseHeaders)
+	secHeaders := c.SecurityHeaders
+	if secHeaders == nil {
+		secHeaders = &secheaders.Handler{}
+	}
+	svr.BaseMiddleware.Append(MiddlewareSecHeadersName, secHeaders.Handle)
 	svr.BaseMiddleware.Append(MiddlewareRequestLogName, loghandler.Handler)
 	svr.BaseMiddleware.Append(MiddlewareErrorName, (&httperror.Handler{
-		RecoverPanic: true,
-		ErrorHandler: httperror.ErrorHandlerFunc(c.ErrorHandler), // TODO - default handler should be a handler?
+		RecoverPanic:   true,
+		BufferResponse: c.BufferResponses,
+		ErrorHandler:   routeErrorHandler(c.ErrorHandlerRoutes, c.ErrorHandler), // TODO - default handler should be a handler?
 	}).Handle)
 
 	svr.BrowserMiddleware.Append(MiddlewareStaticName, func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// set the static handler in the context, so we can use it to build paths in
 			// templates.
-			r = r.WithContext(ctxkeys.ContextWithStaticHandler(r.Context(), sh))
+			r = r.WithContext(ctxkeys.ContextWithStaticHandler(r.Context(), staticPaths))
 			h.ServeHTTP(w, r)
 		})
 	})
@@ -125,6 +288,13 @@ func NewServer(c *Config) (*Server, error) {
 	}
 
 	svr.RawMux.Handle("/static/", svr.staticHandler)
+	for _, m := range mounts {
+		svr.RawMux.Handle(m.prefix, m.handler)
+	}
+
+	if c.DevMode {
+		svr.BrowserMux.Handle("GET /_/debug/csp", cspDebugHandler(cspHandler))
+	}
 
 	return svr, nil
 }
@@ -141,6 +311,27 @@ type Server struct {
 
 	config        *Config
 	staticHandler *static.FileHandler
+	// secureContext guards handlers registered with RequireSecureContext. It
+	// is built once, and serves the handler serveBrowser stores in the
+	// request context.
+	secureContext http.Handler
+	// baseCtx provides values from Config.BaseContext, if set.
+	baseCtx context.Context
+}
+
+// ValidateMiddleware checks the base and browser middleware chains against
+// DefaultBaseMiddlewareRules and DefaultBrowserMiddlewareRules. The chains can
+// be modified after the server is created, so this should be called at
+// startup, once the app has finished configuring them.
+func (s *Server) ValidateMiddleware() error {
+	var errs []error
+	if err := s.BaseMiddleware.Validate(DefaultBaseMiddlewareRules...); err != nil {
+		errs = append(errs, fmt.Errorf("base middleware: %w", err))
+	}
+	if err := s.BrowserMiddleware.Validate(DefaultBrowserMiddlewareRules...); err != nil {
+		errs = append(errs, fmt.Errorf("browser middleware: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
 func (s *Server) HandleRaw(pattern string, handler http.Handler) {
@@ -148,12 +339,12 @@ func (s *Server) HandleRaw(pattern string, handler http.Handler) {
 }
 
 func (s *Server) Handle(pattern string, h http.Handler, opts ...HandlerOpt) {
-	s.BrowserMux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for _, opt := range opts {
-			r = opt(r)
-		}
-		h.ServeHTTP(NewResponseWriter(w), r)
-	}))
+	s.BrowserMux.Handle(pattern, &handlerWithOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(NewResponseWriter(w), r)
+		}),
+		Opts: opts,
+	})
 }
 
 func (s *Server) HandleFunc(pattern string, h func(w http.ResponseWriter, r *http.Request), opts ...HandlerOpt) {
@@ -161,13 +352,29 @@ func (s *Server) HandleFunc(pattern string, h func(w http.ResponseWriter, r *htt
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if s.baseCtx != nil {
+		ctx = &baseValuesContext{Context: ctx, base: s.baseCtx}
+	}
+	r = r.WithContext(ctxkeys.ContextWithBaseURL(ctx, s.config.BaseURL))
+
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") == "" && !s.config.DisableOptionsHandling {
+		if allow := s.allowedMethods(r); allow != nil {
+			s.BaseMiddleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				w.WriteHeader(http.StatusNoContent)
+			})).ServeHTTP(w, r)
+			return
+		}
+	}
+
 	bh, bp := s.BrowserMux.Handler(r)
 	rh, rp := s.RawMux.Handler(r)
 
 	switch {
 	case bp != "" && rp == "":
 		// browser path only
-		s.BaseMiddleware.Handler(s.BrowserMiddleware.Handler(bh)).ServeHTTP(w, r)
+		s.serveBrowser(w, r, bh)
 		return
 	case bp == "" && rp != "":
 		// raw path only
@@ -176,7 +383,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case bp != "" && rp != "":
 		switch compareSpecificity(bp, rp, r) {
 		case 1:
-			s.BaseMiddleware.Handler(s.BrowserMiddleware.Handler(bh)).ServeHTTP(w, r)
+			s.serveBrowser(w, r, bh)
 			return
 		case -1:
 			s.BaseMiddleware.Handler(rh).ServeHTTP(w, r)
@@ -190,12 +397,69 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	default:
 		// not found
+		if s.config.NotFoundHandler != nil {
+			s.serveBrowser(w, r, s.config.NotFoundHandler)
+			return
+		}
 		// TODO - call the error handler directly?
 		s.BaseMiddleware.Handler(http.NotFoundHandler()).ServeHTTP(w, r)
 		return
 	}
 }
 
+// optionsProbeMethods are the methods checked for the Allow header of an
+// automatic OPTIONS response.
+var optionsProbeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// allowedMethods returns the methods routes accept for the OPTIONS request's
+// path, including OPTIONS. It returns nil if no routes match the path, or a
+// route matches the OPTIONS request itself, either registered for OPTIONS or
+// without a method, as that route handles it.
+func (s *Server) allowedMethods(r *http.Request) []string {
+	muxes := []*http.ServeMux{s.BrowserMux, s.RawMux}
+	for _, mux := range muxes {
+		if _, p := mux.Handler(r); p != "" {
+			return nil
+		}
+	}
+
+	var allow []string
+	probe := new(http.Request)
+	*probe = *r
+	for _, m := range optionsProbeMethods {
+		probe.Method = m
+		for _, mux := range muxes {
+			if _, p := mux.Handler(probe); p != "" {
+				allow = append(allow, m)
+				break
+			}
+		}
+	}
+	if allow == nil {
+		return nil
+	}
+	return append([]string{http.MethodOptions}, allow...)
+}
+
+// serveBrowser serves a handler from the browser mux, applying any handler
+// options before the middleware stack.
+func (s *Server) serveBrowser(w http.ResponseWriter, r *http.Request, h http.Handler) {
+	if ho, ok := h.(*handlerWithOpts); ok {
+		for _, opt := range ho.Opts {
+			r = opt(r)
+		}
+	}
+	limitFormBody(w, r, s.config.MaxFormBytes)
+	if requiresSecureContext(r.Context()) {
+		r = r.WithContext(context.WithValue(r.Context(), secureContextNextCtxKey{}, h))
+		h = s.secureContext
+	}
+	bm := s.BrowserMiddleware.HandlerExcluding(h, skippedMiddleware(r.Context())...)
+	s.BaseMiddleware.Handler(bm).ServeHTTP(w, r)
+}
+
 // compareSpecificity determines the relative specificity of two patterns. It
 // returns:
 //
@@ -329,3 +593,29 @@ func patternMatchesHost(pattern string, r *http.Request) bool {
 
 	return r.Host == patternHost
 }
+
+// cspDebugHandler renders the CSP computed for the request, for debugging
+// blocked resources. It must be served behind the CSP middleware.
+func cspDebugHandler(h *csp.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		scriptNonce, scriptNonceEnabled := csp.GetScriptNonce(r.Context())
+		styleNonce, styleNonceEnabled := csp.GetStyleNonce(r.Context())
+
+		policy := h.PolicyString(r.Context())
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "Header: %s\n", h.HeaderName())
+		fmt.Fprintf(&b, "Policy: %s\n\n", policy)
+		fmt.Fprintf(&b, "Script nonce enabled: %t\n", scriptNonceEnabled)
+		fmt.Fprintf(&b, "Script nonce: %s\n", scriptNonce)
+		fmt.Fprintf(&b, "Style nonce enabled: %t\n", styleNonceEnabled)
+		fmt.Fprintf(&b, "Style nonce: %s\n\n", styleNonce)
+		b.WriteString("Directives:\n")
+		for _, d := range strings.Split(policy, "; ") {
+			fmt.Fprintf(&b, "  %s\n", d)
+		}
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
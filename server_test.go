@@ -2,17 +2,24 @@ package web
 
 import (
 	"context"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"lds.li/web/csp"
+	"lds.li/web/middleware"
+	"lds.li/web/secheaders"
 	"lds.li/web/session"
 )
 
@@ -296,3 +303,875 @@ func TestCompareSpecificityWithRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCSRFExempt(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	sm, err := session.NewKVManager(session.NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr, err := NewServer(&Config{
+		BaseURL:        base,
+		SessionManager: sm,
+		Static:         os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		// session middleware should still be applied
+		br.Session().Set("called", true)
+		return rw.WriteResponse(br, &NilResponse{})
+	})
+	svr.Handle("POST /protected", handler)
+	svr.Handle("POST /webhook", handler, WithCSRFExempt())
+
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/protected", wantStatus: http.StatusForbidden},
+		{path: "/webhook", wantStatus: http.StatusOK},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://example.com"+tc.path, nil)
+			req.Header.Set("Sec-Fetch-Site", "cross-site")
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus == http.StatusOK && len(rr.Result().Cookies()) == 0 {
+				t.Error("want session cookie to be set")
+			}
+		})
+	}
+}
+
+func TestServerRequireSecureContext(t *testing.T) {
+	base, _ := url.Parse("https://example.com/app")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	svr.Handle("POST /pay", handler, RequireSecureContext())
+	svr.Handle("POST /webhook", handler, WithCSRFExempt(), RequireSecureContext())
+	svr.Handle("POST /other", handler)
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		origin     string
+		wantStatus int
+	}{
+		{name: "same origin", path: "/pay", origin: "https://example.com", wantStatus: http.StatusOK},
+		{name: "missing origin", path: "/pay", wantStatus: http.StatusForbidden},
+		{name: "csrf exempt still checked", path: "/webhook", wantStatus: http.StatusForbidden},
+		{name: "not required", path: "/other", wantStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://example.com"+tc.path, nil)
+			req.Header.Set("Sec-Fetch-Site", "same-origin")
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestServerInvalidSecureContext(t *testing.T) {
+	base, _ := url.Parse("https://example.com/app")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want NewServer to panic for an invalid SecureContext origin")
+		}
+	}()
+	_, _ = NewServer(&Config{
+		BaseURL:       base,
+		Static:        os.DirFS("static/testdata"),
+		SecureContext: &middleware.SecureContext{Origin: "://example.com"},
+	})
+}
+
+func TestServerSecurityHeaders(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	for _, tc := range []struct {
+		name     string
+		headers  *secheaders.Handler
+		url      string
+		wantHSTS string
+		// wantFrameOptions defaults to secheaders.DefaultFrameOptions.
+		wantFrameOptions string
+	}{
+		{name: "default", url: "https://example.com/", wantHSTS: secheaders.DefaultStrictTransportSecurity},
+		{name: "plain http", url: "http://example.com/", wantHSTS: ""},
+		{name: "configured", headers: &secheaders.Handler{StrictTransportSecurity: "max-age=60"}, url: "https://example.com/", wantHSTS: "max-age=60"},
+		{name: "raw handler", url: "https://example.com/raw", wantHSTS: secheaders.DefaultStrictTransportSecurity},
+		{name: "raw handler omitted", headers: &secheaders.Handler{FrameOptions: secheaders.Omit}, url: "https://example.com/raw", wantHSTS: secheaders.DefaultStrictTransportSecurity, wantFrameOptions: secheaders.Omit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, err := NewServer(&Config{
+				BaseURL:         base,
+				Static:          os.DirFS("static/testdata"),
+				SecurityHeaders: tc.headers,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svr.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+			svr.RawMux.HandleFunc("/raw", func(w http.ResponseWriter, r *http.Request) {})
+
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			if got := rr.Header().Get("Strict-Transport-Security"); got != tc.wantHSTS {
+				t.Errorf("want HSTS %q, got %q", tc.wantHSTS, got)
+			}
+			if got := rr.Header().Get("Referrer-Policy"); got != secheaders.DefaultReferrerPolicy {
+				t.Errorf("want referrer policy %q, got %q", secheaders.DefaultReferrerPolicy, got)
+			}
+			wantFrameOptions := tc.wantFrameOptions
+			switch wantFrameOptions {
+			case "":
+				wantFrameOptions = secheaders.DefaultFrameOptions
+			case secheaders.Omit:
+				wantFrameOptions = ""
+			}
+			if got := rr.Header().Get("X-Frame-Options"); got != wantFrameOptions {
+				t.Errorf("want frame options %q, got %q", wantFrameOptions, got)
+			}
+		})
+	}
+}
+
+func TestServerTemplateRenderError(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the render fails part way through, after "start " is output.
+	tmpl := template.Must(template.New("").Parse(`{{define "page"}}start {{.Missing}}{{end}}`))
+
+	svr.Handle("/buffered", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &TemplateResponse{Templates: tmpl, Name: "page", Data: struct{}{}})
+	}))
+	svr.Handle("/stream", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &TemplateResponse{Templates: tmpl, Name: "page", Data: struct{}{}, Stream: true})
+	}))
+
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/buffered", wantStatus: http.StatusInternalServerError, wantBody: "Internal Server Error\n"},
+		// the page has started, so the error is only logged.
+		{path: "/stream", wantStatus: http.StatusOK, wantBody: "start "},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if rr.Body.String() != tc.wantBody {
+				t.Errorf("want body %q, got %q", tc.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerBufferResponses(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL:         base,
+		Static:          os.DirFS("static/testdata"),
+		BufferResponses: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr.Handle("/partial", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		_, _ = rw.Write([]byte("partial"))
+		return errors.New("failed after writing")
+	}))
+	svr.Handle("/stream", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &StreamResponse{
+			ContentType: "text/plain",
+			Stream: func(w http.ResponseWriter) error {
+				_, err := w.Write([]byte("streamed"))
+				return err
+			},
+		})
+	}))
+	svr.Handle("/json", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		if err := rw.WriteResponse(br, &JSONResponse{Data: "ok"}); err != nil {
+			return err
+		}
+		return errors.New("failed after writing")
+	}))
+	svr.Handle("/json-buffered", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		if err := rw.WriteResponse(br, &JSONResponse{Data: "ok", Buffered: true}); err != nil {
+			return err
+		}
+		return errors.New("failed after writing")
+	}))
+	svr.Handle("/json-invalid", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &JSONResponse{Data: make(chan int)})
+	}))
+
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/partial", wantStatus: http.StatusInternalServerError, wantBody: "Internal Server Error\n"},
+		{path: "/stream", wantStatus: http.StatusOK, wantBody: "streamed"},
+		// the response has been sent, so the error is only logged.
+		{path: "/json", wantStatus: http.StatusOK, wantBody: "\"ok\"\n"},
+		{path: "/json-buffered", wantStatus: http.StatusInternalServerError, wantBody: "Internal Server Error\n"},
+		{path: "/json-invalid", wantStatus: http.StatusInternalServerError, wantBody: "Internal Server Error\n"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if rr.Body.String() != tc.wantBody {
+				t.Errorf("want body %q, got %q", tc.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+// csvResponse is an app-defined response type.
+type csvResponse struct {
+	CommonResponse
+	Rows [][]string
+}
+
+func (c *csvResponse) WriteTo(ctx context.Context, w http.ResponseWriter, r *Request) error {
+	w.Header().Set("Content-Type", "text/csv")
+	return csv.NewWriter(w).WriteAll(c.Rows)
+}
+
+func TestServerNotFoundHandler(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	for _, tc := range []struct {
+		name       string
+		handler    http.Handler
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "default",
+			wantStatus: http.StatusNotFound,
+			// rendered by the error handler
+			wantBody: "Not Found\n",
+		},
+		{
+			name: "custom",
+			handler: BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("index"))
+				return nil
+			}),
+			wantStatus: http.StatusOK,
+			wantBody:   "index",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, err := NewServer(&Config{
+				BaseURL:         base,
+				Static:          os.DirFS("static/testdata"),
+				NotFoundHandler: tc.handler,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svr.Handle("GET /exists", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/app/route", nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if rr.Body.String() != tc.wantBody {
+				t.Errorf("want body %q, got %q", tc.wantBody, rr.Body.String())
+			}
+			if tc.handler != nil && rr.Header().Get("Content-Security-Policy") == "" {
+				t.Error("want browser middleware applied to not found handler")
+			}
+		})
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	for _, tc := range []struct {
+		name            string
+		disable         bool
+		path            string
+		preflight       bool
+		wantStatus      int
+		wantAllow       string
+		wantAllowOrigin string
+	}{
+		{name: "methods", path: "/items", wantStatus: http.StatusNoContent, wantAllow: "OPTIONS, GET, HEAD, POST"},
+		{name: "raw route", path: "/raw", wantStatus: http.StatusNoContent, wantAllow: "OPTIONS, PUT"},
+		{name: "route handles options", path: "/custom", wantStatus: http.StatusTeapot},
+		{name: "method-less route handles options", path: "/api/things", preflight: true, wantStatus: http.StatusOK, wantAllowOrigin: "https://other.example.com"},
+		{name: "preflight not answered", path: "/items", preflight: true, wantStatus: http.StatusNotFound},
+		{name: "no route", path: "/missing", wantStatus: http.StatusNotFound},
+		{name: "disabled", disable: true, path: "/items", wantStatus: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, err := NewServer(&Config{
+				BaseURL:                base,
+				Static:                 os.DirFS("static/testdata"),
+				DisableOptionsHandling: tc.disable,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			svr.Handle("GET /items", noop)
+			svr.Handle("POST /items", noop)
+			svr.HandleRaw("PUT /raw", noop)
+			svr.Handle("GET /custom", noop)
+			svr.Handle("OPTIONS /custom", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			svr.RawMux.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodOptions {
+					w.Header().Set("Access-Control-Allow-Origin", "https://other.example.com")
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodOptions, tc.path, nil)
+			if tc.preflight {
+				req.Header.Set("Origin", "https://other.example.com")
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Allow"); got != tc.wantAllow {
+				t.Errorf("want Allow %q, got %q", tc.wantAllow, got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.wantAllowOrigin {
+				t.Errorf("want Access-Control-Allow-Origin %q, got %q", tc.wantAllowOrigin, got)
+			}
+		})
+	}
+}
+
+func TestServerCustomResponse(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr.Handle("/csv", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &csvResponse{
+			CommonResponse: CommonResponse{
+				Cookies: []*http.Cookie{{Name: "test", Value: "value"}},
+			},
+			Rows: [][]string{{"a", "b"}, {"1", "2"}},
+		})
+	}))
+
+	rr := httptest.NewRecorder()
+	svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/csv", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("want content type text/csv, got %q", got)
+	}
+	if diff := cmp.Diff("a,b\n1,2\n", rr.Body.String()); diff != "" {
+		t.Error(diff)
+	}
+	if len(rr.Result().Cookies()) == 0 {
+		t.Error("want cookie to be set")
+	}
+}
+
+func TestServerCachedResponse(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr.Handle("/doc", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &CachedResponse{
+			ETag: "v1",
+			Response: &csvResponse{
+				Rows: [][]string{{"a", "b"}},
+			},
+		})
+	}))
+
+	for _, tc := range []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{name: "no header", wantStatus: http.StatusOK, wantBody: "a,b\n"},
+		{name: "match", ifNoneMatch: `"v1"`, wantStatus: http.StatusNotModified},
+		{name: "weak match", ifNoneMatch: `W/"v1"`, wantStatus: http.StatusNotModified},
+		{name: "match in list", ifNoneMatch: `"v0", "v1"`, wantStatus: http.StatusNotModified},
+		{name: "wildcard", ifNoneMatch: `*`, wantStatus: http.StatusNotModified},
+		{name: "stale", ifNoneMatch: `"v0"`, wantStatus: http.StatusOK, wantBody: "a,b\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/doc", nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if got := rr.Header().Get("ETag"); got != `"v1"` {
+				t.Errorf("want etag %q, got %q", `"v1"`, got)
+			}
+			if diff := cmp.Diff(tc.wantBody, rr.Body.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestServerCacheableResponse(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	for _, tc := range []struct {
+		name string
+		opts CacheOpts
+		want string
+	}{
+		{name: "private", opts: CacheOpts{Private: true, MaxAge: 5 * time.Minute}, want: "private, max-age=300"},
+		{name: "public", opts: CacheOpts{Public: true, MaxAge: time.Hour, SMaxAge: 10 * time.Minute}, want: "public, max-age=3600, s-maxage=600"},
+		{name: "private wins", opts: CacheOpts{Public: true, Private: true, SMaxAge: time.Minute}, want: "private"},
+		{name: "immutable", opts: CacheOpts{Public: true, MaxAge: 365 * 24 * time.Hour, Immutable: true}, want: "public, max-age=31536000, immutable"},
+		{name: "no store", opts: CacheOpts{NoStore: true, MaxAge: time.Hour}, want: "no-store"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, err := NewServer(&Config{
+				BaseURL: base,
+				Static:  os.DirFS("static/testdata"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svr.Handle("/doc", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+				return rw.WriteResponse(br, Cacheable(&csvResponse{
+					CommonResponse: CommonResponse{Cookies: []*http.Cookie{{Name: "c", Value: "v"}}},
+					Rows:           [][]string{{"a", "b"}},
+				}, tc.opts))
+			}))
+
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/doc", nil))
+
+			if got := rr.Header().Get("Cache-Control"); got != tc.want {
+				t.Errorf("want Cache-Control %q, got %q", tc.want, got)
+			}
+			if rr.Body.String() != "a,b\n" {
+				t.Errorf("want inner response rendered, got %q", rr.Body.String())
+			}
+			if len(rr.Result().Cookies()) != 1 {
+				t.Error("want inner response cookies set")
+			}
+		})
+	}
+}
+
+func TestServerNDJSONResponse(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL:         base,
+		Static:          os.DirFS("static/testdata"),
+		BufferResponses: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr.Handle("/events", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		ch := make(chan any)
+		go func() {
+			defer close(ch)
+			for i := range 3 {
+				select {
+				case ch <- map[string]int{"n": i}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return rw.WriteResponse(br, &NDJSONResponse{
+			Values: func(ctx context.Context, yield func(any) bool) {
+				for v := range ch {
+					if !yield(v) {
+						return
+					}
+				}
+			},
+		})
+	}))
+
+	for _, tc := range []struct {
+		name     string
+		cancel   bool
+		wantBody string
+	}{
+		{name: "streams", wantBody: "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n"},
+		{name: "cancelled", cancel: true, wantBody: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if tc.cancel {
+				cancel()
+			}
+
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequestWithContext(ctx, http.MethodGet, "/events", nil))
+
+			if got := rr.Header().Get("Content-Type"); got != "application/x-ndjson" {
+				t.Errorf("want ndjson content type, got %q", got)
+			}
+			if diff := cmp.Diff(tc.wantBody, rr.Body.String()); diff != "" {
+				t.Error(diff)
+			}
+			if !tc.cancel && !rr.Flushed {
+				t.Error("want response to be flushed")
+			}
+		})
+	}
+}
+
+func TestServerNDJSONResponseBlockingProducer(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producerDone := make(chan struct{})
+	svr.Handle("/events", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		return rw.WriteResponse(br, &NDJSONResponse{
+			Values: func(ctx context.Context, yield func(any) bool) {
+				defer close(producerDone)
+				// a producer with nothing to send blocks until the client
+				// goes away.
+				<-ctx.Done()
+			},
+		})
+	}))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		svr.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/events", nil))
+	}()
+	cancel()
+
+	for name, ch := range map[string]chan struct{}{"producer": producerDone, "handler": served} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not return after the request was cancelled", name)
+		}
+	}
+}
+
+func TestServerErrorHandlerRoutes(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	handlerFor := func(name string) func(w http.ResponseWriter, r *http.Request, err error) {
+		return func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(name))
+		}
+	}
+
+	svr, err := NewServer(&Config{
+		BaseURL:      base,
+		Static:       os.DirFS("static/testdata"),
+		ErrorHandler: handlerFor("default"),
+		ErrorHandlerRoutes: []ErrorHandlerRoute{
+			{PathPrefix: "/api/", Handler: handlerFor("api")},
+			{
+				Match:   func(r *http.Request) bool { return r.Header.Get("HX-Request") != "" },
+				Handler: handlerFor("htmx"),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr.HandleRaw("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("failed")
+	}))
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		header   http.Header
+		wantBody string
+	}{
+		{name: "prefix", path: "/api/users", wantBody: "api"},
+		{name: "predicate", path: "/page", header: http.Header{"Hx-Request": []string{"true"}}, wantBody: "htmx"},
+		{name: "default", path: "/page", wantBody: "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Body.String() != tc.wantBody {
+				t.Errorf("want body %q, got %q", tc.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerMaxFormBytes(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL:      base,
+		Static:       os.DirFS("static/testdata"),
+		MaxFormBytes: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		var f struct {
+			Value string `form:"value"`
+		}
+		if err := br.DecodeForm(&f); err != nil {
+			return err
+		}
+		return rw.WriteResponse(br, &NilResponse{})
+	})
+	svr.Handle("POST /form", handler)
+	svr.Handle("POST /upload", handler, WithMaxFormBytes(1024))
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		size       int
+		wantStatus int
+	}{
+		{name: "small", path: "/form", size: 10, wantStatus: http.StatusOK},
+		{name: "too large", path: "/form", size: 100, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "handler override", path: "/upload", size: 100, wantStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := url.Values{"value": []string{strings.Repeat("a", tc.size)}}.Encode()
+			req := httptest.NewRequest(http.MethodPost, "https://example.com"+tc.path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Sec-Fetch-Site", "same-origin")
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d (%s)", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerDebugCSP(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	for _, tc := range []struct {
+		name       string
+		devMode    bool
+		wantStatus int
+	}{
+		{name: "dev mode", devMode: true, wantStatus: http.StatusOK},
+		{name: "production", devMode: false, wantStatus: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, err := NewServer(&Config{
+				BaseURL: base,
+				Static:  os.DirFS("static/testdata"),
+				DevMode: tc.devMode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/_/debug/csp", nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status %d, got %d", tc.wantStatus, rr.Code)
+			}
+			if !tc.devMode {
+				return
+			}
+
+			// the rendered policy and nonce should match what was sent in the
+			// header for the request.
+			header := rr.Header().Get("Content-Security-Policy")
+			if !strings.Contains(rr.Body.String(), "Policy: "+header+"\n") {
+				t.Errorf("body does not contain policy %q:\n%s", header, rr.Body.String())
+			}
+			if !strings.Contains(rr.Body.String(), "Script nonce enabled: true") {
+				t.Errorf("want script nonce enabled in body:\n%s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerStaticMounts(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	svr, err := NewServer(&Config{
+		BaseURL: base,
+		Static:  os.DirFS("static/testdata"),
+		StaticMounts: map[string]fs.FS{
+			"/vendor/": fstest.MapFS{"lib.js": &fstest.MapFile{Data: []byte("vendor")}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	svr.Handle("/paths", BrowserHandlerFunc(func(ctx context.Context, rw ResponseWriter, br *Request) error {
+		staticPath := TemplateFuncs(ctx, nil)["StaticPath"].(func(string) (string, error))
+		for _, f := range []string{"subdir/file2.txt", "/vendor/lib.js"} {
+			p, err := staticPath(f)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p)
+		}
+		return rw.WriteResponse(br, &NilResponse{})
+	}))
+
+	rr := httptest.NewRecorder()
+	svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/paths", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if len(paths) != 2 || paths[0] != "/static/subdir/file2.687830f0.txt" || !strings.HasPrefix(paths[1], "/vendor/lib.") {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+
+	rr = httptest.NewRecorder()
+	svr.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, paths[1], nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "vendor" {
+		t.Errorf("want vendor file served, got status %d body %q", rr.Code, rr.Body.String())
+	}
+
+	if _, err := NewServer(&Config{
+		BaseURL:      base,
+		Static:       os.DirFS("static/testdata"),
+		StaticMounts: map[string]fs.FS{"vendor": fstest.MapFS{}},
+	}); err == nil {
+		t.Error("want error for invalid mount prefix")
+	}
+}
+
+func TestServerValidateMiddleware(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+
+	sm, err := session.NewKVManager(session.NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr, err := NewServer(&Config{
+		BaseURL:        base,
+		SessionManager: sm,
+		Static:         os.DirFS("static/testdata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svr.ValidateMiddleware(); err != nil {
+		t.Fatalf("want default server middleware to be valid, got: %v", err)
+	}
+
+	if err := svr.BrowserMiddleware.Remove(MiddlewareCSRFName); err != nil {
+		t.Fatal(err)
+	}
+	svr.BrowserMiddleware.Append(MiddlewareCSRFName, NoopHandler)
+
+	var ov *middleware.ErrOrderViolation
+	if err := svr.ValidateMiddleware(); !errors.As(err, &ov) {
+		t.Errorf("want order violation, got: %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package session
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +24,36 @@ type AEAD interface {
 	Decrypt(ciphertext, associatedData []byte) ([]byte, error)
 }
 
+// RotatableAEAD is an AEAD that can report which key decrypted the data. If
+// the manager's AEAD implements this, cookie sessions encrypted with an old
+// key are re-encrypted with the primary key on the next response. This allows
+// old keys to be retired once all sessions have been seen or expired.
+type RotatableAEAD interface {
+	AEAD
+	// DecryptWithKeyInfo decrypts the ciphertext, indicating if it was
+	// encrypted with the primary key.
+	DecryptWithKeyInfo(ciphertext, associatedData []byte) (plaintext []byte, primary bool, _ error)
+}
+
+// KeyInfoAEAD is an AEAD that can describe the keys it has loaded, e.g. for
+// rotation tooling to track when an old key is no longer needed. It never
+// exposes the key material.
+type KeyInfoAEAD interface {
+	AEAD
+	// KeyCount returns the number of keys that can decrypt, including the
+	// primary key.
+	KeyCount() int
+	// KeyIDs returns a short identifier for each key, primary first. The ID
+	// is derived from a hash of the key, so it is stable across restarts but
+	// can not be used to recover the key.
+	KeyIDs() []string
+}
+
+var (
+	_ RotatableAEAD = (*xchaPolyAEAD)(nil)
+	_ KeyInfoAEAD   = (*xchaPolyAEAD)(nil)
+)
+
 // xchaPolyAEAD is an implementation of the AEAD interface that uses
 // XChaCha20-Poly1305 with a random nonce. This provides 256-bit security
 // and is resistant to timing attacks.
@@ -36,15 +70,7 @@ func NewXChaPolyAEAD(encryptionKey []byte, additionalDecryptionKeys [][]byte) (A
 		if len(k) != chacha20poly1305.KeySize {
 			return nil, fmt.Errorf("keys must be %d bytes", chacha20poly1305.KeySize)
 		}
-		// Check if key is all zeros
-		isZero := true
-		for _, b := range k {
-			if b != 0 {
-				isZero = false
-				break
-			}
-		}
-		if isZero {
+		if isZeroKey(k) {
 			return nil, fmt.Errorf("keys cannot be all zeros")
 		}
 	}
@@ -70,16 +96,132 @@ func (x *xchaPolyAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error)
 }
 
 func (x *xchaPolyAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	pt, _, err := x.DecryptWithKeyInfo(ciphertext, associatedData)
+	return pt, err
+}
+
+func (x *xchaPolyAEAD) DecryptWithKeyInfo(ciphertext, associatedData []byte) (_ []byte, primary bool, _ error) {
 	nonceSize := chacha20poly1305.NonceSizeX
 	if len(ciphertext) < nonceSize {
-		return nil, errors.New("invalid ciphertext")
+		return nil, false, errors.New("invalid ciphertext")
 	}
 
-	var plaintext []byte
-	for _, dk := range append([][]byte{x.encryptionKey}, x.decryptionKeys...) {
+	for i, dk := range append([][]byte{x.encryptionKey}, x.decryptionKeys...) {
 		aead, err := chacha20poly1305.NewX(dk)
 		if err != nil {
-			return nil, fmt.Errorf("creating XChaCha20-Poly1305 cipher: %w", err)
+			return nil, false, fmt.Errorf("creating XChaCha20-Poly1305 cipher: %w", err)
+		}
+
+		pt, err := aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], associatedData)
+		if err != nil {
+			continue
+		}
+
+		return pt, i == 0, nil
+	}
+
+	return nil, false, fmt.Errorf("failed to decrypt data")
+}
+
+func (x *xchaPolyAEAD) KeyCount() int {
+	return 1 + len(x.decryptionKeys)
+}
+
+func (x *xchaPolyAEAD) KeyIDs() []string {
+	ids := make([]string, 0, x.KeyCount())
+	for _, k := range append([][]byte{x.encryptionKey}, x.decryptionKeys...) {
+		ids = append(ids, aeadKeyID(k))
+	}
+	return ids
+}
+
+// isZeroKey reports if every byte of the key is zero.
+func isZeroKey(k []byte) bool {
+	for _, b := range k {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	_ RotatableAEAD = (*aesGCMAEAD)(nil)
+	_ KeyInfoAEAD   = (*aesGCMAEAD)(nil)
+)
+
+// aesGCMAEAD is an implementation of the AEAD interface that uses AES-GCM with
+// a random nonce.
+type aesGCMAEAD struct {
+	encryptionKey  []byte
+	decryptionKeys [][]byte
+}
+
+// NewAESGCMAEAD constructs an AES-GCM AEAD. The keys must be 16, 24 or 32
+// bytes, selecting AES-128, AES-192 or AES-256. This is a good choice where
+// AES has hardware acceleration. The encryption key is used as the primary
+// encrypt/decrypt key. Additional decryption-only keys can be provided, to
+// enable key rotation. Nonces are random and 96 bits, so a key should be
+// rotated before it encrypts around 2^32 messages.
+func NewAESGCMAEAD(encryptionKey []byte, additionalDecryptionKeys [][]byte) (AEAD, error) {
+	for _, k := range append([][]byte{encryptionKey}, additionalDecryptionKeys...) {
+		switch len(k) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("keys must be 16, 24 or 32 bytes")
+		}
+		if isZeroKey(k) {
+			return nil, fmt.Errorf("keys cannot be all zeros")
+		}
+	}
+
+	return &aesGCMAEAD{
+		encryptionKey:  encryptionKey,
+		decryptionKeys: additionalDecryptionKeys,
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating AES-GCM cipher: %w", err)
+	}
+	return aead, nil
+}
+
+func (a *aesGCMAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := newGCM(a.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(err)
+	}
+
+	return append(nonce, aead.Seal(nil, nonce, plaintext, associatedData)...), nil
+}
+
+func (a *aesGCMAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	pt, _, err := a.DecryptWithKeyInfo(ciphertext, associatedData)
+	return pt, err
+}
+
+func (a *aesGCMAEAD) DecryptWithKeyInfo(ciphertext, associatedData []byte) (_ []byte, primary bool, _ error) {
+	for i, dk := range append([][]byte{a.encryptionKey}, a.decryptionKeys...) {
+		aead, err := newGCM(dk)
+		if err != nil {
+			return nil, false, err
+		}
+
+		nonceSize := aead.NonceSize()
+		if len(ciphertext) < nonceSize {
+			return nil, false, errors.New("invalid ciphertext")
 		}
 
 		pt, err := aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], associatedData)
@@ -87,12 +229,30 @@ func (x *xchaPolyAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error
 			continue
 		}
 
-		plaintext = pt
-		break
+		return pt, i == 0, nil
 	}
 
-	if plaintext == nil {
-		return nil, fmt.Errorf("failed to decrypt data")
+	return nil, false, fmt.Errorf("failed to decrypt data")
+}
+
+func (a *aesGCMAEAD) KeyCount() int {
+	return 1 + len(a.decryptionKeys)
+}
+
+func (a *aesGCMAEAD) KeyIDs() []string {
+	ids := make([]string, 0, a.KeyCount())
+	for _, k := range append([][]byte{a.encryptionKey}, a.decryptionKeys...) {
+		ids = append(ids, aeadKeyID(k))
 	}
-	return plaintext, nil
+	return ids
+}
+
+// aeadKeyID derives a non-secret identifier for a key. The hash input is
+// prefixed so the ID can not be matched against hashes of the key used
+// elsewhere.
+func aeadKeyID(key []byte) string {
+	h := sha256.New()
+	h.Write([]byte("lds.li/web/session aead key id\x00"))
+	h.Write(key)
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
@@ -3,7 +3,9 @@ package session
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/hex"
 	"io"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/chacha20poly1305"
@@ -169,6 +171,11 @@ func TestXChaPolyAEAD_DecryptWithRotatedKeys(t *testing.T) {
 		t.Errorf("Decrypt() = %v, want %v", decrypted, plaintext)
 	}
 
+	// Verify the old key is reported
+	if _, primary, err := aead.(RotatableAEAD).DecryptWithKeyInfo(ciphertext, associatedData); err != nil || primary {
+		t.Errorf("DecryptWithKeyInfo() primary = %v, err = %v, want old key", primary, err)
+	}
+
 	// Now encrypt with the new key
 	newCiphertext, err := aead.Encrypt(plaintext, associatedData)
 	if err != nil {
@@ -267,3 +274,180 @@ func generateKey(t *testing.T) []byte {
 	}
 	return key
 }
+
+func TestXChaPolyAEAD_KeyInfo(t *testing.T) {
+	primary, old := genXChaPolyKey(), genXChaPolyKey()
+
+	a, err := NewXChaPolyAEAD(primary, [][]byte{old})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki, ok := a.(KeyInfoAEAD)
+	if !ok {
+		t.Fatal("want AEAD to implement KeyInfoAEAD")
+	}
+	if got := ki.KeyCount(); got != 2 {
+		t.Errorf("want 2 keys, got %d", got)
+	}
+
+	ids := ki.KeyIDs()
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("want 2 distinct IDs, got %v", ids)
+	}
+	for i, k := range [][]byte{primary, old} {
+		if strings.Contains(ids[i], hex.EncodeToString(k)[:8]) {
+			t.Errorf("ID %s contains key material", ids[i])
+		}
+	}
+
+	// IDs are stable, so the old key can be tracked after it is the only
+	// one left.
+	b, err := NewXChaPolyAEAD(old, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.(KeyInfoAEAD).KeyIDs(); len(got) != 1 || got[0] != ids[1] {
+		t.Errorf("want ID %s for the same key, got %v", ids[1], got)
+	}
+}
+
+func TestNewAESGCMAEAD(t *testing.T) {
+	tests := []struct {
+		name          string
+		encryptionKey []byte
+		decryptKeys   [][]byte
+		wantErr       bool
+	}{
+		{
+			name:          "AES-128 key",
+			encryptionKey: generateKeyN(t, 16),
+		},
+		{
+			name:          "AES-192 key",
+			encryptionKey: generateKeyN(t, 24),
+		},
+		{
+			name:          "AES-256 key with additional decryption keys",
+			encryptionKey: generateKeyN(t, 32),
+			decryptKeys:   [][]byte{generateKeyN(t, 16), generateKeyN(t, 32)},
+		},
+		{
+			name:          "Invalid key length",
+			encryptionKey: generateKeyN(t, 20),
+			wantErr:       true,
+		},
+		{
+			name:          "Zero key",
+			encryptionKey: make([]byte, 32),
+			wantErr:       true,
+		},
+		{
+			name:          "Valid key with invalid decryption key",
+			encryptionKey: generateKeyN(t, 32),
+			decryptKeys:   [][]byte{generateKeyN(t, 31)},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAESGCMAEAD(tt.encryptionKey, tt.decryptKeys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAESGCMAEAD() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAESGCMAEAD_DecryptWithRotatedKeys(t *testing.T) {
+	oldKey := generateKeyN(t, 16)
+	newKey := generateKeyN(t, 32)
+
+	aead, err := NewAESGCMAEAD(newKey, [][]byte{oldKey})
+	if err != nil {
+		t.Fatalf("Failed to create AEAD: %v", err)
+	}
+	oldAead, err := NewAESGCMAEAD(oldKey, nil)
+	if err != nil {
+		t.Fatalf("Failed to create old AEAD: %v", err)
+	}
+
+	plaintext := []byte("secret message")
+	associatedData := []byte("session-context")
+
+	ciphertext, err := oldAead.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt() with old key error = %v", err)
+	}
+
+	pt, primary, err := aead.(RotatableAEAD).DecryptWithKeyInfo(ciphertext, associatedData)
+	if err != nil {
+		t.Fatalf("DecryptWithKeyInfo() with new AEAD error = %v", err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Errorf("DecryptWithKeyInfo() = %v, want %v", pt, plaintext)
+	}
+	if primary {
+		t.Error("DecryptWithKeyInfo() reported the primary key, want old key")
+	}
+
+	newCiphertext, err := aead.Encrypt(plaintext, associatedData)
+	if err != nil {
+		t.Fatalf("Encrypt() with new key error = %v", err)
+	}
+	if _, primary, err := aead.(RotatableAEAD).DecryptWithKeyInfo(newCiphertext, associatedData); err != nil || !primary {
+		t.Errorf("DecryptWithKeyInfo() primary = %v, err = %v, want primary key", primary, err)
+	}
+	if _, err := oldAead.Decrypt(newCiphertext, associatedData); err == nil {
+		t.Error("Old AEAD should not be able to decrypt data encrypted with new key")
+	}
+}
+
+func TestAESGCMAEAD_DecryptInvalid(t *testing.T) {
+	aead, err := NewAESGCMAEAD(generateKeyN(t, 32), nil)
+	if err != nil {
+		t.Fatalf("Failed to create AEAD: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		ciphertext     []byte
+		associatedData []byte
+	}{
+		{
+			name:       "Empty ciphertext",
+			ciphertext: []byte{},
+		},
+		{
+			name:       "Ciphertext too short",
+			ciphertext: make([]byte, 11),
+		},
+		{
+			name:       "Invalid ciphertext (tampered)",
+			ciphertext: func() []byte { c, _ := aead.Encrypt([]byte("hello"), nil); c[len(c)-1]++; return c }(),
+		},
+		{
+			name:           "Wrong associated data",
+			ciphertext:     func() []byte { c, _ := aead.Encrypt([]byte("hello"), []byte("correct")); return c }(),
+			associatedData: []byte("incorrect"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := aead.Decrypt(tt.ciphertext, tt.associatedData); err == nil {
+				t.Error("Decrypt() want error, got nil")
+			}
+		})
+	}
+}
+
+// generateKeyN generates a random key of n bytes.
+func generateKeyN(t *testing.T, n int) []byte {
+	t.Helper()
+	key := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		t.Fatalf("Failed to generate random key: %v", err)
+	}
+	return key
+}
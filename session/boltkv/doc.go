@@ -0,0 +1,29 @@
+// Package boltkv provides a session store backed by a bbolt database, for
+// single instance apps that want sessions to survive a restart without
+// running a database server.
+//
+// Values are stored in a bucket, prefixed with their expiry time. Expired
+// keys are not returned, and are removed by GC.
+//
+// Usage:
+//
+//	db, err := bbolt.Open("sessions.db", 0o600, nil)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	kv, err := boltkv.New(db, &boltkv.Opts{
+//		BucketName: "my_sessions", // optional, defaults to "web_sessions"
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	// Remove expired sessions in the background
+//	ctx, cancel := context.WithCancel(context.Background())
+//	defer cancel()
+//	wait := kv.RunGC(ctx, 10*time.Minute, slog.Default())
+//	defer wait()
+//
+// This is a separate module, so the root module does not depend on bbolt.
+package boltkv
@@ -0,0 +1,15 @@
+module lds.li/web/session/boltkv
+
+go 1.25
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	lds.li/web v0.0.0
+)
+
+require (
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+)
+
+replace lds.li/web => ../..
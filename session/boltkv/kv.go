@@ -0,0 +1,246 @@
+package boltkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"lds.li/web/session"
+)
+
+var (
+	_ session.TouchableKV       = (*BoltKV)(nil)
+	_ session.PrefixDeletableKV = (*BoltKV)(nil)
+)
+
+const (
+	// DefaultBucketName is the default bucket name for the KV store
+	DefaultBucketName = "web_sessions"
+)
+
+// expiryLen is the length of the big-endian Unix nanosecond expiry that
+// prefixes each stored value.
+const expiryLen = 8
+
+// Opts contains options for configuring the KV store
+type Opts struct {
+	// BucketName is the name of the bucket sessions are stored in (defaults
+	// to "web_sessions").
+	BucketName string
+}
+
+// BoltKV implements the session.KV interface using bbolt
+type BoltKV struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+// New creates a new KV store in an open bbolt database, creating the bucket
+// if it does not exist. The caller is responsible for closing the database.
+func New(db *bbolt.DB, opts *Opts) (*BoltKV, error) {
+	bucket := DefaultBucketName
+	if opts != nil && opts.BucketName != "" {
+		bucket = opts.BucketName
+	}
+
+	kv := &BoltKV{
+		db:     db,
+		bucket: []byte(bucket),
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(kv.bucket)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("creating bucket %s: %w", bucket, err)
+	}
+
+	return kv, nil
+}
+
+// Get retrieves a value by key, checking expiration
+func (k *BoltKV) Get(_ context.Context, key string) (_ []byte, found bool, _ error) {
+	var data []byte
+	err := k.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(k.bucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		expiresAt, value, err := decodeValue(v)
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(expiresAt) {
+			return nil
+		}
+		// values are only valid for the life of the transaction.
+		data = bytes.Clone(value)
+		if data == nil {
+			data = []byte{}
+		}
+		found = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+	}
+	return data, found, nil
+}
+
+// Set stores a key with a given value and expiration time, creating or updating as needed
+func (k *BoltKV) Set(_ context.Context, key string, expiresAt time.Time, value []byte) error {
+	err := k.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(k.bucket).Put([]byte(key), encodeValue(expiresAt, value))
+	})
+	if err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Touch updates the expiration time of an existing key, without changing its
+// data
+func (k *BoltKV) Touch(_ context.Context, key string, expiresAt time.Time) error {
+	err := k.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(k.bucket)
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		_, value, err := decodeValue(v)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), encodeValue(expiresAt, value))
+	})
+	if err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes a key from the store
+func (k *BoltKV) Delete(_ context.Context, key string) error {
+	err := k.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(k.bucket).Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteByPrefix removes all keys that start with the given prefix. This
+// requires the keys to be written with a scheme that encodes the grouping (e.g.
+// a tenant ID) in the prefix.
+func (k *BoltKV) DeleteByPrefix(_ context.Context, prefix string) (deleted int, _ error) {
+	if prefix == "" {
+		return 0, errors.New("prefix must not be empty")
+	}
+
+	err := k.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(k.bucket)
+		var keys [][]byte
+		c := b.Cursor()
+		for key, _ := c.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, _ = c.Next() {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("deleting prefix %s: %w", prefix, err)
+	}
+	return deleted, nil
+}
+
+// GC performs garbage collection, removing expired keys
+func (k *BoltKV) GC(ctx context.Context) (deleted int, _ error) {
+	now := time.Now()
+	err := k.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(k.bucket)
+		// keys are collected first, as deleting while iterating a cursor
+		// can skip entries.
+		var keys [][]byte
+		if err := b.ForEach(func(key, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			expiresAt, _, err := decodeValue(v)
+			if err != nil || !now.Before(expiresAt) {
+				keys = append(keys, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("gc: %w", err)
+	}
+	return deleted, nil
+}
+
+// RunGC starts a background goroutine that performs garbage collection at
+// regular intervals, until the context is cancelled. The returned func blocks
+// until the goroutine has exited, so graceful shutdown can confirm GC has
+// stopped before the database is closed.
+func (k *BoltKV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) (wait func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				if logger != nil {
+					logger.InfoContext(ctx, "Garbage collection stopped", "reason", ctx.Err())
+				}
+				return
+			case <-ticker.C:
+				deleted, err := k.GC(ctx)
+				if err != nil {
+					if logger != nil {
+						logger.ErrorContext(ctx, "Garbage collection failed", "error", err)
+					}
+				} else if logger != nil {
+					logger.InfoContext(ctx, "Garbage collection successful", "deleted_keys", deleted)
+				}
+			}
+		}
+	}()
+	return func() { <-done }
+}
+
+func encodeValue(expiresAt time.Time, value []byte) []byte {
+	b := make([]byte, expiryLen+len(value))
+	binary.BigEndian.PutUint64(b, uint64(expiresAt.UnixNano()))
+	copy(b[expiryLen:], value)
+	return b
+}
+
+func decodeValue(b []byte) (expiresAt time.Time, value []byte, _ error) {
+	if len(b) < expiryLen {
+		return time.Time{}, nil, fmt.Errorf("stored value is %d bytes, shorter than the expiry", len(b))
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), b[expiryLen:], nil
+}
@@ -0,0 +1,114 @@
+package boltkv
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"lds.li/web/session/kvtest"
+)
+
+func openTestDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "sessions.db"), 0o600, nil)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestKV_Bolt(t *testing.T) {
+	db := openTestDB(t)
+
+	kv, err := New(db, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	clearFunc := func() {
+		if err := db.Update(func(tx *bbolt.Tx) error {
+			if err := tx.DeleteBucket([]byte(DefaultBucketName)); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucket([]byte(DefaultBucketName))
+			return err
+		}); err != nil {
+			t.Fatalf("Failed to clear bucket: %v", err)
+		}
+	}
+
+	kvtest.RunComplianceTest(t, kv, clearFunc)
+}
+
+func TestKV_BucketName(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	kv, err := New(db, &Opts{BucketName: "custom"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := kv.Set(ctx, "key", time.Now().Add(time.Hour), []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	if err := db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte(DefaultBucketName)) != nil {
+			t.Errorf("default bucket was created")
+		}
+		b := tx.Bucket([]byte("custom"))
+		if b == nil {
+			t.Fatal("custom bucket was not created")
+		}
+		expiresAt, value, err := decodeValue(b.Get([]byte("key")))
+		if err != nil {
+			return err
+		}
+		if string(value) != "value" {
+			t.Errorf("stored value = %q, want %q", value, "value")
+		}
+		if d := time.Until(expiresAt); d <= 0 || d > time.Hour {
+			t.Errorf("stored expiry in %v, want up to an hour", d)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKV_RunGC(t *testing.T) {
+	db := openTestDB(t)
+
+	kv, err := New(db, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := kv.Set(context.Background(), "expired", time.Now().Add(-time.Hour), []byte("value")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := kv.RunGC(ctx, time.Millisecond, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		_ = db.View(func(tx *bbolt.Tx) error {
+			n = tx.Bucket([]byte(DefaultBucketName)).Stats().KeyN
+			return nil
+		})
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired key was not collected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	wait()
+}
@@ -3,7 +3,9 @@ package session
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"time"
 )
 
@@ -13,6 +15,9 @@ type codec interface {
 
 	// Decode deserializes the session data into a map
 	Decode(data []byte) (persistedSession, error)
+
+	// name identifies the codec, for metadata.
+	name() string
 }
 
 // gobCodec is a codec that uses Go's gob encoding
@@ -43,8 +48,32 @@ type persistedSession struct {
 	UpdatedAt time.Time
 	Flash     flashLevel
 	FlashMsg  string
+	// Fingerprint is the client fingerprint the session is bound to, if
+	// enabled.
+	Fingerprint string
+	// ExpiresAt is the expiry the session was last saved with. It is used to
+	// skip touches that would barely move the expiry.
+	ExpiresAt time.Time
+	// TokenSecret is a random per-session key, used to derive tokens with
+	// Session.Token. It is created on first use.
+	TokenSecret []byte
+	// Persist overrides the manager's SessionCookieOpts.Persist for this
+	// session, set with Session.SetPersistent.
+	Persist persistMode
 }
 
+// persistMode is a per-session override of cookie persistence.
+type persistMode int8
+
+const (
+	// persistDefault uses the manager's setting.
+	persistDefault persistMode = iota
+	persistEnabled
+	persistDisabled
+)
+
+func (g *gobCodec) name() string { return "gob" }
+
 func (g *gobCodec) Encode(sess persistedSession) ([]byte, error) {
 	var buf bytes.Buffer
 
@@ -65,3 +94,35 @@ func (g *gobCodec) Decode(data []byte) (persistedSession, error) {
 
 	return result, nil
 }
+
+// decodeFailureDataPrefixLen is the number of bytes of undecodable session
+// data logged, enough to identify the format without exposing its contents.
+const decodeFailureDataPrefixLen = 8
+
+// decodeFailureAttrs describes session data that failed to decode, for
+// debugging. It logs the length, the first few bytes, and a guess at the
+// format, but never the full data.
+func decodeFailureAttrs(data []byte) []any {
+	prefix := data[:min(len(data), decodeFailureDataPrefixLen)]
+	return []any{
+		slog.Int("data_len", len(data)),
+		slog.String("data_prefix", hex.EncodeToString(prefix)),
+		slog.String("data_format", guessFormat(data)),
+	}
+}
+
+// guessFormat guesses the encoding of session data.
+func guessFormat(data []byte) string {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case len(trimmed) == 0:
+		return "empty"
+	case trimmed[0] == '{' || trimmed[0] == '[':
+		return "json"
+	// gob streams start with the type definition, which names the type.
+	case bytes.Contains(data, []byte("persistedSession")):
+		return "gob"
+	default:
+		return "unknown"
+	}
+}
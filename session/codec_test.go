@@ -1,6 +1,9 @@
 package session
 
 import (
+	"bytes"
+	"log/slog"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +34,83 @@ func TestGobEncoding(t *testing.T) {
 		t.Fatalf("Data mismatch: %v", decodedData.Data)
 	}
 }
+
+type typedSessionValue struct {
+	Name  string
+	Count int
+}
+
+func TestPutGetT(t *testing.T) {
+	sess := &Session{sessdata: persistedSession{Data: map[string]any{}}}
+
+	Put(sess, "value", typedSessionValue{Name: "test", Count: 1})
+	Put(sess, "ptr", &typedSessionValue{Name: "ptr", Count: 2})
+
+	g := &gobCodec{}
+	encoded, err := g.Encode(sess.sessdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := g.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess = &Session{sessdata: decoded}
+
+	v, ok := GetT[typedSessionValue](sess, "value")
+	if !ok || v.Name != "test" || v.Count != 1 {
+		t.Errorf("unexpected value %#v (ok: %t)", v, ok)
+	}
+	p, ok := GetT[*typedSessionValue](sess, "ptr")
+	if !ok || p.Name != "ptr" || p.Count != 2 {
+		t.Errorf("unexpected pointer value %#v (ok: %t)", p, ok)
+	}
+	if _, ok := GetT[string](sess, "value"); ok {
+		t.Error("want wrong type to not be ok")
+	}
+	if _, ok := GetT[typedSessionValue](sess, "missing"); ok {
+		t.Error("want missing key to not be ok")
+	}
+}
+
+func TestGuessFormat(t *testing.T) {
+	gobData, err := (&gobCodec{}).Encode(persistedSession{Data: map[string]any{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "gob", data: gobData, want: "gob"},
+		{name: "truncated gob", data: gobData[:len(gobData)-4], want: "gob"},
+		{name: "json", data: []byte(` {"Data":{}}`), want: "json"},
+		{name: "empty", data: nil, want: "empty"},
+		{name: "unknown", data: []byte{0x00, 0x01, 0x02}, want: "unknown"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := guessFormat(tc.data); got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeFailureAttrs(t *testing.T) {
+	data := []byte(`{"Data":{"secret":"value"}}`)
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Warn("decode failed", decodeFailureAttrs(data)...)
+
+	got := buf.String()
+	for _, want := range []string{"data_len=27", "data_prefix=7b2244617461223a", "data_format=json"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want %q in log, got: %s", want, got)
+		}
+	}
+	if strings.Contains(got, "secret") {
+		t.Errorf("log contains session contents: %s", got)
+	}
+}
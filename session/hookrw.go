@@ -19,25 +19,37 @@ type hookRW struct {
 	// response because it handled it.
 	hook     func(http.ResponseWriter) bool
 	hookOnce sync.Once
+	// proceed is the result of the hook.
+	proceed bool
+	// written tracks if the handler wrote a header or body.
+	written bool
 }
 
-func (h *hookRW) Write(b []byte) (int, error) {
-	write := true
+// fire calls the hook if it has not been called yet, returning its result.
+func (h *hookRW) fire() bool {
 	h.hookOnce.Do(func() {
-		write = h.hook(h.ResponseWriter)
+		h.proceed = h.hook(h.ResponseWriter)
 	})
-	if !write {
+	return h.proceed
+}
+
+// Written indicates if the handler has written a header or body to the
+// response.
+func (h *hookRW) Written() bool {
+	return h.written
+}
+
+func (h *hookRW) Write(b []byte) (int, error) {
+	h.written = true
+	if !h.fire() {
 		return 0, errors.New("request interrupted by hook")
 	}
 	return h.ResponseWriter.Write(b)
 }
 
 func (h *hookRW) WriteHeader(statusCode int) {
-	write := true
-	h.hookOnce.Do(func() {
-		write = h.hook(h.ResponseWriter)
-	})
-	if write {
+	h.written = true
+	if h.fire() {
 		h.ResponseWriter.WriteHeader(statusCode)
 	}
 }
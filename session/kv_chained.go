@@ -0,0 +1,56 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type chainedKV struct {
+	primary  KV
+	fallback KV
+}
+
+// ChainedKV returns a KV for migrating between stores without logging users
+// out. Get reads from primary, then falls back to fallback if the key is not
+// found. Set only writes to primary, so sessions move to it as they are saved.
+// Delete removes the key from both, so a session deleted on logout can not be
+// read back from the fallback.
+//
+// The returned KV does not implement TouchableKV, so extending a session's idle
+// timeout re-saves it, which also moves it to primary. Once the sessions in
+// fallback have expired, it can be removed.
+func ChainedKV(primary, fallback KV) KV {
+	return &chainedKV{primary: primary, fallback: fallback}
+}
+
+func (c *chainedKV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	v, found, err := c.primary.Get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting from primary: %w", err)
+	}
+	if found {
+		return v, true, nil
+	}
+	v, found, err = c.fallback.Get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting from fallback: %w", err)
+	}
+	return v, found, nil
+}
+
+func (c *chainedKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	return c.primary.Set(ctx, key, expiresAt, value)
+}
+
+func (c *chainedKV) Delete(ctx context.Context, key string) error {
+	var errs []error
+	if err := c.primary.Delete(ctx, key); err != nil {
+		errs = append(errs, fmt.Errorf("deleting from primary: %w", err))
+	}
+	if err := c.fallback.Delete(ctx, key); err != nil {
+		errs = append(errs, fmt.Errorf("deleting from fallback: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"lds.li/web/session"
+	"lds.li/web/session/kvtest"
+)
+
+func TestChainedKV_Compliance(t *testing.T) {
+	kv := session.ChainedKV(session.NewMemoryKV(), session.NewMemoryKV())
+
+	kvtest.RunComplianceTest(t, kv, nil)
+}
+
+func TestChainedKV_Migration(t *testing.T) {
+	ctx := context.Background()
+	primary, fallback := session.NewMemoryKV(), session.NewMemoryKV()
+	kv := session.ChainedKV(primary, fallback)
+
+	exp := time.Now().Add(time.Hour)
+	if err := fallback.Set(ctx, "old", exp, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// reads fall back to the old store
+	v, found, err := kv.Get(ctx, "old")
+	if err != nil || !found || string(v) != "old" {
+		t.Fatalf("want old value from fallback, got %q found: %v err: %v", v, found, err)
+	}
+
+	// writes only go to the primary
+	if err := kv.Set(ctx, "old", exp, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := primary.Get(ctx, "old"); string(v) != "new" {
+		t.Errorf("want new value in primary, got %q", v)
+	}
+	if v, _, _ := fallback.Get(ctx, "old"); string(v) != "old" {
+		t.Errorf("want fallback unchanged, got %q", v)
+	}
+	if v, _, _ := kv.Get(ctx, "old"); string(v) != "new" {
+		t.Errorf("want primary value preferred, got %q", v)
+	}
+
+	// deletes remove from both, so the session is not read back
+	if err := kv.Delete(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := kv.Get(ctx, "old"); found {
+		t.Error("want deleted key to not be found")
+	}
+}
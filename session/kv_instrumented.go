@@ -0,0 +1,150 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// KVOp identifies a KV operation reported by InstrumentedKV.
+type KVOp string
+
+const (
+	KVOpGet            KVOp = "get"
+	KVOpSet            KVOp = "set"
+	KVOpDelete         KVOp = "delete"
+	KVOpTouch          KVOp = "touch"
+	KVOpDeleteByPrefix KVOp = "delete_by_prefix"
+	KVOpGC             KVOp = "gc"
+)
+
+// KVRecorder receives measurements of KV operations from InstrumentedKV, e.g.
+// to export them as metrics or trace spans.
+type KVRecorder interface {
+	// RecordKVOp is called after each operation completes, with how long it
+	// took and the error it returned. For KVOpGet found reports if the key was
+	// found, to track the hit ratio. It is false for other operations.
+	RecordKVOp(ctx context.Context, op KVOp, duration time.Duration, found bool, err error)
+}
+
+// gcKV is implemented by stores with garbage collection, e.g. sqlkv. It
+// matches kvtest.GC.
+type gcKV interface {
+	GC(ctx context.Context) (deleted int, _ error)
+}
+
+type instrumentedKV struct {
+	kv       KV
+	recorder KVRecorder
+}
+
+// InstrumentedKV returns a KV that reports the timing and outcome of each
+// operation on kv to recorder. This works with any KV, and can be composed
+// with the other KV wrappers such as ChainedKV. The returned KV implements
+// TouchableKV and PrefixDeletableKV only if kv does, so the manager's
+// behavior is unchanged. Likewise if kv has a GC(ctx) (int, error) method
+// for garbage collection, the returned KV has one that is instrumented as
+// KVOpGC. Maintenance the store runs itself, e.g. sqlkv's RunGC, calls the
+// store directly so is not instrumented.
+func InstrumentedKV(kv KV, recorder KVRecorder) KV {
+	i := &instrumentedKV{kv: kv, recorder: recorder}
+	_, touchable := kv.(TouchableKV)
+	_, prefixDeletable := kv.(PrefixDeletableKV)
+	_, gc := kv.(gcKV)
+	t, p, g := instrumentedToucher{i}, instrumentedPrefixDeleter{i}, instrumentedGCer{i}
+	switch {
+	case touchable && prefixDeletable && gc:
+		return &struct {
+			*instrumentedKV
+			instrumentedToucher
+			instrumentedPrefixDeleter
+			instrumentedGCer
+		}{i, t, p, g}
+	case touchable && prefixDeletable:
+		return &struct {
+			*instrumentedKV
+			instrumentedToucher
+			instrumentedPrefixDeleter
+		}{i, t, p}
+	case touchable && gc:
+		return &struct {
+			*instrumentedKV
+			instrumentedToucher
+			instrumentedGCer
+		}{i, t, g}
+	case prefixDeletable && gc:
+		return &struct {
+			*instrumentedKV
+			instrumentedPrefixDeleter
+			instrumentedGCer
+		}{i, p, g}
+	case touchable:
+		return &struct {
+			*instrumentedKV
+			instrumentedToucher
+		}{i, t}
+	case prefixDeletable:
+		return &struct {
+			*instrumentedKV
+			instrumentedPrefixDeleter
+		}{i, p}
+	case gc:
+		return &struct {
+			*instrumentedKV
+			instrumentedGCer
+		}{i, g}
+	default:
+		return i
+	}
+}
+
+func (i *instrumentedKV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	start := time.Now()
+	v, found, err := i.kv.Get(ctx, key)
+	i.recorder.RecordKVOp(ctx, KVOpGet, time.Since(start), found, err)
+	return v, found, err
+}
+
+func (i *instrumentedKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	start := time.Now()
+	err := i.kv.Set(ctx, key, expiresAt, value)
+	i.recorder.RecordKVOp(ctx, KVOpSet, time.Since(start), false, err)
+	return err
+}
+
+func (i *instrumentedKV) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.kv.Delete(ctx, key)
+	i.recorder.RecordKVOp(ctx, KVOpDelete, time.Since(start), false, err)
+	return err
+}
+
+// The optional methods are on separate types, so InstrumentedKV can compose
+// only those the wrapped KV supports. They hold the instrumentedKV as a named
+// field, so embedding several does not make Get, Set and Delete ambiguous.
+
+type instrumentedToucher struct{ i *instrumentedKV }
+
+func (t instrumentedToucher) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	start := time.Now()
+	err := t.i.kv.(TouchableKV).Touch(ctx, key, expiresAt)
+	t.i.recorder.RecordKVOp(ctx, KVOpTouch, time.Since(start), false, err)
+	return err
+}
+
+type instrumentedPrefixDeleter struct{ i *instrumentedKV }
+
+func (p instrumentedPrefixDeleter) DeleteByPrefix(ctx context.Context, prefix string) (deleted int, _ error) {
+	start := time.Now()
+	deleted, err := p.i.kv.(PrefixDeletableKV).DeleteByPrefix(ctx, prefix)
+	p.i.recorder.RecordKVOp(ctx, KVOpDeleteByPrefix, time.Since(start), false, err)
+	return deleted, err
+}
+
+type instrumentedGCer struct{ i *instrumentedKV }
+
+func (g instrumentedGCer) GC(ctx context.Context) (deleted int, _ error) {
+	start := time.Now()
+	deleted, err := g.i.kv.(gcKV).GC(ctx)
+	g.i.recorder.RecordKVOp(ctx, KVOpGC, time.Since(start), false, err)
+	return deleted, err
+}
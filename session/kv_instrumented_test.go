@@ -0,0 +1,112 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"lds.li/web/session"
+	"lds.li/web/session/kvtest"
+)
+
+type recordedOp struct {
+	Op    session.KVOp
+	Found bool
+	Err   bool
+}
+
+type opRecorder struct {
+	ops []recordedOp
+}
+
+func (r *opRecorder) RecordKVOp(_ context.Context, op session.KVOp, _ time.Duration, found bool, err error) {
+	r.ops = append(r.ops, recordedOp{Op: op, Found: found, Err: err != nil})
+}
+
+func TestInstrumentedKV_Compliance(t *testing.T) {
+	kv := session.InstrumentedKV(session.NewMemoryKV(), &opRecorder{})
+
+	kvtest.RunComplianceTest(t, kv, nil)
+}
+
+func TestInstrumentedKV_Records(t *testing.T) {
+	ctx := context.Background()
+	rec := &opRecorder{}
+	kv := session.InstrumentedKV(session.NewMemoryKV(), rec)
+
+	exp := time.Now().Add(time.Hour)
+	_, _, _ = kv.Get(ctx, "key")
+	_ = kv.Set(ctx, "key", exp, []byte("value"))
+	_, _, _ = kv.Get(ctx, "key")
+	_ = kv.(session.TouchableKV).Touch(ctx, "key", exp)
+	_, _ = kv.(session.PrefixDeletableKV).DeleteByPrefix(ctx, "")
+	_ = kv.Delete(ctx, "key")
+
+	want := []recordedOp{
+		{Op: session.KVOpGet},
+		{Op: session.KVOpSet},
+		{Op: session.KVOpGet, Found: true},
+		{Op: session.KVOpTouch},
+		{Op: session.KVOpDeleteByPrefix, Err: true},
+		{Op: session.KVOpDelete},
+	}
+	if diff := cmp.Diff(want, rec.ops); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestInstrumentedKV_OptionalInterfaces(t *testing.T) {
+	// ChainedKV implements neither optional interface.
+	kv := session.InstrumentedKV(session.ChainedKV(session.NewMemoryKV(), session.NewMemoryKV()), &opRecorder{})
+	if _, ok := kv.(session.TouchableKV); ok {
+		t.Error("want TouchableKV only if the wrapped KV implements it")
+	}
+	if _, ok := kv.(session.PrefixDeletableKV); ok {
+		t.Error("want PrefixDeletableKV only if the wrapped KV implements it")
+	}
+	if _, ok := kv.(kvtest.GC); ok {
+		t.Error("want GC only if the wrapped KV implements it")
+	}
+}
+
+// gcMemoryKV adds garbage collection to the memory KV, like a SQL store.
+type gcMemoryKV struct {
+	session.TouchableKV
+	deleted int
+}
+
+func (g *gcMemoryKV) GC(context.Context) (deleted int, _ error) {
+	return g.deleted, nil
+}
+
+func TestInstrumentedKV_GC(t *testing.T) {
+	ctx := context.Background()
+	rec := &opRecorder{}
+	inner := &gcMemoryKV{TouchableKV: session.NewMemoryKV().(session.TouchableKV), deleted: 3}
+	kv := session.InstrumentedKV(inner, rec)
+
+	gc, ok := kv.(kvtest.GC)
+	if !ok {
+		t.Fatal("want GC if the wrapped KV implements it")
+	}
+	if _, ok := kv.(session.TouchableKV); !ok {
+		t.Error("want TouchableKV to be kept alongside GC")
+	}
+	if _, ok := kv.(session.PrefixDeletableKV); ok {
+		t.Error("want PrefixDeletableKV only if the wrapped KV implements it")
+	}
+
+	deleted, err := gc.GC(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 3 {
+		t.Errorf("want 3 deleted, got %d", deleted)
+	}
+	if diff := cmp.Diff([]recordedOp{{Op: session.KVOpGC}}, rec.ops); diff != "" {
+		t.Error(diff)
+	}
+
+	kvtest.RunComplianceTest(t, kv, nil)
+}
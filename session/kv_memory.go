@@ -2,6 +2,8 @@ package session
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,20 +18,31 @@ type memoryKV struct {
 	contentsMu sync.RWMutex
 }
 
+var (
+	_ TouchableKV       = (*memoryKV)(nil)
+	_ PrefixDeletableKV = (*memoryKV)(nil)
+)
+
 func NewMemoryKV() KV {
 	return &memoryKV{contents: make(map[string]kvItem)}
 }
 
 func (m *memoryKV) Get(_ context.Context, key string) (_ []byte, found bool, _ error) {
 	m.contentsMu.RLock()
-	defer m.contentsMu.RUnlock()
-
 	v, ok := m.contents[key]
+	m.contentsMu.RUnlock()
+
 	if !ok {
 		return nil, false, nil
 	}
 	if time.Now().After(v.expiresAt) {
-		delete(m.contents, key)
+		// expired items are removed under the write lock, re-checking in case
+		// the item was updated in the mean time.
+		m.contentsMu.Lock()
+		if cur, ok := m.contents[key]; ok && time.Now().After(cur.expiresAt) {
+			delete(m.contents, key)
+		}
+		m.contentsMu.Unlock()
 		return nil, false, nil
 	}
 	return v.data, true, nil
@@ -46,6 +59,19 @@ func (m *memoryKV) Set(_ context.Context, key string, expiresAt time.Time, value
 	return nil
 }
 
+func (m *memoryKV) Touch(_ context.Context, key string, expiresAt time.Time) error {
+	m.contentsMu.Lock()
+	defer m.contentsMu.Unlock()
+
+	v, ok := m.contents[key]
+	if !ok {
+		return nil
+	}
+	v.expiresAt = expiresAt
+	m.contents[key] = v
+	return nil
+}
+
 func (m *memoryKV) Delete(_ context.Context, key string) error {
 	m.contentsMu.Lock()
 	defer m.contentsMu.Unlock()
@@ -53,3 +79,20 @@ func (m *memoryKV) Delete(_ context.Context, key string) error {
 	delete(m.contents, key)
 	return nil
 }
+
+func (m *memoryKV) DeleteByPrefix(_ context.Context, prefix string) (deleted int, _ error) {
+	if prefix == "" {
+		return 0, errors.New("prefix must not be empty")
+	}
+
+	m.contentsMu.Lock()
+	defer m.contentsMu.Unlock()
+
+	for k := range m.contents {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.contents, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
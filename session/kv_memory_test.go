@@ -1,4 +1,4 @@
-package storee2e
+package session_test
 
 import (
 	"testing"
@@ -7,7 +7,7 @@ import (
 	"lds.li/web/session/kvtest"
 )
 
-func TestMemoryKV_E2E(t *testing.T) {
+func TestMemoryKV_Compliance(t *testing.T) {
 	kv := session.NewMemoryKV()
 
 	kvtest.RunComplianceTest(t, kv, nil)
@@ -71,13 +71,42 @@ func RunComplianceTest(t *testing.T, kv session.KV, cleanup func()) {
 		ctx := context.Background()
 		key := "nonexistentkey"
 
-		_, found, err := kv.Get(ctx, key)
+		value, found, err := kv.Get(ctx, key)
 		if err != nil {
-			t.Fatalf("Get() error = %v", err)
+			t.Fatalf("Get() error = %v, a missing key should not be an error", err)
 		}
 		if found {
 			t.Errorf("Get() found = %v, want %v", found, false)
 		}
+		if len(value) != 0 {
+			t.Errorf("Get() value = %q, want no data for a missing key", value)
+		}
+	})
+
+	t.Run("GetEmptyValue", func(t *testing.T) {
+		if cleanup != nil {
+			cleanup()
+		}
+
+		// A key with an empty value exists, and must be distinguishable from
+		// a missing key.
+		ctx := context.Background()
+		key := "emptykey"
+
+		if err := kv.Set(ctx, key, time.Now().Add(time.Hour), []byte{}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+
+		value, found, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if !found {
+			t.Errorf("Get() found = %v, want %v for an empty value", found, true)
+		}
+		if len(value) != 0 {
+			t.Errorf("Get() value = %q, want empty", value)
+		}
 	})
 
 	t.Run("Upsert", func(t *testing.T) {
@@ -143,6 +172,12 @@ func RunComplianceTest(t *testing.T, kv session.KV, cleanup func()) {
 
 	// Additional tests for any KV implementations that support GC
 	t.Run("GC", testGC(kv, cleanup))
+
+	// Additional tests for any KV implementations that support Touch
+	t.Run("Touch", testTouch(kv, cleanup))
+
+	// Additional tests for any KV implementations that support DeleteByPrefix
+	t.Run("DeleteByPrefix", testDeleteByPrefix(kv, cleanup))
 }
 
 // assertJSONEqual checks if two JSON byte slices are semantically equal
@@ -224,3 +259,118 @@ func testGC(kv session.KV, cleanup func()) func(t *testing.T) {
 		}
 	}
 }
+
+// testTouch tests updating expiry if the KV implements session.TouchableKV
+func testTouch(kv session.KV, cleanup func()) func(t *testing.T) {
+	return func(t *testing.T) {
+		tkv, ok := kv.(session.TouchableKV)
+		if !ok {
+			t.Skip("KV implementation does not support Touch")
+		}
+
+		if cleanup != nil {
+			cleanup()
+		}
+
+		ctx := context.Background()
+		key := "touchkey"
+		value := []byte(`{"value":"touched"}`)
+
+		// Set a key that is about to expire
+		err := kv.Set(ctx, key, time.Now().Add(2*time.Second), value)
+		if err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+
+		// Extend it, and make sure the data is unchanged
+		if err := tkv.Touch(ctx, key, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Touch() error = %v", err)
+		}
+		retrievedValue, found, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if !found {
+			t.Fatalf("Get() found = %v, want %v", found, true)
+		}
+		assertJSONEqual(t, value, retrievedValue)
+
+		// Expire it via touch
+		if err := tkv.Touch(ctx, key, time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("Touch() error = %v", err)
+		}
+		_, found, err = kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if found {
+			t.Errorf("Get() found = %v, want %v", found, false)
+		}
+
+		// Touching a missing key should not error
+		if err := tkv.Touch(ctx, "nonexistentkey", time.Now().Add(time.Hour)); err != nil {
+			t.Errorf("Touch() on missing key error = %v", err)
+		}
+	}
+}
+
+// testDeleteByPrefix tests bulk deletion if the KV implements
+// session.PrefixDeletableKV
+func testDeleteByPrefix(kv session.KV, cleanup func()) func(t *testing.T) {
+	return func(t *testing.T) {
+		pkv, ok := kv.(session.PrefixDeletableKV)
+		if !ok {
+			t.Skip("KV implementation does not support DeleteByPrefix")
+		}
+
+		if cleanup != nil {
+			cleanup()
+		}
+
+		ctx := context.Background()
+		expiresAt := time.Now().Add(time.Hour)
+		value := []byte(`{"value":1}`)
+
+		// include characters that are wildcards in SQL LIKE and GLOB, to make
+		// sure they are treated literally, and a key that only differs by
+		// case, to make sure the prefix match is case sensitive.
+		deleteKeys := []string{"tenant_a%*:1", "tenant_a%*:2"}
+		keepKeys := []string{"tenant_b:1", "tenantXa%*:1", "tenant_a%:1", "TENANT_A%*:3"}
+		for _, k := range append(deleteKeys, keepKeys...) {
+			if err := kv.Set(ctx, k, expiresAt, value); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+		}
+
+		deleted, err := pkv.DeleteByPrefix(ctx, "tenant_a%*:")
+		if err != nil {
+			t.Fatalf("DeleteByPrefix() error = %v", err)
+		}
+		if deleted != len(deleteKeys) {
+			t.Errorf("DeleteByPrefix() deleted = %d, want %d", deleted, len(deleteKeys))
+		}
+
+		for _, k := range deleteKeys {
+			_, found, err := kv.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if found {
+				t.Errorf("Get(%s) found = %v, want %v", k, found, false)
+			}
+		}
+		for _, k := range keepKeys {
+			_, found, err := kv.Get(ctx, k)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if !found {
+				t.Errorf("Get(%s) found = %v, want %v", k, found, true)
+			}
+		}
+
+		if _, err := pkv.DeleteByPrefix(ctx, ""); err == nil {
+			t.Error("DeleteByPrefix() with empty prefix should error")
+		}
+	}
+}
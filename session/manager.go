@@ -1,14 +1,17 @@
 package session
 
 import (
+	"compress/zlib"
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,6 +35,22 @@ func MustFromContext(ctx context.Context) *Session {
 	return sess
 }
 
+// CurrentSessionIDHash returns the hash of the ID of the session loaded for
+// the request. Prefixed with ManagerOpts.KVKeyPrefix, this is the key the
+// session is stored under in the KV, so it can be used to track or revoke the
+// session without handling the ID itself.
+// It returns false if there is no session in the context, it is stored in a
+// cookie, or it is new. It reflects the session at the start of the request,
+// a reset or delete stores the session under a new ID when the response is
+// written.
+func CurrentSessionIDHash(ctx context.Context) (string, bool) {
+	sess, ok := FromContext(ctx)
+	if !ok || sess.idHash == "" {
+		return "", false
+	}
+	return sess.idHash, true
+}
+
 // storageMode identifies the session storage mechanism
 type storageMode int
 
@@ -42,6 +61,17 @@ const (
 	storageModeKV
 )
 
+func (s storageMode) String() string {
+	switch s {
+	case storageModeCookie:
+		return "cookie"
+	case storageModeKV:
+		return "kv"
+	default:
+		return fmt.Sprintf("storageMode(%d)", int(s))
+	}
+}
+
 // Manager handles both session data and storage.
 type Manager struct {
 	// Storage settings
@@ -50,6 +80,8 @@ type Manager struct {
 	// Cookie-mode settings
 	aead                AEAD
 	compressionDisabled bool
+	// compression pools compressors for cookie sessions.
+	compression *compressionPool
 
 	// KV-mode settings
 	kv KV
@@ -58,16 +90,145 @@ type Manager struct {
 	cookieSettings SessionCookieOpts
 	codec          codec
 	opts           ManagerOpts
+
+	// reencrypted counts cookie sessions loaded with an old key.
+	reencrypted atomic.Int64
 }
 
 var DefaultIdleTimeout = 24 * time.Hour
 
+// DefaultSessionIDBytes is the default number of random bytes in a session ID.
+const DefaultSessionIDBytes = 16
+
 // SessionCookieOpts configures cookie behavior for sessions
 type SessionCookieOpts struct {
+	// Name of the cookie. If not set, a name with the strongest valid prefix
+	// is used: __Host- for host-only cookies, or __Secure- if Domain is set.
+	// Names with a prefix are validated against the prefix's rules.
 	Name     string
 	Path     string
 	Insecure bool
 	Persist  bool
+
+	// BaseURL is the URL the app is served from, typically the server's
+	// Config.BaseURL. If set and Insecure is not, the Secure attribute is
+	// derived from its scheme: http URLs get insecure cookies, so local
+	// development over plain HTTP works without setting Insecure. Set Insecure
+	// to force insecure cookies, or leave BaseURL unset to always use secure
+	// ones. The __Host- and __Secure- name prefixes require secure cookies, so
+	// an explicit Name with one of these is rejected for http base URLs.
+	BaseURL *url.URL
+
+	// Domain scopes the cookie to a domain and its subdomains, e.g. to share
+	// the session between app.example.com and api.example.com. If not set,
+	// the cookie is only sent to the host that set it. Cookies with a Domain
+	// can not use the __Host- prefix.
+	Domain string
+
+	// SameSite sets the SameSite attribute of the cookie. Defaults to
+	// http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// Partitioned sets the Partitioned attribute (CHIPS) on the cookie, so it
+	// can be used when the app is embedded cross-site, e.g. in an iframe.
+	// Browsers only accept partitioned cookies that are also Secure, so this
+	// requires SameSite to be http.SameSiteNoneMode and Insecure to be false.
+	Partitioned bool
+
+	// Customize is called with each session cookie after the options above
+	// are applied, so it can set attributes not covered by them, e.g. new or
+	// experimental ones supported by http.Cookie. It must not change the
+	// Name or Value. The result is validated against the name prefix rules
+	// when the manager is created, so it can not make a __Host- or __Secure-
+	// cookie insecure. Per-session overrides, e.g. from Session.SetPersistent,
+	// are applied after it.
+	Customize func(*http.Cookie)
+}
+
+// Cookie name prefixes, see
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Reference/Headers/Set-Cookie#cookie_prefixes
+const (
+	cookiePrefixHost   = "__Host-"
+	cookiePrefixSecure = "__Secure-"
+)
+
+// setDefaults derives Insecure from the BaseURL, and fills in the cookie name
+// and path if they are not set, using the strongest prefix valid for the
+// options.
+func (c *SessionCookieOpts) setDefaults(baseName string) {
+	if !c.Insecure && c.BaseURL != nil && c.BaseURL.Scheme == "http" {
+		c.Insecure = true
+	}
+	if c.Name != "" {
+		return
+	}
+	switch {
+	case c.Insecure:
+		c.Name = baseName
+	case c.Domain != "":
+		c.Name = cookiePrefixSecure + baseName
+	default:
+		c.Name = cookiePrefixHost + baseName
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+}
+
+func (c *SessionCookieOpts) validate() error {
+	switch {
+	case strings.HasPrefix(c.Name, cookiePrefixHost):
+		if c.Insecure {
+			return fmt.Errorf("cookie %s: %s cookies must be secure", c.Name, cookiePrefixHost)
+		}
+		if c.Domain != "" {
+			return fmt.Errorf("cookie %s: %s cookies can not have a Domain, use the %s prefix instead", c.Name, cookiePrefixHost, cookiePrefixSecure)
+		}
+		if c.Path != "/" {
+			return fmt.Errorf("cookie %s: %s cookies must have a Path of /", c.Name, cookiePrefixHost)
+		}
+	case strings.HasPrefix(c.Name, cookiePrefixSecure):
+		if c.Insecure {
+			return fmt.Errorf("cookie %s: %s cookies must be secure", c.Name, cookiePrefixSecure)
+		}
+	}
+	if c.Partitioned {
+		if c.SameSite != http.SameSiteNoneMode {
+			return errors.New("partitioned cookies require SameSite=None")
+		}
+		if c.Insecure {
+			return errors.New("partitioned cookies must be secure")
+		}
+	}
+	if c.Customize != nil {
+		if err := c.validateCustomized(); err != nil {
+			return fmt.Errorf("cookie %s: customized cookie: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateCustomized checks a cookie built with Customize still follows the
+// name prefix rules, and is still the session cookie.
+func (c *SessionCookieOpts) validateCustomized() error {
+	hc := c.newCookie(time.Now())
+	switch {
+	case hc.Name != c.Name:
+		return errors.New("the name can not be changed")
+	case hc.Value != "":
+		return errors.New("the value can not be set")
+	case strings.HasPrefix(hc.Name, cookiePrefixHost):
+		if !hc.Secure || hc.Domain != "" || hc.Path != "/" {
+			return fmt.Errorf("%s cookies must be secure, with no Domain and a Path of /", cookiePrefixHost)
+		}
+	case strings.HasPrefix(hc.Name, cookiePrefixSecure):
+		if !hc.Secure {
+			return fmt.Errorf("%s cookies must be secure", cookiePrefixSecure)
+		}
+	}
+	if hc.Partitioned && !hc.Secure {
+		return errors.New("partitioned cookies must be secure")
+	}
+	return nil
 }
 
 // newCookie creates a cookie with the configured options
@@ -75,13 +236,44 @@ func (c *SessionCookieOpts) newCookie(exp time.Time) *http.Cookie {
 	hc := &http.Cookie{
 		Name:     c.Name,
 		Path:     c.Path,
+		Domain:   c.Domain,
 		Secure:   !c.Insecure,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	}
+	if c.SameSite != 0 {
+		hc.SameSite = c.SameSite
+	}
+	hc.Partitioned = c.Partitioned
 	if c.Persist {
 		hc.MaxAge = int(time.Until(exp).Seconds())
 	}
+	if c.Customize != nil {
+		c.Customize(hc)
+	}
+	return hc
+}
+
+// newCookie creates a session cookie for the request, applying any per-request
+// overrides from the session.
+func (m *Manager) newCookie(r *http.Request, exp time.Time) *http.Cookie {
+	hc := m.cookieSettings.newCookie(exp)
+	if r == nil {
+		return hc
+	}
+	sess, ok := r.Context().Value(sessionContextKey{}).(*Session)
+	if !ok {
+		return hc
+	}
+	if sess.sameSite != 0 {
+		hc.SameSite = sess.sameSite
+	}
+	switch sess.sessdata.Persist {
+	case persistEnabled:
+		hc.MaxAge = int(time.Until(exp).Seconds())
+	case persistDisabled:
+		hc.MaxAge = 0
+	}
 	return hc
 }
 
@@ -93,8 +285,118 @@ type ManagerOpts struct {
 	Onload func(map[string]any) map[string]any
 	// Cookie settings
 	CookieOpts *SessionCookieOpts
+	// SessionIDBytes is the number of random bytes used to generate session
+	// IDs in KV mode. The ID is base32 encoded in the cookie, and the KV key is
+	// a hash of the ID, so this affects entropy but not storage size. Defaults
+	// to 16 (128 bits), values lower than this are rejected.
+	SessionIDBytes int
+	// KVKeyPrefix is prepended to the key sessions are stored under in KV
+	// mode, e.g. "tenant-a:" for a manager per tenant. With a
+	// PrefixDeletableKV, all of the manager's sessions can then be removed
+	// with DeleteAllSessions. Changing it orphans existing sessions.
+	KVKeyPrefix string
+	// SaveFailureMode controls what happens to the request when the session
+	// can not be persisted. Defaults to SaveFailureModeFailRequest.
+	SaveFailureMode SaveFailureMode
+	// MaxDecompressionRatio limits the size of decompressed cookie session
+	// data, as a multiple of the maximum cookie size (4096 bytes). Cookies
+	// that decompress to more than this are rejected. Defaults to 64.
+	MaxDecompressionRatio int
+	// CompressionLevel is the zlib compression level used for cookie session
+	// data, from zlib.BestSpeed to zlib.BestCompression. Defaults to
+	// zlib.BestCompression, as fitting in the cookie size limit matters more
+	// than the CPU cost for small session data.
+	CompressionLevel int
+	// CompressionPoolSize is the maximum number of idle compressors, and of
+	// decompressors, kept for reuse by a cookie manager. This bounds the
+	// memory held after a burst of requests with large sessions. Defaults to
+	// DefaultCompressionPoolSize.
+	CompressionPoolSize int
+	// TouchThreshold reduces writes for sessions that are read but not
+	// modified. With an IdleTimeout, the expiry of these sessions is extended
+	// on every request. If set, the expiry is only extended when it would move
+	// by at least this much, e.g. a minute. Extending the expiry then re-saves
+	// the session, rather than touching it, so the new expiry is stored with
+	// the session and the next request compares against it. Defaults to zero,
+	// which extends the expiry on every request.
+	TouchThreshold time.Duration
+	// Fingerprint optionally binds sessions to a coarse fingerprint of the
+	// client, e.g. a hash of the User-Agent or the client's IP /24. It is
+	// stored in the session when saved, and compared when the session is
+	// loaded. On a mismatch FingerprintMismatchAction is taken. This can make
+	// a stolen session cookie harder to use, but fingerprints that change
+	// during legitimate use will affect users. For example, mobile clients
+	// change IP address frequently, and browsers update their User-Agent.
+	// Sessions saved without a fingerprint adopt the current one.
+	Fingerprint func(*http.Request) string
+	// FingerprintMismatchAction controls what happens when a loaded session's
+	// fingerprint does not match the request. Defaults to
+	// FingerprintMismatchInvalidate.
+	FingerprintMismatchAction FingerprintMismatchAction
+	// LogDecodeFailureData logs a warning describing session data that fails
+	// to decode, to help diagnose corrupt sessions, e.g. from a codec change
+	// or truncated data. It logs the data length, the first few bytes in hex,
+	// and a guess at the format, but not the full contents. Intended for
+	// debugging, leave unset in production.
+	LogDecodeFailureData bool
+	// ExposeExpiryHeader sets the ExpiryHeader response header to the
+	// session's expiry, as Unix seconds, whenever an existing session is
+	// saved, touched, or loaded. This lets client-side code schedule a refresh
+	// or warn before the session ends, as the cookie is not readable from
+	// JavaScript. It is not set if the session has no expiry.
+	ExposeExpiryHeader bool
+	// SkipForRequest can return true to skip session handling for a request,
+	// e.g. for crawlers or health checks by User-Agent or path. Wrap then
+	// passes the request straight through: no session is loaded or put in
+	// the context, and no cookie is issued. Handlers that can serve skipped
+	// requests must use FromContext, as MustFromContext panics.
+	SkipForRequest func(*http.Request) bool
 }
 
+// ExpiryHeader is the response header set when ManagerOpts.ExposeExpiryHeader
+// is enabled.
+const ExpiryHeader = "X-Session-Expires-At"
+
+// FingerprintMismatchAction controls how the manager handles a session whose
+// fingerprint does not match the current request.
+type FingerprintMismatchAction int
+
+const (
+	// FingerprintMismatchInvalidate discards the loaded session, starting a
+	// new one. The old session is deleted.
+	FingerprintMismatchInvalidate FingerprintMismatchAction = iota
+	// FingerprintMismatchFlag loads the session as normal, but flags it so the
+	// mismatch is reported by Session.FingerprintMismatch. This lets the app
+	// decide what to do, e.g. requiring re-authentication. The new
+	// fingerprint is stored the next time the session is saved.
+	FingerprintMismatchFlag
+)
+
+// DefaultMaxDecompressionRatio is the default for
+// ManagerOpts.MaxDecompressionRatio.
+const DefaultMaxDecompressionRatio = 64
+
+// DefaultCompressionPoolSize is the default for
+// ManagerOpts.CompressionPoolSize.
+const DefaultCompressionPoolSize = 16
+
+// SaveFailureMode controls how the manager handles errors persisting the
+// session at the end of a request.
+type SaveFailureMode int
+
+const (
+	// SaveFailureModeFailRequest responds to the request with a 500 error if
+	// the session can not be saved.
+	SaveFailureModeFailRequest SaveFailureMode = iota
+	// SaveFailureModeLogAndContinue logs the error, and serves the response
+	// without persisting the session. This can be used to keep serving pages
+	// during a brief storage outage, at the cost of session changes made
+	// during the outage being lost. Failures deleting a session, e.g. on
+	// logout or Reset, still fail the request, as the old session would
+	// otherwise stay valid.
+	SaveFailureModeLogAndContinue
+)
+
 // NewCookieManager creates a new Manager that stores session data in cookies
 func NewCookieManager(aead AEAD, opts *ManagerOpts) (*Manager, error) {
 	m := &Manager{
@@ -114,14 +416,34 @@ func NewCookieManager(aead AEAD, opts *ManagerOpts) (*Manager, error) {
 		return nil, errors.New("at least one of idle timeout or max lifetime must be specified")
 	}
 
+	if m.opts.MaxDecompressionRatio < 0 {
+		return nil, fmt.Errorf("invalid max decompression ratio %d", m.opts.MaxDecompressionRatio)
+	}
+	if m.opts.MaxDecompressionRatio == 0 {
+		m.opts.MaxDecompressionRatio = DefaultMaxDecompressionRatio
+	}
+
+	if m.opts.CompressionLevel == 0 {
+		m.opts.CompressionLevel = zlib.BestCompression
+	}
+	if !validCompressionLevel(m.opts.CompressionLevel) {
+		return nil, fmt.Errorf("invalid compression level %d", m.opts.CompressionLevel)
+	}
+	if m.opts.CompressionPoolSize < 0 {
+		return nil, fmt.Errorf("invalid compression pool size %d", m.opts.CompressionPoolSize)
+	}
+	if m.opts.CompressionPoolSize == 0 {
+		m.opts.CompressionPoolSize = DefaultCompressionPoolSize
+	}
+	m.compression = newCompressionPool(m.opts.CompressionLevel, m.opts.CompressionPoolSize)
+
 	// Set cookie options
 	if m.opts.CookieOpts != nil {
 		m.cookieSettings = *m.opts.CookieOpts
-	} else {
-		m.cookieSettings = SessionCookieOpts{
-			Name: "__Host-session",
-			Path: "/",
-		}
+	}
+	m.cookieSettings.setDefaults("session")
+	if err := m.cookieSettings.validate(); err != nil {
+		return nil, fmt.Errorf("invalid cookie options: %w", err)
 	}
 
 	return m, nil
@@ -146,14 +468,20 @@ func NewKVManager(kv KV, opts *ManagerOpts) (*Manager, error) {
 		return nil, errors.New("at least one of idle timeout or max lifetime must be specified")
 	}
 
+	if m.opts.SessionIDBytes == 0 {
+		m.opts.SessionIDBytes = DefaultSessionIDBytes
+	}
+	if m.opts.SessionIDBytes < DefaultSessionIDBytes {
+		return nil, fmt.Errorf("session ID bytes must be at least %d", DefaultSessionIDBytes)
+	}
+
 	// Set cookie options
 	if m.opts.CookieOpts != nil {
 		m.cookieSettings = *m.opts.CookieOpts
-	} else {
-		m.cookieSettings = SessionCookieOpts{
-			Name: "__Host-session-id",
-			Path: "/",
-		}
+	}
+	m.cookieSettings.setDefaults("session-id")
+	if err := m.cookieSettings.validate(); err != nil {
+		return nil, fmt.Errorf("invalid cookie options: %w", err)
 	}
 
 	return m, nil
@@ -175,6 +503,10 @@ func (m *Manager) Wrap(next http.Handler) http.Handler {
 		if _, ok := r.Context().Value(sessionContextKey{}).(*Session); ok {
 			panic("session middleware wrapped more than once")
 		}
+		if m.opts.SkipForRequest != nil && m.opts.SkipForRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Create new session context with initial metadata
 		sctx := &Session{
@@ -185,30 +517,47 @@ func (m *Manager) Wrap(next http.Handler) http.Handler {
 		}
 
 		// Load session data if it exists
-		data, err := m.loadSession(r)
+		sessdata, data, oldKey, err := m.loadSession(r)
 		if err != nil {
 			// Log the error but don't fail the request - just start a new session
 			slog.WarnContext(r.Context(), "Failed to load session, starting a new one", "err", err)
+		} else if data != nil && len(data) == 0 {
+			// the session exists with no stored data, so it is empty rather
+			// than corrupt. The ID is kept, so saving updates it in place.
+			sctx.datab = data
 		} else if data != nil {
-			// Try to decode the data
-			decodedData, err := m.codec.Decode(data)
-			if err != nil {
-				// Log the error but don't fail the request - just start a new session
-				slog.WarnContext(r.Context(), "Failed to decode session data, starting a new session", "err", err)
-			} else {
-				sctx.sessdata = decodedData
-
-				// track the original data for idle timeout handling
-				if m.opts.IdleTimeout != 0 {
-					sctx.datab = data
-				}
+			sctx.sessdata = sessdata
+
+			if oldKey {
+				// re-save, so the response encrypts it with the
+				// primary key.
+				slog.DebugContext(r.Context(), "Session encrypted with old key, re-encrypting")
+				m.reencrypted.Add(1)
+				sctx.save = true
+			}
 
-				if m.opts.Onload != nil {
-					sctx.sessdata.Data = m.opts.Onload(sctx.sessdata.Data)
-				}
+			// track the original data, so the session can be touched
+			// without re-encoding it.
+			sctx.datab = data
+
+			if m.opts.Onload != nil {
+				sctx.sessdata.Data = m.opts.Onload(sctx.sessdata.Data)
 			}
 		}
 
+		if m.opts.Fingerprint != nil {
+			m.checkFingerprint(r, sctx)
+		}
+
+		sctx.meta = SessionMetadata{
+			Codec:   m.codec.name(),
+			Storage: m.storageMode.String(),
+		}
+		if id := getManagerSessionIDFromContext(r, m); id != "" {
+			sctx.idHash = managerHashSessionID(id)
+			sctx.meta.IDHashPrefix = sctx.idHash[:sessionMetadataIDHashPrefixLen]
+		}
+
 		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sctx))
 
 		hw := &hookRW{
@@ -220,77 +569,235 @@ func (m *Manager) Wrap(next http.Handler) http.Handler {
 
 		// if the handler doesn't write anything, make sure we fire the hook
 		// anyway.
-		hw.hookOnce.Do(func() {
-			hw.hook(hw.ResponseWriter)
-		})
+		if !hw.Written() {
+			hw.fire()
+		}
 	})
 }
 
+// checkFingerprint compares the loaded session's fingerprint to the request,
+// acting on a mismatch. The current fingerprint is set on the session, so it
+// is persisted on save.
+func (m *Manager) checkFingerprint(r *http.Request, sctx *Session) {
+	fp := m.opts.Fingerprint(r)
+	if sctx.sessdata.Fingerprint != "" && sctx.sessdata.Fingerprint != fp {
+		switch m.opts.FingerprintMismatchAction {
+		case FingerprintMismatchFlag:
+			slog.WarnContext(r.Context(), "Session fingerprint mismatch, flagging session")
+			sctx.fingerprintMismatch = true
+		default:
+			slog.WarnContext(r.Context(), "Session fingerprint mismatch, invalidating session")
+			// delete the old session now, so it is gone even if the handler
+			// starts a new one.
+			if m.storageMode == storageModeKV {
+				if id := getManagerSessionIDFromContext(r, m); id != "" {
+					if err := m.kv.Delete(r.Context(), m.kvKey(id)); err != nil {
+						slog.ErrorContext(r.Context(), "Failed to delete session with mismatched fingerprint", "err", err)
+					}
+				}
+				// never re-use the old ID for a new session.
+				setManagerSessionIDInContext(r, m, "")
+			}
+			sctx.sessdata = persistedSession{
+				Data:      make(map[string]any),
+				CreatedAt: time.Now(),
+			}
+			sctx.datab = nil
+			sctx.delete = true
+		}
+	}
+	sctx.sessdata.Fingerprint = fp
+}
+
 // Storage methods
 
-// loadSession retrieves session data from the appropriate storage
-func (m *Manager) loadSession(r *http.Request) ([]byte, error) {
-	cookie, err := r.Cookie(m.cookieSettings.Name)
-	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			// No session exists
-			return nil, nil
+// loadSession retrieves and decodes the session from the appropriate storage.
+// data is the stored session, nil if there is none, or empty if it exists
+// with no data. oldKey indicates that a cookie session was encrypted with a
+// non-primary key.
+//
+// Clients can send more than one cookie with the session name, e.g. after
+// racing writes from multiple tabs, or cookies set for different paths. Each
+// is tried in order, and the first that loads and decodes is used.
+func (m *Manager) loadSession(r *http.Request) (_ persistedSession, data []byte, oldKey bool, _ error) {
+	var errs []error
+	for _, cookie := range r.CookiesNamed(m.cookieSettings.Name) {
+		data, oldKey, err := m.loadSessionFromCookie(r, cookie)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if len(data) == 0 {
+			return persistedSession{}, data, false, nil
 		}
-		return nil, fmt.Errorf("getting cookie %s: %w", m.cookieSettings.Name, err)
+		sessdata, err := m.codec.Decode(data)
+		if err != nil {
+			if m.opts.LogDecodeFailureData {
+				slog.WarnContext(r.Context(), "Failed to decode session data", append([]any{"err", err}, decodeFailureAttrs(data)...)...)
+			}
+			errs = append(errs, fmt.Errorf("decoding session data: %w", err))
+			continue
+		}
+		return sessdata, data, oldKey, nil
 	}
+	return persistedSession{}, nil, false, errors.Join(errs...)
+}
 
+func (m *Manager) loadSessionFromCookie(r *http.Request, cookie *http.Cookie) (_ []byte, oldKey bool, _ error) {
 	switch m.storageMode {
 	case storageModeCookie:
 		return m.loadFromCookie(cookie.Value)
 	case storageModeKV:
-		return m.loadFromKV(r.Context(), cookie.Value)
+		data, err := m.loadFromKV(r.Context(), cookie.Value)
+		if errors.Is(err, ErrSessionNotFound) {
+			// the ID is stale, treat it like there is no cookie.
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		// track the ID, so saving the loaded session updates it in place.
+		setManagerSessionIDInContext(r, m, cookie.Value)
+		return data, false, nil
+	default:
+		return nil, false, fmt.Errorf("unknown storage mode: %v", m.storageMode)
+	}
+}
+
+// ReencryptedSessions returns the number of cookie sessions that have been
+// loaded with an old key since the manager was created, and re-encrypted with
+// the primary key. This requires an AEAD that implements RotatableAEAD. When
+// this stays at zero for longer than the session lifetime, old keys can be
+// retired. It can be exported as a metric.
+func (m *Manager) ReencryptedSessions() int64 {
+	return m.reencrypted.Load()
+}
+
+// saveAction is what the save hook does with the session's storage.
+type saveAction int
+
+const (
+	// saveActionNone leaves the stored session as-is.
+	saveActionNone saveAction = iota
+	// saveActionSave writes the session.
+	saveActionSave
+	// saveActionTouch extends the stored session's expiry.
+	saveActionTouch
+)
+
+// saveDecision decides what to do with the session at the end of the request.
+// deleteFirst indicates the stored session should be deleted, before the
+// action is taken.
+func (m *Manager) saveDecision(sctx *Session, cancelled bool) (deleteFirst bool, action saveAction) {
+	dirty := sctx.save || sctx.delete || sctx.reset
+
+	// If the client has gone away, there is no point extending the
+	// session's lifetime.
+	if cancelled && !dirty {
+		return false, saveActionNone
+	}
+	if sctx.readOnly && !sctx.delete && !sctx.reset {
+		return false, saveActionNone
+	}
+
+	deleteFirst = sctx.delete || sctx.reset
+	switch {
+	case sctx.save || sctx.reset:
+		return deleteFirst, saveActionSave
+	case sctx.extend && len(sctx.datab) != 0:
+		return deleteFirst, saveActionTouch
+	case m.opts.IdleTimeout == 0 || len(sctx.datab) == 0:
+		// nothing loaded, or nothing to extend.
+		return deleteFirst, saveActionNone
+	case m.opts.TouchThreshold > 0:
+		// Only extend the lifetime if it has moved enough. This saves rather
+		// than touches, as a touch would leave the stored ExpiresAt behind
+		// and every later request would pass the threshold again.
+		touched := sctx.sessdata
+		touched.UpdatedAt = time.Now()
+		if m.calculateExpiry(touched).Sub(sctx.sessdata.ExpiresAt) < m.opts.TouchThreshold {
+			return deleteFirst, saveActionNone
+		}
+		return deleteFirst, saveActionSave
 	default:
-		return nil, fmt.Errorf("unknown storage mode: %v", m.storageMode)
+		return deleteFirst, saveActionTouch
 	}
 }
 
 func (m *Manager) saveHook(r *http.Request, sctx *Session) func(w http.ResponseWriter) bool {
 	return func(w http.ResponseWriter) bool {
+		deleteFirst, action := m.saveDecision(sctx, r.Context().Err() != nil)
+		if !deleteFirst && action == saveActionNone {
+			switch {
+			case r.Context().Err() != nil:
+				slog.DebugContext(r.Context(), "request cancelled, skipping session touch")
+			case sctx.readOnly && sctx.save:
+				slog.DebugContext(r.Context(), "session is read-only, discarding changes")
+			}
+			if len(sctx.datab) != 0 {
+				m.setExpiryHeader(w, sctx.sessdata.ExpiresAt)
+			}
+			return true
+		}
+
+		// Changes are persisted even if the request was cancelled, so they
+		// are not lost if the client disconnects after the handler acted.
+		sr := r.WithContext(context.WithoutCancel(r.Context()))
+
 		// Update the metadata timestamp
 		sctx.sessdata.UpdatedAt = time.Now()
 
-		// If we need to delete the session
-		if sctx.delete || sctx.reset {
-			if err := m.deleteSession(w, r, sctx); err != nil {
+		if deleteFirst {
+			// the old session stays valid if this fails, so always fail the
+			// request rather than continue as if it was removed.
+			if err := m.deleteSession(w, sr, sctx); err != nil {
 				m.handleErr(w, r, err)
 				return false
 			}
 		}
 
-		// If we need to save the session
-		if sctx.save || sctx.reset {
-			if err := m.saveSession(w, r, sctx); err != nil {
-				m.handleErr(w, r, err)
-				return false
+		switch action {
+		case saveActionSave:
+			if err := m.saveSession(w, sr, sctx); err != nil {
+				return m.handleSaveErr(w, r, err)
 			}
-		} else if m.opts.IdleTimeout != 0 && len(sctx.datab) != 0 {
+		case saveActionTouch:
 			// Just touch the session to update its lifetime
-			if err := m.touchSession(w, r, sctx); err != nil {
-				m.handleErr(w, r, err)
-				return false
+			if err := m.touchSession(w, sr, sctx); err != nil {
+				return m.handleSaveErr(w, r, err)
 			}
 		}
+		if action != saveActionNone {
+			m.setExpiryHeader(w, m.calculateExpiry(sctx.sessdata))
+		}
 
 		return true
 	}
 }
 
+// setExpiryHeader sets the ExpiryHeader, if enabled and the session expires.
+func (m *Manager) setExpiryHeader(w http.ResponseWriter, expiresAt time.Time) {
+	if !m.opts.ExposeExpiryHeader || expiresAt.IsZero() {
+		return
+	}
+	w.Header().Set(ExpiryHeader, strconv.FormatInt(expiresAt.Unix(), 10))
+}
+
 // saveSession saves the session data to the appropriate storage
 func (m *Manager) saveSession(w http.ResponseWriter, r *http.Request, sctx *Session) error {
+	// Calculate expiry, tracking it in the session
+	expiresAt := m.calculateExpiry(sctx.sessdata)
+	sctx.sessdata.ExpiresAt = expiresAt
+
 	// Encode session data
 	data, err := m.codec.Encode(sctx.sessdata)
 	if err != nil {
 		return fmt.Errorf("encoding session data: %w", err)
 	}
 
-	// Calculate expiry
-	expiresAt := m.calculateExpiry(sctx.sessdata)
-
 	switch m.storageMode {
 	case storageModeCookie:
 		return m.saveToCookie(w, r, expiresAt, data)
@@ -304,7 +811,7 @@ func (m *Manager) saveSession(w http.ResponseWriter, r *http.Request, sctx *Sess
 // deleteSession deletes the session from the appropriate storage
 func (m *Manager) deleteSession(w http.ResponseWriter, r *http.Request, sctx *Session) error {
 	// Delete cookie regardless of storage mode
-	dc := m.cookieSettings.newCookie(time.Time{})
+	dc := m.newCookie(r, time.Time{})
 	dc.MaxAge = -1
 	managerRemoveCookieByName(w, dc.Name)
 	http.SetCookie(w, dc)
@@ -321,14 +828,14 @@ func (m *Manager) deleteSession(w http.ResponseWriter, r *http.Request, sctx *Se
 		}
 
 		if sessionID != "" {
-			storeKey := managerHashSessionID(sessionID)
+			storeKey := m.kvKey(sessionID)
 			if err := m.kv.Delete(r.Context(), storeKey); err != nil {
 				return fmt.Errorf("deleting from KV: %w", err)
 			}
 		}
 
 		// Generate a new ID for potential future use
-		setManagerSessionIDInContext(r, m, rand.Text())
+		setManagerSessionIDInContext(r, m, m.newSessionID())
 	}
 
 	return nil
@@ -354,14 +861,19 @@ func (m *Manager) touchSession(w http.ResponseWriter, r *http.Request, sctx *Ses
 			setManagerSessionIDInContext(r, m, sessionID)
 		}
 
-		// Update KV expiry
-		storeKey := managerHashSessionID(sessionID)
-		if err := m.kv.Set(r.Context(), storeKey, expiresAt, sctx.datab); err != nil {
+		// Update KV expiry, avoiding re-writing the data if the store supports
+		// it.
+		storeKey := m.kvKey(sessionID)
+		if tkv, ok := m.kv.(TouchableKV); ok {
+			if err := tkv.Touch(r.Context(), storeKey, expiresAt); err != nil {
+				return fmt.Errorf("touching KV expiry: %w", err)
+			}
+		} else if err := m.kv.Set(r.Context(), storeKey, expiresAt, sctx.datab); err != nil {
 			return fmt.Errorf("updating KV expiry: %w", err)
 		}
 
 		// Update cookie expiry
-		cookie := m.cookieSettings.newCookie(expiresAt)
+		cookie := m.newCookie(r, expiresAt)
 		cookie.Value = sessionID
 
 		managerRemoveCookieByName(w, cookie.Name)
@@ -443,7 +955,23 @@ func managerRemoveCookieByName(w http.ResponseWriter, cookieName string) {
 	}
 }
 
+// handleSaveErr handles an error persisting the session according to the
+// configured SaveFailureMode. It returns true if the response should continue.
+func (m *Manager) handleSaveErr(w http.ResponseWriter, r *http.Request, err error) bool {
+	if m.opts.SaveFailureMode == SaveFailureModeLogAndContinue {
+		slog.ErrorContext(r.Context(), "failed to persist session, continuing request", "err", err)
+		return true
+	}
+	m.handleErr(w, r, err)
+	return false
+}
+
 func (m *Manager) handleErr(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// the client has gone away, so there is nobody to send the error to.
+		slog.WarnContext(r.Context(), "error in session manager for cancelled request", "err", err)
+		return
+	}
 	slog.ErrorContext(r.Context(), "error in session manager", "err", err)
 	http.Error(w, "Internal Error", http.StatusInternalServerError)
 }
@@ -10,7 +10,7 @@ import (
 )
 
 // saveToCookie saves session data directly to a cookie
-func (m *Manager) saveToCookie(w http.ResponseWriter, _ *http.Request, expiresAt time.Time, data []byte) error {
+func (m *Manager) saveToCookie(w http.ResponseWriter, r *http.Request, expiresAt time.Time, data []byte) error {
 	// Add expiry time to data
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, uint64(expiresAt.Unix()))
@@ -19,8 +19,8 @@ func (m *Manager) saveToCookie(w http.ResponseWriter, _ *http.Request, expiresAt
 	// Apply compression if needed
 	magic := managerCookieMagic
 	if !m.compressionDisabled && len(dataWithExpiry) > managerCompressThreshold {
-		cw := getCompressor()
-		defer putCompressor(cw)
+		cw := m.compression.getCompressor()
+		defer m.compression.putCompressor(cw)
 
 		b, err := cw.Compress(dataWithExpiry)
 		if err != nil {
@@ -43,7 +43,7 @@ func (m *Manager) saveToCookie(w http.ResponseWriter, _ *http.Request, expiresAt
 	}
 
 	// Set cookie
-	cookie := m.cookieSettings.newCookie(expiresAt)
+	cookie := m.newCookie(r, expiresAt)
 	cookie.Value = cookieValue
 
 	http.SetCookie(w, cookie)
@@ -52,11 +52,11 @@ func (m *Manager) saveToCookie(w http.ResponseWriter, _ *http.Request, expiresAt
 }
 
 // loadFromCookie extracts and decrypts session data from a cookie value
-func (m *Manager) loadFromCookie(cookieValue string) ([]byte, error) {
+func (m *Manager) loadFromCookie(cookieValue string) (_ []byte, oldKey bool, _ error) {
 	// Split and validate format
 	sp := strings.SplitN(cookieValue, ".", 2)
 	if len(sp) != 2 {
-		return nil, errors.New("cookie does not contain two . separated parts")
+		return nil, false, errors.New("cookie does not contain two . separated parts")
 	}
 
 	magic := sp[0]
@@ -65,40 +65,47 @@ func (m *Manager) loadFromCookie(cookieValue string) ([]byte, error) {
 	// Decode
 	decodedData, err := managerCookieValueEncoding.DecodeString(encodedData)
 	if err != nil {
-		return nil, fmt.Errorf("decoding cookie string: %w", err)
+		return nil, false, fmt.Errorf("decoding cookie string: %w", err)
 	}
 
 	// Validate magic
 	if magic != managerCompressedCookieMagic && magic != managerCookieMagic {
-		return nil, fmt.Errorf("cookie has bad magic prefix: %s", magic)
+		return nil, false, fmt.Errorf("cookie has bad magic prefix: %s", magic)
 	}
 
 	// Decrypt using cookie name as associated data
-	decryptedData, err := m.aead.Decrypt(decodedData, []byte(m.cookieSettings.Name))
+	var decryptedData []byte
+	if ra, ok := m.aead.(RotatableAEAD); ok {
+		var primary bool
+		decryptedData, primary, err = ra.DecryptWithKeyInfo(decodedData, []byte(m.cookieSettings.Name))
+		oldKey = !primary
+	} else {
+		decryptedData, err = m.aead.Decrypt(decodedData, []byte(m.cookieSettings.Name))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("decrypting cookie: %w", err)
+		return nil, false, fmt.Errorf("decrypting cookie: %w", err)
 	}
 
 	// Decompress if needed
 	if magic == managerCompressedCookieMagic {
-		cr := getDecompressor()
-		defer putDecompressor(cr)
-		b, err := cr.Decompress(decryptedData)
+		cr := m.compression.getDecompressor()
+		defer m.compression.putDecompressor(cr)
+		b, err := cr.Decompress(decryptedData, int64(m.opts.MaxDecompressionRatio)*managerMaxCookieSize)
 		if err != nil {
-			return nil, fmt.Errorf("decompressing cookie: %w", err)
+			return nil, false, fmt.Errorf("decompressing cookie: %w", err)
 		}
 		decryptedData = b
 	}
 
 	// Check expiry
 	if len(decryptedData) < 8 {
-		return nil, errors.New("decrypted data too short")
+		return nil, false, errors.New("decrypted data too short")
 	}
 	expiresAt := time.Unix(int64(binary.LittleEndian.Uint64(decryptedData[:8])), 0)
 	if expiresAt.Before(time.Now()) {
-		return nil, fmt.Errorf("cookie expired at %s", expiresAt)
+		return nil, false, fmt.Errorf("cookie expired at %s", expiresAt)
 	}
 
 	// Return actual data (without expiry)
-	return decryptedData[8:], nil
+	return decryptedData[8:], oldKey, nil
 }
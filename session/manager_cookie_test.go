@@ -3,7 +3,10 @@ package session
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -127,7 +130,7 @@ func TestCookieManager_RoundTrip(t *testing.T) {
 			}
 
 			// Load the cookie back
-			loadedData, err := mgr.loadFromCookie(cookieValue)
+			loadedData, _, err := mgr.loadFromCookie(cookieValue)
 
 			if tt.expectRoundTripError {
 				if err == nil {
@@ -215,7 +218,7 @@ func TestCookieManager_MultipleRoundTrips(t *testing.T) {
 	}
 
 	cookies1 := w1.Result().Cookies()
-	loadedData1, err := mgr.loadFromCookie(cookies1[0].Value)
+	loadedData1, _, err := mgr.loadFromCookie(cookies1[0].Value)
 	if err != nil {
 		t.Fatalf("Error in first load: %v", err)
 	}
@@ -234,7 +237,7 @@ func TestCookieManager_MultipleRoundTrips(t *testing.T) {
 	}
 
 	cookies2 := w2.Result().Cookies()
-	loadedData2, err := mgr.loadFromCookie(cookies2[0].Value)
+	loadedData2, _, err := mgr.loadFromCookie(cookies2[0].Value)
 	if err != nil {
 		t.Fatalf("Error in second load: %v", err)
 	}
@@ -295,7 +298,7 @@ func TestCookieManager_CompressionLogic(t *testing.T) {
 	}
 
 	// Now try to load it back
-	loadedData, err := mgr.loadFromCookie(cookieValue)
+	loadedData, _, err := mgr.loadFromCookie(cookieValue)
 	if err != nil {
 		t.Fatalf("Error loading cookie: %v", err)
 	}
@@ -366,3 +369,245 @@ func randBytes(n int) []byte {
 	}
 	return b
 }
+
+// TestCookieManager_DecompressionBomb tests that cookies which decompress to a
+// huge size are rejected.
+func TestCookieManager_DecompressionBomb(t *testing.T) {
+	aead, err := NewXChaPolyAEAD(genXChaPolyKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mgr, err := NewCookieManager(aead, &ManagerOpts{
+		IdleTimeout:           time.Hour,
+		MaxDecompressionRatio: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1MiB of zeros compresses to ~1KiB, so fits in a cookie.
+	bomb := make([]byte, 1<<20)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := mgr.saveToCookie(w, r, time.Now().Add(time.Hour), bomb); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("want 1 cookie, got %d", len(cookies))
+	}
+
+	_, _, err = mgr.loadFromCookie(cookies[0].Value)
+	if !errors.Is(err, errDecompressedTooLarge) {
+		t.Errorf("want errDecompressedTooLarge, got: %v", err)
+	}
+
+	// data within the limit still loads
+	small := make([]byte, 2*managerMaxCookieSize)
+	w = httptest.NewRecorder()
+	if err := mgr.saveToCookie(w, r, time.Now().Add(time.Hour), small); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := mgr.loadFromCookie(w.Result().Cookies()[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, small) {
+		t.Error("data mismatch after round trip")
+	}
+
+	if _, err := NewCookieManager(aead, &ManagerOpts{IdleTimeout: time.Hour, MaxDecompressionRatio: -1}); err == nil {
+		t.Error("want error for negative max decompression ratio")
+	}
+}
+
+func TestCookieManager_ReencryptOldKey(t *testing.T) {
+	oldKey, newKey := genXChaPolyKey(), genXChaPolyKey()
+
+	oldAEAD, err := NewXChaPolyAEAD(oldKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedAEAD, err := NewXChaPolyAEAD(newKey, [][]byte{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newAEAD, err := NewXChaPolyAEAD(newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := func(mgr *Manager) http.Handler {
+		return mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess := MustFromContext(r.Context())
+			if r.URL.Path == "/set" {
+				sess.Set("key", "value")
+			}
+			_, _ = w.Write([]byte(fmt.Sprint(sess.Get("key"))))
+		}))
+	}
+
+	oldMgr, err := NewCookieManager(oldAEAD, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler(oldMgr).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	oldCookie := rec.Result().Cookies()[0]
+
+	// the rotated manager reads the old session, and re-issues it
+	rotatedMgr, err := NewCookieManager(rotatedAEAD, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/read", nil)
+	req.AddCookie(oldCookie)
+	rec = httptest.NewRecorder()
+	handler(rotatedMgr).ServeHTTP(rec, req)
+	if rec.Body.String() != "value" {
+		t.Fatalf("want session readable with old key, got %q", rec.Body.String())
+	}
+	if got := rotatedMgr.ReencryptedSessions(); got != 1 {
+		t.Errorf("want 1 re-encrypted session, got %d", got)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("want session cookie re-issued, got %d cookies", len(cookies))
+	}
+
+	// once the old key is retired, the re-issued session is still readable
+	newMgr, err := NewCookieManager(newAEAD, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/read", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	handler(newMgr).ServeHTTP(rec, req)
+	if rec.Body.String() != "value" {
+		t.Errorf("want session readable with new key, got %q", rec.Body.String())
+	}
+	if got := newMgr.ReencryptedSessions(); got != 0 {
+		t.Errorf("want no re-encrypted sessions, got %d", got)
+	}
+}
+
+func TestCookieManager_Extend(t *testing.T) {
+	mgr, err := NewCookieManager(must(NewXChaPolyAEAD(genXChaPolyKey(), nil)), &ManagerOpts{
+		MaxLifetime: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		switch r.URL.Path {
+		case "/set":
+			sess.Set("key", "value")
+		case "/extend":
+			sess.Extend()
+		}
+		_, _ = w.Write([]byte(fmt.Sprint(sess.Get("key"))))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookie := rec.Result().Cookies()[0]
+
+	for _, tc := range []struct {
+		path        string
+		wantCookies int
+	}{
+		{path: "/read", wantCookies: 0},
+		{path: "/extend", wantCookies: 1},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != tc.wantCookies {
+			t.Fatalf("%s: want %d cookies, got %d", tc.path, tc.wantCookies, len(cookies))
+		}
+		if len(cookies) == 0 {
+			continue
+		}
+
+		// the extended session still has its data
+		req = httptest.NewRequest(http.MethodGet, "/read", nil)
+		req.AddCookie(cookies[0])
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Body.String() != "value" {
+			t.Errorf("%s: want extended session data, got %q", tc.path, rec.Body.String())
+		}
+	}
+}
+
+func newFuzzCookieManager(f *testing.F) *Manager {
+	aead, err := NewXChaPolyAEAD(bytes.Repeat([]byte{1}, 32), nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	mgr, err := NewCookieManager(aead, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return mgr
+}
+
+// FuzzCookieManager_LoadFromCookie passes attacker controlled cookie values
+// through the load path. They should fail to decrypt, but must never panic.
+func FuzzCookieManager_LoadFromCookie(f *testing.F) {
+	mgr := newFuzzCookieManager(f)
+
+	w := httptest.NewRecorder()
+	if err := mgr.saveToCookie(w, httptest.NewRequest("GET", "/", nil), time.Now().Add(time.Hour), []byte("data")); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(w.Result().Cookies()[0].Value)
+	for _, s := range []string{"", ".", "EU1.", "EC1.", "EU1.AAAA", "EC1.!!!!", "EU1.a.b"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		_, _, _ = mgr.loadFromCookie(value)
+	})
+}
+
+// FuzzCookieManager_LoadDecrypted encrypts the input, to exercise the parsing
+// after decryption. This is only reachable with the key, but should still fail
+// cleanly on any input.
+func FuzzCookieManager_LoadDecrypted(f *testing.F) {
+	mgr := newFuzzCookieManager(f)
+
+	for _, seed := range [][]byte{
+		nil,
+		{1},
+		{1, 2, 3, 4, 5, 6, 7},
+		binary.LittleEndian.AppendUint64(nil, uint64(time.Now().Add(time.Hour).Unix())),
+		append(binary.LittleEndian.AppendUint64(nil, uint64(time.Now().Add(time.Hour).Unix())), "data"...),
+	} {
+		f.Add(false, seed)
+		f.Add(true, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, compressed bool, payload []byte) {
+		magic := managerCookieMagic
+		if compressed {
+			magic = managerCompressedCookieMagic
+		}
+		ct, err := mgr.aead.Encrypt(payload, []byte(mgr.cookieSettings.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _, err := mgr.loadFromCookie(magic + "." + managerCookieValueEncoding.EncodeToString(ct))
+		if err != nil {
+			return
+		}
+		_, _ = mgr.codec.Decode(data)
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -16,17 +18,41 @@ type KV interface {
 	Delete(_ context.Context, key string) error
 }
 
+// TouchableKV is an optional interface a KV can implement to update the expiry
+// of an existing key without re-writing its value. This is used when extending
+// a session's idle timeout, and is cheaper for large sessions or stores with
+// native TTL support. Touching a key that does not exist should not error.
+type TouchableKV interface {
+	KV
+	Touch(_ context.Context, key string, expiresAt time.Time) error
+}
+
+// PrefixDeletableKV is an optional interface a KV can implement to delete all
+// keys that start with a given prefix, for example to evict all sessions for a
+// tenant. The Manager stores sessions under ManagerOpts.KVKeyPrefix followed
+// by a hash of the session ID, so a manager per tenant can evict its sessions
+// with Manager.DeleteAllSessions. Implementations must reject an empty prefix.
+type PrefixDeletableKV interface {
+	KV
+	DeleteByPrefix(_ context.Context, prefix string) (deleted int, _ error)
+}
+
+// ErrSessionNotFound is returned when a session ID does not exist in the KV,
+// or has expired. This is distinct from a session that exists with no data,
+// which is loaded as an empty session.
+var ErrSessionNotFound = errors.New("session not found")
+
 // saveToKV saves session data to the KV store and puts the ID in a cookie
 func (m *Manager) saveToKV(w http.ResponseWriter, r *http.Request, sctx *Session, expiresAt time.Time, data []byte) error {
 	// Generate or get session ID
 	sessionID := getManagerSessionIDFromContext(r, m)
 	if sessionID == "" || sctx.reset {
-		sessionID = rand.Text()
+		sessionID = m.newSessionID()
 		setManagerSessionIDInContext(r, m, sessionID)
 	}
 
 	// Hash the session ID for storage
-	storeKey := managerHashSessionID(sessionID)
+	storeKey := m.kvKey(sessionID)
 
 	// Store in KV
 	if err := m.kv.Set(r.Context(), storeKey, expiresAt, data); err != nil {
@@ -34,7 +60,7 @@ func (m *Manager) saveToKV(w http.ResponseWriter, r *http.Request, sctx *Session
 	}
 
 	// Set session ID cookie
-	cookie := m.cookieSettings.newCookie(expiresAt)
+	cookie := m.newCookie(r, expiresAt)
 	cookie.Value = sessionID
 
 	managerRemoveCookieByName(w, cookie.Name)
@@ -46,7 +72,7 @@ func (m *Manager) saveToKV(w http.ResponseWriter, r *http.Request, sctx *Session
 // loadFromKV loads session data from the KV store using the ID from the cookie
 func (m *Manager) loadFromKV(ctx context.Context, sessionID string) ([]byte, error) {
 	// Hash the session ID for storage
-	storeKey := managerHashSessionID(sessionID)
+	storeKey := m.kvKey(sessionID)
 
 	// Get data from KV
 	data, found, err := m.kv.Get(ctx, storeKey)
@@ -55,12 +81,57 @@ func (m *Manager) loadFromKV(ctx context.Context, sessionID string) ([]byte, err
 	}
 
 	if !found {
-		return nil, nil
+		return nil, ErrSessionNotFound
+	}
+	if data == nil {
+		// the session exists, so must not be mistaken for a missing one.
+		data = []byte{}
 	}
 
 	return data, nil
 }
 
+var sessionIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newSessionID generates a new random session ID, of the configured length.
+func (m *Manager) newSessionID() string {
+	n := m.opts.SessionIDBytes
+	if n == 0 {
+		n = DefaultSessionIDBytes
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return sessionIDEncoding.EncodeToString(b)
+}
+
+// DeleteAllSessions removes every session stored under the manager's
+// ManagerOpts.KVKeyPrefix, returning the number deleted. It requires a KV
+// manager with a KVKeyPrefix, and a KV that implements PrefixDeletableKV.
+func (m *Manager) DeleteAllSessions(ctx context.Context) (deleted int, _ error) {
+	if m.storageMode != storageModeKV {
+		return 0, errors.New("deleting all sessions requires KV storage")
+	}
+	if m.opts.KVKeyPrefix == "" {
+		return 0, errors.New("deleting all sessions requires a KVKeyPrefix")
+	}
+	pkv, ok := m.kv.(PrefixDeletableKV)
+	if !ok {
+		return 0, fmt.Errorf("KV %T does not support deleting by prefix", m.kv)
+	}
+	deleted, err := pkv.DeleteByPrefix(ctx, m.opts.KVKeyPrefix)
+	if err != nil {
+		return deleted, fmt.Errorf("deleting sessions by prefix: %w", err)
+	}
+	return deleted, nil
+}
+
+// kvKey returns the key the session with the given ID is stored under.
+func (m *Manager) kvKey(id string) string {
+	return m.opts.KVKeyPrefix + managerHashSessionID(id)
+}
+
 // Generate a consistent hash of session ID for KV storage
 func managerHashSessionID(id string) string {
 	h := sha256.New()
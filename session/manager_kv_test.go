@@ -0,0 +1,1136 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingKV wraps a KV, tracking the calls made to it.
+type countingKV struct {
+	KV
+	mu      sync.Mutex
+	gets    int
+	sets    int
+	touches int
+	deletes int
+}
+
+func (c *countingKV) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+	return c.KV.Get(ctx, key)
+}
+
+func (c *countingKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	c.mu.Lock()
+	c.sets++
+	c.mu.Unlock()
+	return c.KV.Set(ctx, key, expiresAt, value)
+}
+
+func (c *countingKV) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	c.deletes++
+	c.mu.Unlock()
+	return c.KV.Delete(ctx, key)
+}
+
+// touchableCountingKV is a countingKV that also implements TouchableKV.
+type touchableCountingKV struct {
+	*countingKV
+}
+
+func (c *touchableCountingKV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	c.mu.Lock()
+	c.touches++
+	c.mu.Unlock()
+	return c.KV.(TouchableKV).Touch(ctx, key, expiresAt)
+}
+
+func TestKVManager_Touch(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		touchable   bool
+		wantSets    int
+		wantTouches int
+	}{
+		{
+			name:        "touchable KV",
+			touchable:   true,
+			wantSets:    1,
+			wantTouches: 1,
+		},
+		{
+			name:        "non-touchable KV",
+			touchable:   false,
+			wantSets:    2,
+			wantTouches: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ckv := &countingKV{KV: NewMemoryKV()}
+			var kv KV = ckv
+			if tc.touchable {
+				kv = &touchableCountingKV{countingKV: ckv}
+			}
+
+			mgr, err := NewKVManager(kv, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/set" {
+					MustFromContext(r.Context()).Set("key", "value")
+				}
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+
+			req := httptest.NewRequest(http.MethodGet, "/read", nil)
+			for _, c := range rec.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if len(rec.Result().Cookies()) != 1 {
+				t.Errorf("want touched cookie to be re-issued, got %d cookies", len(rec.Result().Cookies()))
+			}
+			if ckv.sets != tc.wantSets {
+				t.Errorf("want %d sets, got %d", tc.wantSets, ckv.sets)
+			}
+			if ckv.touches != tc.wantTouches {
+				t.Errorf("want %d touches, got %d", tc.wantTouches, ckv.touches)
+			}
+		})
+	}
+}
+
+// failingKV is a KV where all operations fail, simulating an outage.
+type failingKV struct{}
+
+func (failingKV) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, errors.New("kv unavailable")
+}
+
+func (failingKV) Set(context.Context, string, time.Time, []byte) error {
+	return errors.New("kv unavailable")
+}
+
+func (failingKV) Delete(context.Context, string) error {
+	return errors.New("kv unavailable")
+}
+
+func TestKVManager_SaveFailureMode(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		mode       SaveFailureMode
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "fail request",
+			mode:       SaveFailureModeFailRequest,
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Error\n",
+		},
+		{
+			name:       "log and continue",
+			mode:       SaveFailureModeLogAndContinue,
+			wantStatus: http.StatusOK,
+			wantBody:   "hello",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewKVManager(failingKV{}, &ManagerOpts{
+				IdleTimeout:     time.Hour,
+				SaveFailureMode: tc.mode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				MustFromContext(r.Context()).Set("key", "value")
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("want status %d, got %d", tc.wantStatus, rec.Code)
+			}
+			if rec.Body.String() != tc.wantBody {
+				t.Errorf("want body %q, got %q", tc.wantBody, rec.Body.String())
+			}
+			if len(rec.Result().Cookies()) != 0 {
+				t.Errorf("want no cookies set, got %v", rec.Result().Cookies())
+			}
+		})
+	}
+}
+
+// deleteFailingKV is a KV where deletes fail.
+type deleteFailingKV struct {
+	KV
+}
+
+func (deleteFailingKV) Delete(context.Context, string) error {
+	return errors.New("delete unavailable")
+}
+
+func TestKVManager_DeleteFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mode SaveFailureMode
+		path string
+	}{
+		{name: "delete, fail request", mode: SaveFailureModeFailRequest, path: "/delete"},
+		{name: "delete, log and continue", mode: SaveFailureModeLogAndContinue, path: "/delete"},
+		{name: "reset, fail request", mode: SaveFailureModeFailRequest, path: "/reset"},
+		{name: "reset, log and continue", mode: SaveFailureModeLogAndContinue, path: "/reset"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kv := NewMemoryKV()
+			mgr, err := NewKVManager(deleteFailingKV{KV: kv}, &ManagerOpts{
+				IdleTimeout:     time.Hour,
+				SaveFailureMode: tc.mode,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sess := MustFromContext(r.Context())
+				switch r.URL.Path {
+				case "/delete":
+					sess.Delete()
+				case "/reset":
+					sess.Reset()
+					sess.Set("path", r.URL.Path)
+				default:
+					sess.Set("path", r.URL.Path)
+				}
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+			oldCookie := rec.Result().Cookies()[0]
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.AddCookie(oldCookie)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			// the old session is still in the store, so the request must not
+			// look like it succeeded.
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("want status %d, got %d", http.StatusInternalServerError, rec.Code)
+			}
+			if _, found, err := kv.Get(t.Context(), mgr.kvKey(oldCookie.Value)); err != nil || !found {
+				t.Fatalf("want old session left in the store, found %t err %v", found, err)
+			}
+		})
+	}
+}
+
+func TestKVManager_SessionIDBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		bytes   int
+		wantLen int
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			wantLen: 26,
+		},
+		{
+			name:    "256 bit",
+			bytes:   32,
+			wantLen: 52,
+		},
+		{
+			name:    "too short",
+			bytes:   8,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewKVManager(NewMemoryKV(), &ManagerOpts{
+				IdleTimeout:    time.Hour,
+				SessionIDBytes: tc.bytes,
+			})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want err %t, got: %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				MustFromContext(r.Context()).Set("key", "value")
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("want 1 cookie, got %d", len(cookies))
+			}
+			if len(cookies[0].Value) != tc.wantLen {
+				t.Errorf("want session ID length %d, got %d (%s)", tc.wantLen, len(cookies[0].Value), cookies[0].Value)
+			}
+		})
+	}
+}
+
+func TestKVManager_SetSameSite(t *testing.T) {
+	mgr, err := NewKVManager(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			sess.Set("user", "test")
+		case "/sensitive":
+			sess.SetSameSite(http.SameSiteStrictMode)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("want 1 lax cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/sensitive", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	strict := rec.Result().Cookies()
+	if len(strict) != 1 {
+		t.Fatalf("want cookie re-issued, got %d cookies", len(strict))
+	}
+	if strict[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("want strict cookie, got %v", strict[0].SameSite)
+	}
+	if strict[0].Value != cookies[0].Value {
+		t.Error("want session ID to be unchanged")
+	}
+}
+
+func TestKVManager_Fingerprint(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		action       FingerprintMismatchAction
+		userAgent    string
+		wantValue    any
+		wantMismatch bool
+		wantDeleted  bool
+	}{
+		{
+			name:      "matching",
+			userAgent: "browser-a",
+			wantValue: "value",
+		},
+		{
+			name:        "mismatch invalidates",
+			userAgent:   "browser-b",
+			wantValue:   nil,
+			wantDeleted: true,
+		},
+		{
+			name:         "mismatch flags",
+			action:       FingerprintMismatchFlag,
+			userAgent:    "browser-b",
+			wantValue:    "value",
+			wantMismatch: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ckv := &countingKV{KV: NewMemoryKV()}
+			mgr, err := NewKVManager(ckv, &ManagerOpts{
+				IdleTimeout:               time.Hour,
+				Fingerprint:               func(r *http.Request) string { return r.UserAgent() },
+				FingerprintMismatchAction: tc.action,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				gotValue    any
+				gotMismatch bool
+			)
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sess := MustFromContext(r.Context())
+				if r.URL.Path == "/set" {
+					sess.Set("key", "value")
+					return
+				}
+				gotValue = sess.Get("key")
+				gotMismatch = sess.FingerprintMismatch()
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/set", nil)
+			req.Header.Set("User-Agent", "browser-a")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			req = httptest.NewRequest(http.MethodGet, "/get", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			for _, c := range rec.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if gotValue != tc.wantValue {
+				t.Errorf("want value %v, got %v", tc.wantValue, gotValue)
+			}
+			if gotMismatch != tc.wantMismatch {
+				t.Errorf("want mismatch %t, got %t", tc.wantMismatch, gotMismatch)
+			}
+			if gotDeleted := ckv.deletes > 0; gotDeleted != tc.wantDeleted {
+				t.Errorf("want deleted %t, got %t", tc.wantDeleted, gotDeleted)
+			}
+		})
+	}
+}
+
+func TestKVManager_TouchThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		threshold   time.Duration
+		touchable   bool
+		wantSets    int
+		wantTouches int
+	}{
+		{
+			name:      "within threshold",
+			threshold: time.Minute,
+			touchable: true,
+			wantSets:  1,
+		},
+		{
+			name:      "exceeds threshold",
+			threshold: time.Nanosecond,
+			touchable: true,
+			wantSets:  3,
+		},
+		{
+			name:      "exceeds threshold, not touchable",
+			threshold: time.Nanosecond,
+			wantSets:  3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ckv := &countingKV{KV: NewMemoryKV()}
+			var kv KV = ckv
+			if tc.touchable {
+				kv = &touchableCountingKV{countingKV: ckv}
+			}
+			mgr, err := NewKVManager(kv, &ManagerOpts{
+				IdleTimeout:    time.Hour,
+				TouchThreshold: tc.threshold,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/set" {
+					MustFromContext(r.Context()).Set("key", "value")
+				}
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+			cookie := rec.Result().Cookies()[0]
+
+			for range 2 {
+				time.Sleep(time.Millisecond)
+				req := httptest.NewRequest(http.MethodGet, "/read", nil)
+				req.AddCookie(cookie)
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				for _, c := range rec.Result().Cookies() {
+					if c.Value != cookie.Value {
+						t.Errorf("want session ID to be retained, got %s", c.Value)
+					}
+				}
+			}
+
+			if ckv.sets != tc.wantSets {
+				t.Errorf("want %d sets, got %d", tc.wantSets, ckv.sets)
+			}
+			if ckv.touches != tc.wantTouches {
+				t.Errorf("want %d touches, got %d", tc.wantTouches, ckv.touches)
+			}
+		})
+	}
+}
+
+func TestKVManager_TouchThresholdStoresExpiry(t *testing.T) {
+	mkv := NewMemoryKV()
+	ckv := &countingKV{KV: mkv}
+	mgr, err := NewKVManager(&touchableCountingKV{countingKV: ckv}, &ManagerOpts{
+		IdleTimeout:    time.Hour,
+		TouchThreshold: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set" {
+			MustFromContext(r.Context()).Set("key", "value")
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookie := rec.Result().Cookies()[0]
+
+	// age the stored session, so the next read moves the expiry by more
+	// than the threshold.
+	key := mgr.kvKey(cookie.Value)
+	data, _, err := mkv.Get(t.Context(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessdata, err := mgr.codec.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessdata.UpdatedAt = sessdata.UpdatedAt.Add(-2 * time.Minute)
+	sessdata.ExpiresAt = sessdata.ExpiresAt.Add(-2 * time.Minute)
+	data, err = mgr.codec.Encode(sessdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mkv.Set(t.Context(), key, sessdata.ExpiresAt, data); err != nil {
+		t.Fatal(err)
+	}
+	ckv.sets = 0
+
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/read", nil)
+		req.AddCookie(cookie)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// only the first read extends the expiry, the rest compare against the
+	// expiry it stored.
+	if got := ckv.sets + ckv.touches; got != 1 {
+		t.Errorf("want 1 write to the KV, got %d sets and %d touches", ckv.sets, ckv.touches)
+	}
+}
+
+// ctxCheckingKV is a KV that fails operations with a cancelled context, like
+// a network backed store would.
+type ctxCheckingKV struct {
+	*countingKV
+}
+
+func (c *ctxCheckingKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.countingKV.Set(ctx, key, expiresAt, value)
+}
+
+func TestKVManager_Cancellation(t *testing.T) {
+	ckv := &countingKV{KV: NewMemoryKV()}
+	mgr, err := NewKVManager(&ctxCheckingKV{countingKV: ckv}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set" {
+			MustFromContext(r.Context()).Set("key", "value")
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookie := rec.Result().Cookies()[0]
+
+	cancelled := func(path string) *http.Request {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		req.AddCookie(cookie)
+		return req
+	}
+
+	// a read-only request skips the touch
+	setsBefore := ckv.sets
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, cancelled("/read"))
+	if ckv.sets != setsBefore {
+		t.Errorf("want touch skipped for cancelled request, got %d sets", ckv.sets-setsBefore)
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("want nothing written, got status %d body %q", rec.Code, rec.Body.String())
+	}
+
+	// a modified session is still persisted
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, cancelled("/set"))
+	if ckv.sets != setsBefore+1 {
+		t.Errorf("want modified session saved for cancelled request, got %d sets", ckv.sets-setsBefore)
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("want no error written, got status %d body %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestKVManager_CancellationErrorNotWritten(t *testing.T) {
+	mgr, err := NewKVManager(failingKV{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		MustFromContext(r.Context()).Set("key", "value")
+	}))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("want no error written to cancelled request, got %q", rec.Body.String())
+	}
+}
+
+func TestKVManager_ReadOnly(t *testing.T) {
+	ckv := &countingKV{KV: NewMemoryKV()}
+	mgr, err := NewKVManager(&touchableCountingKV{countingKV: ckv}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		switch r.URL.Path {
+		case "/set":
+			sess.Set("key", "value")
+		case "/read":
+			sess.ReadOnly()
+		case "/read-set":
+			sess.ReadOnly()
+			sess.Set("key", "changed")
+		case "/read-delete":
+			sess.ReadOnly()
+			sess.Delete()
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookie := rec.Result().Cookies()[0]
+
+	for _, tc := range []struct {
+		path        string
+		wantCookies int
+		wantDeletes int
+	}{
+		{path: "/read", wantCookies: 0},
+		{path: "/read-set", wantCookies: 0},
+		{path: "/read-delete", wantCookies: 1, wantDeletes: 1},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			setsBefore, deletesBefore := ckv.sets, ckv.deletes
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := len(rec.Result().Cookies()); got != tc.wantCookies {
+				t.Errorf("want %d cookies, got %d", tc.wantCookies, got)
+			}
+			if ckv.sets != setsBefore || ckv.touches != 0 {
+				t.Errorf("want no sets or touches, got %d sets %d touches", ckv.sets-setsBefore, ckv.touches)
+			}
+			if got := ckv.deletes - deletesBefore; got != tc.wantDeletes {
+				t.Errorf("want %d deletes, got %d", tc.wantDeletes, got)
+			}
+		})
+	}
+}
+
+func TestKVManager_Partitioned(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    SessionCookieOpts
+		wantErr bool
+	}{
+		{
+			name: "partitioned",
+			opts: SessionCookieOpts{Name: "__Host-session-id", Path: "/", SameSite: http.SameSiteNoneMode, Partitioned: true},
+		},
+		{
+			name:    "not same site none",
+			opts:    SessionCookieOpts{Name: "__Host-session-id", Path: "/", Partitioned: true},
+			wantErr: true,
+		},
+		{
+			name:    "insecure",
+			opts:    SessionCookieOpts{Name: "session-id", Path: "/", SameSite: http.SameSiteNoneMode, Insecure: true, Partitioned: true},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewKVManager(NewMemoryKV(), &ManagerOpts{
+				IdleTimeout: time.Hour,
+				CookieOpts:  &tc.opts,
+			})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want err %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				MustFromContext(r.Context()).Set("key", "value")
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("want 1 cookie, got %d", len(cookies))
+			}
+			if !cookies[0].Partitioned || cookies[0].SameSite != http.SameSiteNoneMode || !cookies[0].Secure {
+				t.Errorf("want partitioned, secure, SameSite=None cookie, got %s", rec.Header().Get("Set-Cookie"))
+			}
+		})
+	}
+}
+
+func TestKVManager_LoadNotFound(t *testing.T) {
+	kv := NewMemoryKV()
+	mgr, err := NewKVManager(kv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+
+	if _, err := mgr.loadFromKV(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("want ErrSessionNotFound for a missing session, got %v", err)
+	}
+
+	if err := kv.Set(ctx, managerHashSessionID("empty"), time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mgr.loadFromKV(ctx, "empty")
+	if err != nil {
+		t.Fatalf("want no error for an existing empty session, got %v", err)
+	}
+	if data == nil {
+		t.Error("want existing empty session to have non-nil data")
+	}
+}
+
+func TestKVManager_LoadEmptyValue(t *testing.T) {
+	kv := NewMemoryKV()
+	mgr, err := NewKVManager(kv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+
+	if err := kv.Set(ctx, managerHashSessionID("empty"), time.Now().Add(time.Hour), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		if len(sess.GetAll()) != 0 {
+			t.Errorf("want empty session, got %v", sess.GetAll())
+		}
+		sess.Set("key", "value")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: mgr.cookieSettings.Name, Value: "empty"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	// the existing session is updated in place, rather than replaced.
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == mgr.cookieSettings.Name && c.Value != "empty" {
+			t.Errorf("want session ID kept, got %s", c.Value)
+		}
+	}
+	data, found, err := kv.Get(ctx, managerHashSessionID("empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || len(data) == 0 {
+		t.Error("want data saved to the existing session")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("want empty session loaded without warnings, got: %s", logs.String())
+	}
+}
+
+func TestKVManager_Metadata(t *testing.T) {
+	mgr, err := NewKVManager(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		md       SessionMetadata
+		idHash   string
+		idHashOK bool
+	)
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		if r.URL.Path == "/set" {
+			sess.Set("key", "value")
+		}
+		md = sess.Metadata()
+		idHash, idHashOK = CurrentSessionIDHash(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	if md.IDHashPrefix != "" {
+		t.Errorf("want no ID hash for a new session, got %q", md.IDHashPrefix)
+	}
+	if idHashOK {
+		t.Errorf("want no current ID hash for a new session, got %q", idHash)
+	}
+	cookie := rec.Result().Cookies()[0]
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if md.Storage != "kv" || md.Codec != "gob" {
+		t.Errorf("want kv storage with gob codec, got %q %q", md.Storage, md.Codec)
+	}
+	if md.CreatedAt.IsZero() || md.UpdatedAt.IsZero() || md.ExpiresAt.IsZero() {
+		t.Errorf("want timestamps set, got %+v", md)
+	}
+	if len(md.IDHashPrefix) != sessionMetadataIDHashPrefixLen {
+		t.Errorf("want ID hash prefix of %d chars, got %q", sessionMetadataIDHashPrefixLen, md.IDHashPrefix)
+	}
+	if strings.Contains(cookie.Value, md.IDHashPrefix) {
+		t.Errorf("ID hash prefix %q should not be part of the session ID", md.IDHashPrefix)
+	}
+	if !idHashOK || !strings.HasPrefix(idHash, md.IDHashPrefix) {
+		t.Errorf("want current ID hash with prefix %q, got %q (ok: %t)", md.IDHashPrefix, idHash, idHashOK)
+	}
+	if _, found, _ := mgr.kv.Get(context.Background(), idHash); !found {
+		t.Errorf("want session stored under current ID hash %q", idHash)
+	}
+
+	b, err := json.Marshal(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), cookie.Value) {
+		t.Errorf("metadata JSON %s contains the session ID", b)
+	}
+}
+
+func TestKVManager_SetPersistent(t *testing.T) {
+	mgr, err := NewKVManager(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		switch r.URL.Path {
+		case "/login":
+			sess.Set("user", "test")
+		case "/remember":
+			sess.Set("user", "test")
+			sess.SetPersistent(true)
+		case "/forget":
+			sess.SetPersistent(false)
+		case "/update":
+			sess.Set("updated", true)
+		}
+	}))
+
+	do := func(path string, cookie *http.Cookie) *http.Cookie {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("%s: want 1 cookie, got %d", path, len(cookies))
+		}
+		return cookies[0]
+	}
+
+	if c := do("/login", nil); c.MaxAge != 0 {
+		t.Errorf("want session cookie by default, got Max-Age %d", c.MaxAge)
+	}
+
+	remembered := do("/remember", nil)
+	if remembered.MaxAge <= 0 {
+		t.Errorf("want persistent cookie, got Max-Age %d", remembered.MaxAge)
+	}
+	// the choice is stored, so later saves keep the cookie persistent.
+	updated := do("/update", remembered)
+	if updated.MaxAge <= 0 {
+		t.Errorf("want persistent cookie after update, got Max-Age %d", updated.MaxAge)
+	}
+
+	if c := do("/forget", updated); c.MaxAge != 0 {
+		t.Errorf("want session cookie after forget, got Max-Age %d", c.MaxAge)
+	}
+}
+
+func TestKVManager_Clear(t *testing.T) {
+	mgr, err := NewKVManager(NewMemoryKV(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		got       map[string]any
+		createdAt time.Time
+	)
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := MustFromContext(r.Context())
+		switch r.URL.Path {
+		case "/set":
+			sess.Set("user", "test")
+			sess.Set("cart", "items")
+		case "/clear":
+			sess.Clear()
+		}
+		got = sess.GetAll()
+		createdAt = sess.Metadata().CreatedAt
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookie := rec.Result().Cookies()[0]
+	wantCreatedAt := createdAt
+
+	req := httptest.NewRequest(http.MethodGet, "/clear", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Value != cookie.Value || cleared[0].MaxAge < 0 {
+		t.Fatalf("want session saved with the same ID, got %v", cleared)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if len(got) != 0 {
+		t.Errorf("want empty session data, got %v", got)
+	}
+	if !createdAt.Equal(wantCreatedAt) {
+		t.Errorf("want created at %s kept, got %s", wantCreatedAt, createdAt)
+	}
+}
+
+func TestKVManager_ExposeExpiryHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "enabled", enabled: true},
+		{name: "disabled"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewKVManager(NewMemoryKV(), &ManagerOpts{
+				IdleTimeout:        time.Hour,
+				ExposeExpiryHeader: tc.enabled,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/login" {
+					MustFromContext(r.Context()).Set("user", "test")
+				}
+			}))
+
+			do := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if cookie != nil {
+					req.AddCookie(cookie)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+
+			if rec := do("/", nil); rec.Header().Get(ExpiryHeader) != "" {
+				t.Errorf("want no header without a session, got %q", rec.Header().Get(ExpiryHeader))
+			}
+
+			login := do("/login", nil)
+			cookies := login.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("want 1 cookie, got %d", len(cookies))
+			}
+
+			// the save and the following touch both report the expiry.
+			for _, rec := range []*httptest.ResponseRecorder{login, do("/", cookies[0])} {
+				got := rec.Header().Get(ExpiryHeader)
+				if !tc.enabled {
+					if got != "" {
+						t.Errorf("want no header when disabled, got %q", got)
+					}
+					continue
+				}
+				exp, err := strconv.ParseInt(got, 10, 64)
+				if err != nil {
+					t.Fatalf("parsing header %q: %v", got, err)
+				}
+				if d := time.Until(time.Unix(exp, 0)); d < 59*time.Minute || d > time.Hour {
+					t.Errorf("want expiry in about an hour, got %s", d)
+				}
+			}
+		})
+	}
+}
+
+func TestKVManager_SkipForRequest(t *testing.T) {
+	mgr, err := NewKVManager(NewMemoryKV(), &ManagerOpts{
+		IdleTimeout: time.Hour,
+		SkipForRequest: func(r *http.Request) bool {
+			return strings.Contains(r.UserAgent(), "bot")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hasSession bool
+	h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sess *Session
+		sess, hasSession = FromContext(r.Context())
+		if hasSession {
+			sess.Set("visited", true)
+		}
+	}))
+
+	for _, tc := range []struct {
+		name        string
+		userAgent   string
+		wantSession bool
+	}{
+		{name: "browser", userAgent: "Mozilla/5.0", wantSession: true},
+		{name: "crawler", userAgent: "examplebot/1.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if hasSession != tc.wantSession {
+				t.Errorf("want session in context %t, got %t", tc.wantSession, hasSession)
+			}
+			if got := len(rec.Result().Cookies()); (got != 0) != tc.wantSession {
+				t.Errorf("want cookie issued %t, got %d cookies", tc.wantSession, got)
+			}
+		})
+	}
+}
+
+func TestKVManager_DeleteAllSessions(t *testing.T) {
+	kv := NewMemoryKV()
+	newMgr := func(prefix string) *Manager {
+		mgr, err := NewKVManager(kv, &ManagerOpts{IdleTimeout: time.Hour, KVKeyPrefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mgr
+	}
+	tenantA, tenantB := newMgr("a:"), newMgr("b:")
+
+	newSession := func(mgr *Manager) *http.Cookie {
+		h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MustFromContext(r.Context()).Set("key", "value")
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Result().Cookies()[0]
+	}
+	loaded := func(mgr *Manager, cookie *http.Cookie) bool {
+		var got bool
+		h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = MustFromContext(r.Context()).Get("key") == "value"
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	a1, a2, b1 := newSession(tenantA), newSession(tenantA), newSession(tenantB)
+
+	deleted, err := tenantA.DeleteAllSessions(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("want 2 sessions deleted, got %d", deleted)
+	}
+	if loaded(tenantA, a1) || loaded(tenantA, a2) {
+		t.Error("want tenant a sessions deleted")
+	}
+	if !loaded(tenantB, b1) {
+		t.Error("want tenant b session kept")
+	}
+
+	if _, err := newMgr("").DeleteAllSessions(t.Context()); err == nil {
+		t.Error("want error deleting all sessions without a prefix")
+	}
+	noPrefixDelete, err := NewKVManager(&countingKV{KV: kv}, &ManagerOpts{IdleTimeout: time.Hour, KVKeyPrefix: "a:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noPrefixDelete.DeleteAllSessions(t.Context()); err == nil {
+		t.Error("want error deleting all sessions from a KV without DeleteByPrefix")
+	}
+}
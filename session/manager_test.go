@@ -1,6 +1,11 @@
 package session
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -83,3 +88,359 @@ func TestItem_InvalidAt(t *testing.T) {
 func ptr[T any](v T) *T {
 	return &v
 }
+
+func TestManager_DuplicateCookies(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		newManager func() (*Manager, error)
+		staleValue string
+	}{
+		{
+			name: "cookie",
+			newManager: func() (*Manager, error) {
+				return NewCookieManager(must(NewXChaPolyAEAD(genXChaPolyKey(), nil)), nil)
+			},
+			staleValue: "EU1.bm90LXZhbGlk",
+		},
+		{
+			name: "kv",
+			newManager: func() (*Manager, error) {
+				return NewKVManager(NewMemoryKV(), nil)
+			},
+			staleValue: "STALESESSIONID",
+		},
+		{
+			name: "kv undecodable",
+			newManager: func() (*Manager, error) {
+				kv := NewMemoryKV()
+				if err := kv.Set(context.Background(), managerHashSessionID("CORRUPTSESSIONID"), time.Now().Add(time.Hour), []byte("not a session")); err != nil {
+					return nil, err
+				}
+				return NewKVManager(kv, nil)
+			},
+			staleValue: "CORRUPTSESSIONID",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := tc.newManager()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sess := MustFromContext(r.Context())
+				if r.URL.Path == "/set" {
+					sess.Set("key", "value")
+				}
+				_, _ = fmt.Fprint(w, sess.Get("key"))
+			}))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/set", nil))
+			valid := rec.Result().Cookies()[0]
+
+			for _, order := range [][]string{
+				{tc.staleValue, valid.Value},
+				{valid.Value, tc.staleValue},
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/read", nil)
+				for _, v := range order {
+					req.AddCookie(&http.Cookie{Name: valid.Name, Value: v})
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+
+				if rec.Body.String() != "value" {
+					t.Errorf("want session loaded from valid cookie, got %q", rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestManager_CookiePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		opts       *SessionCookieOpts
+		wantName   string
+		wantSecure bool
+		// wantSameSite is checked if set.
+		wantSameSite http.SameSite
+		wantErr      bool
+	}{
+		{
+			name:       "default",
+			wantName:   "__Host-session-id",
+			wantSecure: true,
+		},
+		{
+			name:       "domain uses secure prefix",
+			opts:       &SessionCookieOpts{Domain: "example.com"},
+			wantName:   "__Secure-session-id",
+			wantSecure: true,
+		},
+		{
+			name:     "insecure has no prefix",
+			opts:     &SessionCookieOpts{Insecure: true},
+			wantName: "session-id",
+		},
+		{
+			name:     "http base url is insecure",
+			opts:     &SessionCookieOpts{BaseURL: &url.URL{Scheme: "http", Host: "localhost:8080"}},
+			wantName: "session-id",
+		},
+		{
+			name:       "https base url is secure",
+			opts:       &SessionCookieOpts{BaseURL: &url.URL{Scheme: "https", Host: "example.com"}},
+			wantName:   "__Host-session-id",
+			wantSecure: true,
+		},
+		{
+			name:    "http base url with host prefix",
+			opts:    &SessionCookieOpts{Name: "__Host-sess", Path: "/", BaseURL: &url.URL{Scheme: "http", Host: "localhost:8080"}},
+			wantErr: true,
+		},
+		{
+			name:    "customize makes host prefix insecure",
+			opts:    &SessionCookieOpts{Customize: func(c *http.Cookie) { c.Secure = false }},
+			wantErr: true,
+		},
+		{
+			name:    "customize adds domain to host prefix",
+			opts:    &SessionCookieOpts{Customize: func(c *http.Cookie) { c.Domain = "example.com" }},
+			wantErr: true,
+		},
+		{
+			name:    "customize renames",
+			opts:    &SessionCookieOpts{Customize: func(c *http.Cookie) { c.Name = "other" }},
+			wantErr: true,
+		},
+		{
+			name:         "customize",
+			opts:         &SessionCookieOpts{Customize: func(c *http.Cookie) { c.SameSite = http.SameSiteStrictMode }},
+			wantName:     "__Host-session-id",
+			wantSecure:   true,
+			wantSameSite: http.SameSiteStrictMode,
+		},
+		{
+			name:    "host prefix with domain",
+			opts:    &SessionCookieOpts{Name: "__Host-sess", Path: "/", Domain: "example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "host prefix with path",
+			opts:    &SessionCookieOpts{Name: "__Host-sess", Path: "/app"},
+			wantErr: true,
+		},
+		{
+			name:    "host prefix insecure",
+			opts:    &SessionCookieOpts{Name: "__Host-sess", Path: "/", Insecure: true},
+			wantErr: true,
+		},
+		{
+			name:    "secure prefix insecure",
+			opts:    &SessionCookieOpts{Name: "__Secure-sess", Domain: "example.com", Insecure: true},
+			wantErr: true,
+		},
+		{
+			name:       "secure prefix with domain",
+			opts:       &SessionCookieOpts{Name: "__Secure-sess", Path: "/", Domain: "example.com"},
+			wantName:   "__Secure-sess",
+			wantSecure: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := NewKVManager(NewMemoryKV(), &ManagerOpts{
+				IdleTimeout: time.Hour,
+				CookieOpts:  tc.opts,
+			})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want err %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				MustFromContext(r.Context()).Set("key", "value")
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("want 1 cookie, got %d", len(cookies))
+			}
+			if cookies[0].Name != tc.wantName {
+				t.Errorf("want cookie name %s, got %s", tc.wantName, cookies[0].Name)
+			}
+			if tc.wantSameSite != 0 && cookies[0].SameSite != tc.wantSameSite {
+				t.Errorf("want SameSite %v, got %v", tc.wantSameSite, cookies[0].SameSite)
+			}
+			if cookies[0].Secure != tc.wantSecure {
+				t.Errorf("want secure %t, got %t", tc.wantSecure, cookies[0].Secure)
+			}
+			if tc.opts != nil && cookies[0].Domain != tc.opts.Domain {
+				t.Errorf("want domain %q, got %q", tc.opts.Domain, cookies[0].Domain)
+			}
+		})
+	}
+}
+
+func TestManager_SaveDecision(t *testing.T) {
+	loaded := []byte("data")
+
+	for _, tc := range []struct {
+		name        string
+		sess        *Session
+		idleTimeout time.Duration
+		threshold   time.Duration
+		cancelled   bool
+		wantDelete  bool
+		wantAction  saveAction
+	}{
+		{
+			name:       "new session unmodified",
+			sess:       &Session{},
+			wantAction: saveActionNone,
+		},
+		{
+			name:       "modified",
+			sess:       &Session{save: true},
+			wantAction: saveActionSave,
+		},
+		{
+			name:       "deleted",
+			sess:       &Session{delete: true},
+			wantDelete: true,
+			wantAction: saveActionNone,
+		},
+		{
+			name:       "reset",
+			sess:       &Session{reset: true},
+			wantDelete: true,
+			wantAction: saveActionSave,
+		},
+		{
+			name:        "read with idle timeout",
+			sess:        &Session{datab: loaded},
+			idleTimeout: time.Hour,
+			wantAction:  saveActionTouch,
+		},
+		{
+			name:       "read without idle timeout",
+			sess:       &Session{datab: loaded},
+			wantAction: saveActionNone,
+		},
+		{
+			name:        "read within touch threshold",
+			sess:        &Session{datab: loaded, sessdata: persistedSession{CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}},
+			idleTimeout: time.Hour,
+			threshold:   time.Minute,
+			wantAction:  saveActionNone,
+		},
+		{
+			name:        "read beyond touch threshold",
+			sess:        &Session{datab: loaded, sessdata: persistedSession{CreatedAt: time.Now(), ExpiresAt: time.Now()}},
+			idleTimeout: time.Hour,
+			threshold:   time.Minute,
+			wantAction:  saveActionSave,
+		},
+		{
+			name:        "read only",
+			sess:        &Session{datab: loaded, readOnly: true},
+			idleTimeout: time.Hour,
+			wantAction:  saveActionNone,
+		},
+		{
+			name:        "extend beyond touch threshold",
+			sess:        &Session{datab: loaded, extend: true, sessdata: persistedSession{CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}},
+			idleTimeout: time.Hour,
+			threshold:   time.Minute,
+			wantAction:  saveActionTouch,
+		},
+		{
+			name:       "extend without idle timeout",
+			sess:       &Session{datab: loaded, extend: true},
+			wantAction: saveActionTouch,
+		},
+		{
+			name:       "extend new session",
+			sess:       &Session{extend: true},
+			wantAction: saveActionNone,
+		},
+		{
+			name:        "extend read only",
+			sess:        &Session{datab: loaded, extend: true, readOnly: true},
+			idleTimeout: time.Hour,
+			wantAction:  saveActionNone,
+		},
+		{
+			name:       "read only modified",
+			sess:       &Session{save: true, readOnly: true},
+			wantAction: saveActionNone,
+		},
+		{
+			name:       "read only deleted",
+			sess:       &Session{delete: true, readOnly: true},
+			wantDelete: true,
+			wantAction: saveActionNone,
+		},
+		{
+			name:        "cancelled read",
+			sess:        &Session{datab: loaded},
+			idleTimeout: time.Hour,
+			cancelled:   true,
+			wantAction:  saveActionNone,
+		},
+		{
+			name:       "cancelled modified",
+			sess:       &Session{save: true},
+			cancelled:  true,
+			wantAction: saveActionSave,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Manager{opts: ManagerOpts{
+				IdleTimeout:    tc.idleTimeout,
+				MaxLifetime:    24 * time.Hour,
+				TouchThreshold: tc.threshold,
+			}}
+			gotDelete, gotAction := m.saveDecision(tc.sess, tc.cancelled)
+			if gotDelete != tc.wantDelete || gotAction != tc.wantAction {
+				t.Errorf("want delete %v action %v, got delete %v action %v", tc.wantDelete, tc.wantAction, gotDelete, gotAction)
+			}
+		})
+	}
+}
+
+func TestHookRW_Written(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		write       func(w http.ResponseWriter)
+		wantWritten bool
+	}{
+		{name: "nothing", write: func(w http.ResponseWriter) {}},
+		{name: "header", write: func(w http.ResponseWriter) { w.WriteHeader(http.StatusNoContent) }, wantWritten: true},
+		{name: "body", write: func(w http.ResponseWriter) { _, _ = w.Write([]byte("body")) }, wantWritten: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			hw := &hookRW{
+				ResponseWriter: httptest.NewRecorder(),
+				hook: func(http.ResponseWriter) bool {
+					calls++
+					return true
+				},
+			}
+			tc.write(hw)
+			if hw.Written() != tc.wantWritten {
+				t.Errorf("want written %v, got %v", tc.wantWritten, hw.Written())
+			}
+			hw.fire()
+			if calls != 1 {
+				t.Errorf("want hook called once, got %d", calls)
+			}
+		})
+	}
+}
@@ -3,33 +3,82 @@ package session
 import (
 	"bytes"
 	"compress/zlib"
+	"errors"
 	"fmt"
 	"io"
-	"sync"
 )
 
-var compressorPool = sync.Pool{
-	New: func() any {
-		return &pooledCompressor{}
-	},
+// compressionPool holds compressors and decompressors for reuse. Unlike a
+// sync.Pool it holds at most a fixed number of each, so a burst of requests
+// with large sessions can't leave a large number of zlib writers and their
+// buffers in memory. When the pool is empty a new one is created, and when it
+// is full returned ones are dropped.
+type compressionPool struct {
+	level         int
+	compressors   chan *pooledCompressor
+	decompressors chan *pooledDecompressor
 }
 
-func getCompressor() *pooledCompressor {
-	return compressorPool.Get().(*pooledCompressor)
+// newCompressionPool creates a pool of up to size compressors for the given
+// zlib level, and size decompressors. The level must be valid.
+func newCompressionPool(level, size int) *compressionPool {
+	return &compressionPool{
+		level:         level,
+		compressors:   make(chan *pooledCompressor, size),
+		decompressors: make(chan *pooledDecompressor, size),
+	}
+}
+
+// validCompressionLevel checks if the level is usable with newCompressionPool.
+func validCompressionLevel(level int) bool {
+	return level >= zlib.HuffmanOnly && level <= zlib.BestCompression
 }
 
-func putCompressor(pc *pooledCompressor) {
-	compressorPool.Put(pc)
+func (c *compressionPool) getCompressor() *pooledCompressor {
+	select {
+	case pc := <-c.compressors:
+		return pc
+	default:
+		return &pooledCompressor{level: c.level}
+	}
+}
+
+func (c *compressionPool) putCompressor(pc *pooledCompressor) {
+	select {
+	case c.compressors <- pc:
+	default:
+	}
+}
+
+func (c *compressionPool) getDecompressor() *pooledDecompressor {
+	select {
+	case pd := <-c.decompressors:
+		return pd
+	default:
+		return &pooledDecompressor{}
+	}
+}
+
+func (c *compressionPool) putDecompressor(pd *pooledDecompressor) {
+	select {
+	case c.decompressors <- pd:
+	default:
+	}
 }
 
 type pooledCompressor struct {
 	Buf    bytes.Buffer
 	Writer *zlib.Writer
+	level  int
 }
 
 func (p *pooledCompressor) Compress(data []byte) ([]byte, error) {
 	if p.Writer == nil {
-		p.Writer = zlib.NewWriter(&p.Buf)
+		w, err := zlib.NewWriterLevel(&p.Buf, p.level)
+		if err != nil {
+			return nil, fmt.Errorf("creating compressor: %w", err)
+		}
+		p.Writer = w
 	} else {
 		p.Buf.Reset()
 		p.Writer.Reset(&p.Buf)
@@ -43,25 +92,17 @@ func (p *pooledCompressor) Compress(data []byte) ([]byte, error) {
 	return p.Buf.Bytes(), nil
 }
 
-var decompressorPool = sync.Pool{
-	New: func() any {
-		return &pooledDecompressor{}
-	},
-}
-
-func getDecompressor() *pooledDecompressor {
-	return decompressorPool.Get().(*pooledDecompressor)
-}
-
-func putDecompressor(pc *pooledDecompressor) {
-	decompressorPool.Put(pc)
-}
-
 type pooledDecompressor struct {
 	Reader io.ReadCloser
 }
 
-func (p *pooledDecompressor) Decompress(data []byte) ([]byte, error) {
+// errDecompressedTooLarge is returned when decompressed data exceeds the limit.
+var errDecompressedTooLarge = errors.New("decompressed data exceeds size limit")
+
+// Decompress decompresses data, returning an error if the output is larger
+// than limit bytes. This guards against small inputs that expand to a huge
+// size.
+func (p *pooledDecompressor) Decompress(data []byte, limit int64) ([]byte, error) {
 	if p.Reader == nil {
 		r, err := zlib.NewReader(bytes.NewReader(data))
 		if err != nil {
@@ -73,10 +114,13 @@ func (p *pooledDecompressor) Decompress(data []byte) ([]byte, error) {
 			return nil, fmt.Errorf("resetting reader: %w", err)
 		}
 	}
-	b, err := io.ReadAll(p.Reader)
+	b, err := io.ReadAll(io.LimitReader(p.Reader, limit+1))
 	if err != nil {
 		return nil, fmt.Errorf("decompressing: %w", err)
 	}
 	_ = p.Reader.Close()
+	if int64(len(b)) > limit {
+		return nil, errDecompressedTooLarge
+	}
 	return b, nil
 }
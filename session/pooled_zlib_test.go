@@ -10,9 +10,12 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestCompression(t *testing.T) {
+	pool := newCompressionPool(zlib.DefaultCompression, 2)
+
 	var (
 		wg   sync.WaitGroup
 		errC = make(chan (error), 1000)
@@ -25,8 +28,8 @@ func TestCompression(t *testing.T) {
 			data := randStr(4096)
 
 			for range 20 {
-				cw := getCompressor()
-				cr := getDecompressor()
+				cw := pool.getCompressor()
+				cr := pool.getDecompressor()
 
 				b, err := cw.Compress([]byte(data))
 				if err != nil {
@@ -34,7 +37,7 @@ func TestCompression(t *testing.T) {
 					return
 				}
 
-				dec, err := cr.Decompress(b)
+				dec, err := cr.Decompress(b, 1<<20)
 				if err != nil {
 					errC <- fmt.Errorf("decompressing data: %v", err)
 					return
@@ -45,8 +48,8 @@ func TestCompression(t *testing.T) {
 					return
 				}
 
-				putCompressor(cw)
-				putDecompressor(cr)
+				pool.putCompressor(cw)
+				pool.putDecompressor(cr)
 			}
 		}()
 	}
@@ -95,24 +98,25 @@ func BenchmarkCompression(b *testing.B) {
 	})
 
 	b.Run("pooled", func(b *testing.B) {
+		pool := newCompressionPool(zlib.DefaultCompression, DefaultCompressionPoolSize)
 		for range b.N {
 			b.StopTimer()
 			data := randStr(4096)
 			b.StartTimer()
 
-			cw := getCompressor()
+			cw := pool.getCompressor()
 			cb, err := cw.Compress([]byte(data))
 			if err != nil {
 				b.Fatal(err)
 			}
-			putCompressor(cw)
+			pool.putCompressor(cw)
 
-			cr := getDecompressor()
-			rb, err := cr.Decompress(cb)
+			cr := pool.getDecompressor()
+			rb, err := cr.Decompress(cb, 1<<20)
 			if err != nil {
 				b.Fatal(err)
 			}
-			putDecompressor(cr)
+			pool.putDecompressor(cr)
 
 			b.StopTimer()
 			if !strings.EqualFold(data, string(rb)) {
@@ -122,6 +126,32 @@ func BenchmarkCompression(b *testing.B) {
 			b.SetBytes(int64(len(data)))
 		}
 	})
+
+	// parallel use, with more goroutines than pooled compressors.
+	b.Run("pooled-parallel", func(b *testing.B) {
+		pool := newCompressionPool(zlib.DefaultCompression, DefaultCompressionPoolSize)
+		data := []byte(randStr(4096))
+		b.SetBytes(int64(len(data)))
+		b.SetParallelism(4)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cw := pool.getCompressor()
+				cb, err := cw.Compress(data)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				cr := pool.getDecompressor()
+				_, err = cr.Decompress(cb, 1<<20)
+				pool.putCompressor(cw)
+				pool.putDecompressor(cr)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
 }
 
 var randChars = []rune(`abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01234567890`)
@@ -135,9 +165,11 @@ func randStr(n int) string {
 }
 
 func TestCompressionRoundTrip(t *testing.T) {
+	pool := newCompressionPool(zlib.DefaultCompression, 1)
+
 	// Get the pooled compressor
-	cw := getCompressor()
-	defer putCompressor(cw)
+	cw := pool.getCompressor()
+	defer pool.putCompressor(cw)
 
 	// Create test data - larger than threshold to ensure compression activates
 	data := bytes.Repeat([]byte("a"), managerCompressThreshold+100)
@@ -151,11 +183,11 @@ func TestCompressionRoundTrip(t *testing.T) {
 	t.Logf("Original size: %d, Compressed size: %d", len(data), len(compressed))
 
 	// Get the pooled decompressor
-	cr := getDecompressor()
-	defer putDecompressor(cr)
+	cr := pool.getDecompressor()
+	defer pool.putDecompressor(cr)
 
 	// Decompress the data
-	decompressed, err := cr.Decompress(compressed)
+	decompressed, err := cr.Decompress(compressed, 1<<20)
 	if err != nil {
 		t.Fatalf("Error decompressing data: %v", err)
 	}
@@ -165,3 +197,80 @@ func TestCompressionRoundTrip(t *testing.T) {
 		t.Error("Data mismatch after compression round-trip")
 	}
 }
+
+func TestCompressionPoolBounded(t *testing.T) {
+	pool := newCompressionPool(zlib.DefaultCompression, 2)
+
+	var cws []*pooledCompressor
+	var crs []*pooledDecompressor
+	for range 4 {
+		cws = append(cws, pool.getCompressor())
+		crs = append(crs, pool.getDecompressor())
+	}
+	for i := range cws {
+		pool.putCompressor(cws[i])
+		pool.putDecompressor(crs[i])
+	}
+
+	if got := len(pool.compressors); got != 2 {
+		t.Errorf("want 2 pooled compressors, got %d", got)
+	}
+	if got := len(pool.decompressors); got != 2 {
+		t.Errorf("want 2 pooled decompressors, got %d", got)
+	}
+	if cw := pool.getCompressor(); cw != cws[0] {
+		t.Error("want pooled compressor re-used")
+	}
+}
+
+func TestCompressionLevels(t *testing.T) {
+	// representative session data, encoded the same way the cookie manager
+	// does.
+	data := map[string]any{
+		"user_id":    "4b1d2a0e-6c1f-4a8e-9d55-1c2b3a4d5e6f",
+		"email":      "someone@example.com",
+		"roles":      "admin,editor,viewer",
+		"csrf_token": randStr(32),
+		"return_to":  "/some/deep/path/in/the/app?with=query&params=true",
+		"prefs":      strings.Repeat(`{"theme":"dark","lang":"en"},`, 20),
+	}
+	enc, err := (&gobCodec{}).Encode(persistedSession{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[int]int{}
+	for _, level := range []int{zlib.BestSpeed, zlib.DefaultCompression, zlib.BestCompression} {
+		pool := newCompressionPool(level, 1)
+		cw := pool.getCompressor()
+		b, err := cw.Compress(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[level] = len(b)
+		pool.putCompressor(cw)
+
+		cr := pool.getDecompressor()
+		rb, err := cr.Decompress(b, 1<<20)
+		pool.putDecompressor(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(enc, rb) {
+			t.Errorf("level %d: data mismatch after round trip", level)
+		}
+	}
+	t.Logf("uncompressed: %d, best speed: %d, default: %d, best compression: %d",
+		len(enc), sizes[zlib.BestSpeed], sizes[zlib.DefaultCompression], sizes[zlib.BestCompression])
+
+	if sizes[zlib.BestCompression] > sizes[zlib.BestSpeed] {
+		t.Errorf("best compression (%d) larger than best speed (%d)", sizes[zlib.BestCompression], sizes[zlib.BestSpeed])
+	}
+
+	if _, err := NewCookieManager(nil, &ManagerOpts{IdleTimeout: time.Hour, CompressionLevel: 10}); err == nil {
+		t.Error("want error for invalid compression level")
+	}
+	if _, err := NewCookieManager(nil, &ManagerOpts{IdleTimeout: time.Hour, CompressionPoolSize: -1}); err == nil {
+		t.Error("want error for negative compression pool size")
+	}
+}
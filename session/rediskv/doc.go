@@ -0,0 +1,20 @@
+// Package rediskv provides a Redis-backed session store using go-redis.
+//
+// Keys are stored with a native Redis TTL, so expired sessions are removed by
+// Redis and no garbage collection is needed. Keys are namespaced with a
+// prefix, defaulting to "websess:", so the store can share a database with
+// other data.
+//
+// Usage:
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//
+//	kv := rediskv.New(client, &rediskv.Opts{
+//		KeyPrefix: "myapp:sess:", // optional, defaults to "websess:"
+//	})
+//
+//	// Configure the session manager to use this KV store
+//	manager, err := session.NewKVManager(kv, &session.ManagerOpts{...})
+//
+// This is a separate module, so the root module does not depend on go-redis.
+package rediskv
@@ -0,0 +1,90 @@
+package rediskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"lds.li/web/session"
+)
+
+var _ session.TouchableKV = (*RedisKV)(nil)
+
+const (
+	// DefaultKeyPrefix is the default prefix for keys in Redis
+	DefaultKeyPrefix = "websess:"
+)
+
+// Opts contains options for configuring the KV store
+type Opts struct {
+	// KeyPrefix is prepended to every key stored in Redis (defaults to
+	// "websess:").
+	KeyPrefix string
+}
+
+// RedisKV implements the session.KV interface using Redis
+type RedisKV struct {
+	client    redis.Cmdable
+	keyPrefix string
+}
+
+// New creates a new KV store backed by Redis. The client can be any go-redis
+// client, e.g. a *redis.Client or *redis.ClusterClient.
+func New(client redis.Cmdable, opts *Opts) *RedisKV {
+	kv := &RedisKV{
+		client:    client,
+		keyPrefix: DefaultKeyPrefix,
+	}
+	if opts != nil && opts.KeyPrefix != "" {
+		kv.keyPrefix = opts.KeyPrefix
+	}
+	return kv
+}
+
+// Get retrieves a value by key
+func (k *RedisKV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
+	data, err := k.client.Get(ctx, k.keyPrefix+key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("getting %s: %w", key, err)
+	}
+	return data, true, nil
+}
+
+// Set stores a key with a given value, expiring at the given time. If the
+// time has already passed the key is deleted instead.
+func (k *RedisKV) Set(ctx context.Context, key string, expiresAt time.Time, value []byte) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return k.Delete(ctx, key)
+	}
+	if err := k.client.Set(ctx, k.keyPrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Touch updates the expiration time of an existing key, without re-writing
+// its data. If the time has already passed the key is deleted instead.
+func (k *RedisKV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return k.Delete(ctx, key)
+	}
+	if err := k.client.PExpire(ctx, k.keyPrefix+key, ttl).Err(); err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes a key from the store
+func (k *RedisKV) Delete(ctx context.Context, key string) error {
+	if err := k.client.Del(ctx, k.keyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
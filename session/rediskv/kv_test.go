@@ -0,0 +1,59 @@
+package rediskv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"lds.li/web/session/kvtest"
+)
+
+func TestKV_Redis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	kv := New(client, nil)
+
+	kvtest.RunComplianceTest(t, kv, mr.FlushAll)
+}
+
+func TestKV_KeyPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name    string
+		opts    *Opts
+		wantKey string
+	}{
+		{
+			name:    "default",
+			wantKey: "websess:key",
+		},
+		{
+			name:    "custom",
+			opts:    &Opts{KeyPrefix: "app:"},
+			wantKey: "app:key",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+
+			kv := New(client, tc.opts)
+			if err := kv.Set(ctx, "key", time.Now().Add(time.Hour), []byte("value")); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			if got := mr.Keys(); len(got) != 1 || got[0] != tc.wantKey {
+				t.Errorf("keys = %v, want [%s]", got, tc.wantKey)
+			}
+			if ttl := mr.TTL(tc.wantKey); ttl <= 0 || ttl > time.Hour {
+				t.Errorf("TTL = %v, want up to an hour", ttl)
+			}
+		})
+	}
+}
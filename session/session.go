@@ -1,8 +1,20 @@
 package session
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
 	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 type sessionContextKey struct{}
@@ -11,12 +23,52 @@ type sessionContextKey struct{}
 type Session struct {
 	sessdata   persistedSession
 	sessdataMu sync.RWMutex
-	// datab is the original loaded data bytes. Used for idle timeout, when a
-	// save may happen without data modification
+	// datab is the original loaded data bytes. Used to touch the session, when
+	// a save may happen without data modification
 	datab  []byte
 	delete bool
 	save   bool
 	reset  bool
+	// sameSite overrides the cookie SameSite mode for this request, if set.
+	sameSite http.SameSite
+	// fingerprintMismatch is set if the loaded session's fingerprint did not
+	// match the request.
+	fingerprintMismatch bool
+	// readOnly suppresses saving and touching the session for this request.
+	readOnly bool
+	// extend forces a loaded session to be touched.
+	extend bool
+	// meta holds the metadata that is fixed for the request, the timestamps
+	// are added from sessdata by Metadata.
+	meta SessionMetadata
+	// idHash is the hash of the loaded KV session's ID.
+	idHash string
+}
+
+// sessionMetadataIDHashPrefixLen is the number of hex characters of the
+// hashed session ID exposed in metadata.
+const sessionMetadataIDHashPrefixLen = 12
+
+// SessionMetadata describes a session without exposing its data or ID, for
+// audit logging.
+type SessionMetadata struct {
+	// CreatedAt is when the session was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// UpdatedAt is when the session was last saved or touched, if it has
+	// been.
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	// ExpiresAt is the expiry the session was last saved with, if it has
+	// been.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+	// IDHashPrefix is a prefix of the SHA-256 hash of the session ID the
+	// request loaded, in hex. It can correlate log entries for a session, but
+	// not be used to recover the ID. It is empty for cookie sessions, and new
+	// sessions.
+	IDHashPrefix string `json:"idHashPrefix,omitempty"`
+	// Codec is the name of the codec the session is serialized with.
+	Codec string `json:"codec"`
+	// Storage is where the session is stored, "cookie" or "kv".
+	Storage string `json:"storage"`
 }
 
 // Get returns the value for the given key from the session.
@@ -36,6 +88,29 @@ func (s *Session) GetAll() map[string]any {
 	return maps.Clone(s.sessdata.Data)
 }
 
+// Keys returns a sorted copy of the keys in the session.
+func (s *Session) Keys() []string {
+	s.sessdataMu.RLock()
+	defer s.sessdataMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(s.sessdata.Data))
+}
+
+// Range calls fn for each key and value in the session, in key order,
+// stopping if fn returns false. It iterates over a snapshot of the session, so
+// fn may modify the session. This does not mark the session to be saved.
+func (s *Session) Range(fn func(key string, value any) bool) {
+	s.sessdataMu.RLock()
+	data := maps.Clone(s.sessdata.Data)
+	s.sessdataMu.RUnlock()
+
+	for _, k := range slices.Sorted(maps.Keys(data)) {
+		if !fn(k, data[k]) {
+			return
+		}
+	}
+}
+
 // Set sets a single key-value pair in the session and marks it to be saved.
 func (s *Session) Set(key string, value any) {
 	s.sessdataMu.Lock()
@@ -57,7 +132,23 @@ func (s *Session) SetAll(data map[string]any) {
 	s.sessdata.Data = data
 }
 
-// Delete marks the session for deletion at the end of the request.
+// Clear removes all data from the session and marks it to be saved. Unlike
+// Delete, the session itself is kept, with the same ID and creation time, and
+// it is saved empty rather than removed. Unlike Reset, the ID is not rotated.
+// Metadata like the flash message, cookie persistence and the secret used for
+// Token are also kept.
+func (s *Session) Clear() {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	s.delete = false
+	s.save = true
+	s.sessdata.Data = make(map[string]any)
+}
+
+// Delete marks the session for deletion at the end of the request. The
+// stored session and its cookie are removed, and if data is set again a new
+// session is started. To empty the session but keep it, use Clear.
 func (s *Session) Delete() {
 	s.sessdataMu.Lock()
 	defer s.sessdataMu.Unlock()
@@ -71,7 +162,7 @@ func (s *Session) Delete() {
 	s.reset = false
 }
 
-// Reset rotates the session ID to avoid session fixation.
+// Reset rotates the session ID to avoid session fixation. The data is kept.
 func (s *Session) Reset() {
 	s.sessdataMu.Lock()
 	defer s.sessdataMu.Unlock()
@@ -82,6 +173,134 @@ func (s *Session) Reset() {
 	s.reset = true
 }
 
+// SetSameSite overrides the SameSite attribute of the session cookie for
+// this request, e.g. upgrading it to http.SameSiteStrictMode on a password
+// change page. The session is marked to be saved, so the cookie is re-issued
+// with the new mode.
+//
+// Browsers track cookies by name, domain and path, not by the route that set
+// them. The new mode applies to all requests the cookie is sent on, until it
+// is next set without the override, which returns it to the default Lax mode.
+// This means a Strict upgrade only protects requests made while it is in
+// effect, and cross-site navigations to any page will not include the session
+// until it is re-issued. For a Strict cookie that only covers sensitive
+// routes, use a separate cookie with a more specific Path.
+func (s *Session) SetSameSite(mode http.SameSite) {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	s.sameSite = mode
+	if !s.delete {
+		s.save = true
+	}
+}
+
+// SetPersistent overrides the manager's SessionCookieOpts.Persist for this
+// session, e.g. to only persist the cookie across browser restarts when the
+// user chose "remember me" at login. A persistent cookie has a Max-Age
+// matching the session's expiry, otherwise it is removed when the browser is
+// closed. The choice is stored in the session, so it applies to all later
+// responses until changed, or the session is deleted.
+func (s *Session) SetPersistent(persist bool) {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	if persist {
+		s.sessdata.Persist = persistEnabled
+	} else {
+		s.sessdata.Persist = persistDisabled
+	}
+	s.delete = false
+	s.save = true
+}
+
+// ReadOnly marks the session as read-only for this request. The session will
+// not be saved or have its idle timeout extended, so no Set-Cookie is sent.
+// This is intended for pages that only read the session, e.g. to show who is
+// logged in, and want to avoid re-issuing the cookie on every request.
+//
+// Changes made to the session data, including reading a flash message, are
+// discarded. Delete and Reset are still applied, so a read-only page can not
+// prevent a logout or ID rotation.
+func (s *Session) ReadOnly() {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	s.readOnly = true
+}
+
+// Token returns a token bound to this session and purpose, e.g. for a logout
+// link that should only work for the user it was rendered for. The token is
+// stable for the lifetime of the session, and differs for each purpose. It is
+// derived from a random secret stored in the session, which is created and
+// the session marked to be saved the first time a token is requested.
+func (s *Session) Token(purpose string) string {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	if len(s.sessdata.TokenSecret) == 0 {
+		s.sessdata.TokenSecret = make([]byte, 32)
+		if _, err := rand.Read(s.sessdata.TokenSecret); err != nil {
+			panic(fmt.Sprintf("reading random bytes: %v", err))
+		}
+		s.delete = false
+		s.save = true
+	}
+	return s.token(purpose)
+}
+
+// VerifyToken checks that the token was created by Token for this session and
+// purpose, in constant time.
+func (s *Session) VerifyToken(purpose, token string) bool {
+	s.sessdataMu.RLock()
+	defer s.sessdataMu.RUnlock()
+
+	if len(s.sessdata.TokenSecret) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(s.token(purpose)), []byte(token)) == 1
+}
+
+func (s *Session) token(purpose string) string {
+	mac := hmac.New(sha256.New, s.sessdata.TokenSecret)
+	mac.Write([]byte(purpose))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Extend extends the lifetime of the session at the end of this request,
+// without changing its data, e.g. for a "keep me signed in" ping. The session
+// is touched even if the idle timeout is disabled, or the TouchThreshold would
+// otherwise skip it. The new expiry is still limited by the MaxLifetime, so
+// with no idle timeout this only re-issues the cookie. It has no effect on a
+// new session that has not been saved, or if the session is ReadOnly.
+func (s *Session) Extend() {
+	s.sessdataMu.Lock()
+	defer s.sessdataMu.Unlock()
+
+	s.extend = true
+}
+
+// Metadata returns information about the session suitable for audit logging.
+// It reflects the session as loaded for this request, and any changes made to
+// it so far.
+func (s *Session) Metadata() SessionMetadata {
+	s.sessdataMu.RLock()
+	defer s.sessdataMu.RUnlock()
+
+	md := s.meta
+	md.CreatedAt = s.sessdata.CreatedAt
+	md.UpdatedAt = s.sessdata.UpdatedAt
+	md.ExpiresAt = s.sessdata.ExpiresAt
+	return md
+}
+
+// FingerprintMismatch indicates that the session was loaded, but its
+// fingerprint did not match the request. This is only set when the manager is
+// configured with a Fingerprint and FingerprintMismatchFlag.
+func (s *Session) FingerprintMismatch() bool {
+	return s.fingerprintMismatch
+}
+
 // HasFlash indicates if there is a flash message.
 func (s *Session) HasFlash() bool {
 	return s.sessdata.Flash != flashLevelNone
@@ -92,7 +311,10 @@ func (s *Session) FlashIsError() bool {
 	return s.sessdata.Flash == flashLevelError
 }
 
-// FlashMessage returns the current flash message and clears it.
+// FlashMessage returns the current flash message and clears it. The message
+// is plain text. The FlashMessage template func returns it as a string, so
+// html/template always escapes it, and it is safe for messages that include
+// user input. There is no way to render a flash as HTML.
 func (s *Session) FlashMessage() string {
 	flash := s.sessdata.FlashMsg
 	if flash == "" {
@@ -106,14 +328,89 @@ func (s *Session) FlashMessage() string {
 	return flash
 }
 
+// SetFlashError sets a plain text error message, to be shown on the next page
+// rendered. See FlashMessage.
 func (s *Session) SetFlashError(message string) {
 	s.sessdata.FlashMsg = message
 	s.sessdata.Flash = flashLevelError
 	s.save = true
 }
 
+// SetFlashMessage sets a plain text message, to be shown on the next page
+// rendered. See FlashMessage.
 func (s *Session) SetFlashMessage(message string) {
 	s.sessdata.FlashMsg = message
 	s.sessdata.Flash = flashLevelInfo
 	s.save = true
 }
+
+// Put stores a typed value in the session. The type is registered with the
+// session codec, so it can be decoded on later requests.
+//
+// Sessions are encoded with encoding/gob. Put calls gob.Register for the
+// type, if the type is already registered under another name with
+// gob.RegisterName that registration is used. The registration must have
+// happened before a session containing the type is loaded on a later
+// request, so types should be registered in an init function if they can be
+// read on a request that did not call Put.
+func Put[T any](s *Session, key string, val T) {
+	registerGobType(val)
+	s.Set(key, val)
+}
+
+// GetT returns the typed value for the given key from the session. If the key
+// doesn't exist, or holds a value of a different type, it returns the zero
+// value and false.
+//
+// gob does not distinguish between a type and a pointer to it, so a value
+// stored as one may be decoded as the other. GetT converts between the two, so
+// the value can be retrieved with the same type it was Put with.
+func GetT[T any](s *Session, key string) (T, bool) {
+	var zero T
+	v := s.Get(key)
+	if tv, ok := v.(T); ok {
+		return tv, true
+	}
+
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return zero, false
+	}
+	want := reflect.TypeFor[T]()
+	switch {
+	case want.Kind() == reflect.Pointer && rv.Type() == want.Elem():
+		p := reflect.New(want.Elem())
+		p.Elem().Set(rv)
+		return p.Interface().(T), true
+	case rv.Kind() == reflect.Pointer && rv.Type().Elem() == want && !rv.IsNil():
+		return rv.Elem().Interface().(T), true
+	}
+	return zero, false
+}
+
+var registeredGobTypes sync.Map
+
+// registerGobType registers the type of val with gob, once.
+func registerGobType(val any) {
+	if val == nil {
+		return
+	}
+	t := reflect.TypeOf(val)
+	if _, loaded := registeredGobTypes.LoadOrStore(t, struct{}{}); loaded {
+		return
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		// gob panics if the type was already registered under a different
+		// name. That registration is still valid to use, so ignore this.
+		if s, ok := r.(string); ok && strings.HasPrefix(s, "gob: registering duplicate names for ") {
+			return
+		}
+		registeredGobTypes.Delete(t)
+		panic(r)
+	}()
+	gob.Register(val)
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"encoding/gob"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeysRange(t *testing.T) {
+	sess := &Session{sessdata: persistedSession{Data: map[string]any{
+		"b": 2,
+		"a": 1,
+		"c": 3,
+	}}}
+
+	if diff := cmp.Diff([]string{"a", "b", "c"}, sess.Keys()); diff != "" {
+		t.Error(diff)
+	}
+
+	var got []string
+	sess.Range(func(key string, value any) bool {
+		got = append(got, key)
+		// modifying during iteration must be safe
+		sess.Set("d", 4)
+		return key != "b"
+	})
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Error(diff)
+	}
+
+	unmodified := &Session{sessdata: persistedSession{Data: map[string]any{"a": 1}}}
+	unmodified.Range(func(string, any) bool { return true })
+	_ = unmodified.Keys()
+	if unmodified.save {
+		t.Error("want Keys and Range to not mark the session as modified")
+	}
+}
+
+func TestToken(t *testing.T) {
+	sess := &Session{sessdata: persistedSession{Data: map[string]any{}}}
+	other := &Session{sessdata: persistedSession{Data: map[string]any{}}}
+
+	if sess.VerifyToken("logout", "anything") {
+		t.Error("want verify to fail before a token is created")
+	}
+
+	tok := sess.Token("logout")
+	if !sess.save {
+		t.Error("want session marked for save when the secret is created")
+	}
+	if got := sess.Token("logout"); got != tok {
+		t.Errorf("want stable token, got %q then %q", tok, got)
+	}
+	if sess.Token("delete-account") == tok {
+		t.Error("want tokens to differ by purpose")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		sess    *Session
+		purpose string
+		token   string
+		want    bool
+	}{
+		{name: "valid", sess: sess, purpose: "logout", token: tok, want: true},
+		{name: "wrong purpose", sess: sess, purpose: "delete-account", token: tok, want: false},
+		{name: "other session", sess: other, purpose: "logout", token: tok, want: false},
+		{name: "empty", sess: sess, purpose: "logout", token: "", want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.sess.VerifyToken(tc.purpose, tc.token); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+type gobRenamed struct{ A int }
+
+type gobClash struct{ A int }
+
+type gobClashOther struct{ B string }
+
+func TestRegisterGobType(t *testing.T) {
+	// a type already registered under another name can still be used.
+	gob.RegisterName("session-test-renamed", gobRenamed{})
+	registerGobType(gobRenamed{})
+
+	// a different type holding the name gob would use is a real conflict.
+	gob.RegisterName("lds.li/web/session.gobClash", gobClashOther{})
+	defer func() {
+		if recover() == nil {
+			t.Error("want panic registering a type whose name is taken by another type")
+		}
+	}()
+	registerGobType(gobClash{})
+}
@@ -0,0 +1,87 @@
+// Package sessiontest provides helpers for testing code that uses sessions.
+package sessiontest
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lds.li/web/session"
+)
+
+// SeedSession creates a session containing data, stored by the manager the
+// same way as a session saved by a request, e.g. to set up an authenticated
+// user in an integration test without driving the login flow. The returned
+// cookie should be added to requests to use the session. The session expires
+// according to the manager's configuration.
+func SeedSession(t testing.TB, m *session.Manager, data map[string]any) *http.Cookie {
+	t.Helper()
+
+	if data == nil {
+		data = make(map[string]any)
+	}
+
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session.MustFromContext(r.Context()).SetAll(data)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil))
+	if rec.Code >= http.StatusBadRequest {
+		t.Fatalf("seeding session: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// a new session sets a single cookie, any others would be removing
+	// previous sessions.
+	for _, c := range rec.Result().Cookies() {
+		if c.Value != "" && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	t.Fatal("seeding session: no session cookie set")
+	return nil
+}
+
+// NewTestAEAD returns an AEAD that always encrypts with a zero nonce, so the
+// same plaintext always produces the same ciphertext. This allows tests to
+// assert exact cookie values, and build fuzz corpora.
+//
+// INSECURE: re-using a nonce with the same key destroys the confidentiality
+// and integrity of everything encrypted with it. This must only be used in
+// tests, and panics if called outside of a test binary. The key must be 32
+// bytes.
+//
+// The output uses the same format as session.NewXChaPolyAEAD, so it can be
+// decrypted by one with the same key. Note that a cookie is only
+// deterministic if the session data is, e.g. gob encodes maps with more than
+// one key in random order, and the expiry is based on the current time.
+func NewTestAEAD(key []byte) session.AEAD {
+	if !testing.Testing() {
+		panic("sessiontest: NewTestAEAD used outside of a test")
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		panic(fmt.Sprintf("sessiontest: test AEAD key must be %d bytes", chacha20poly1305.KeySize))
+	}
+	return &testAEAD{aead: aead}
+}
+
+type testAEAD struct {
+	aead cipher.AEAD
+}
+
+func (a *testAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	return a.aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func (a *testAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	nonceSize := a.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("invalid ciphertext")
+	}
+	return a.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], associatedData)
+}
@@ -0,0 +1,87 @@
+package sessiontest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lds.li/web/session"
+)
+
+func TestSeedSession(t *testing.T) {
+	aead, err := session.NewXChaPolyAEAD(bytes.Repeat([]byte{1}, 32), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		mgr  func() (*session.Manager, error)
+	}{
+		{name: "kv", mgr: func() (*session.Manager, error) { return session.NewKVManager(session.NewMemoryKV(), nil) }},
+		{name: "cookie", mgr: func() (*session.Manager, error) { return session.NewCookieManager(aead, nil) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr, err := tc.mgr()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cookie := SeedSession(t, mgr, map[string]any{"user": "alice"})
+
+			var got any
+			h := mgr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = session.MustFromContext(r.Context()).Get("user")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookie)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != "alice" {
+				t.Errorf("want seeded user alice, got %v", got)
+			}
+		})
+	}
+}
+
+func TestNewTestAEAD(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	aead := NewTestAEAD(key)
+
+	ct1, err := aead.Encrypt([]byte("plaintext"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct2, err := aead.Encrypt([]byte("plaintext"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ct1, ct2) {
+		t.Error("want deterministic ciphertext")
+	}
+
+	prod, err := session.NewXChaPolyAEAD(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := prod.Decrypt(ct1, []byte("ad"))
+	if err != nil {
+		t.Fatalf("decrypting with XChaPoly AEAD: %v", err)
+	}
+	if string(pt) != "plaintext" {
+		t.Errorf("want plaintext, got %q", pt)
+	}
+
+	prodCT, err := prod.Encrypt([]byte("plaintext"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err = aead.Decrypt(prodCT, []byte("ad"))
+	if err != nil {
+		t.Fatalf("decrypting XChaPoly AEAD output: %v", err)
+	}
+	if string(pt) != "plaintext" {
+		t.Errorf("want plaintext, got %q", pt)
+	}
+}
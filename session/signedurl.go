@@ -0,0 +1,61 @@
+package session
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrURLParamExpired is returned by VerifyURLParam when the token has expired.
+var ErrURLParamExpired = errors.New("url param expired")
+
+// urlParamADPrefix namespaces the associated data, so URL tokens can never be
+// confused with cookies encrypted with the same AEAD.
+const urlParamADPrefix = "lds.li/web/session.urlparam:"
+
+// SignURLParam encrypts data into a URL safe token, for links like email
+// confirmation or password resets. The purpose is bound to the token, so a
+// token issued for one purpose can not be used for another. The token is
+// rejected by VerifyURLParam after the expiry.
+//
+// Tokens can be used any number of times until they expire. If a link must
+// only be used once, include something in the data that is invalidated on
+// use, e.g. a nonce stored against the user, or a hash of their current
+// password.
+func SignURLParam(aead AEAD, purpose string, data []byte, expiry time.Time) (string, error) {
+	b := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint64(b, uint64(expiry.Unix()))
+	b = append(b, data...)
+
+	ct, err := aead.Encrypt(b, []byte(urlParamADPrefix+purpose))
+	if err != nil {
+		return "", fmt.Errorf("encrypting url param: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(ct), nil
+}
+
+// VerifyURLParam decrypts a token created by SignURLParam, returning the data.
+// It fails if the token was created for a different purpose, has been
+// tampered with, or has expired.
+func VerifyURLParam(aead AEAD, purpose string, token string) ([]byte, error) {
+	ct, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("decoding url param: %w", err)
+	}
+
+	b, err := aead.Decrypt(ct, []byte(urlParamADPrefix+purpose))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting url param: %w", err)
+	}
+	if len(b) < 8 {
+		return nil, errors.New("url param too short")
+	}
+
+	expiresAt := time.Unix(int64(binary.LittleEndian.Uint64(b[:8])), 0)
+	if time.Now().After(expiresAt) {
+		return nil, ErrURLParamExpired
+	}
+	return b[8:], nil
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURLParam(t *testing.T) {
+	aead, err := NewXChaPolyAEAD(genXChaPolyKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherAEAD, err := NewXChaPolyAEAD(genXChaPolyKey(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("user-1234")
+	token, err := SignURLParam(aead, "password-reset", data, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url.QueryEscape(token) != token {
+		t.Errorf("token %q is not URL safe", token)
+	}
+
+	expired, err := SignURLParam(aead, "password-reset", data, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		aead    AEAD
+		purpose string
+		token   string
+		wantErr bool
+		// wantErrIs optionally checks the specific error
+		wantErrIs error
+	}{
+		{
+			name:    "valid",
+			aead:    aead,
+			purpose: "password-reset",
+			token:   token,
+		},
+		{
+			name:    "wrong purpose",
+			aead:    aead,
+			purpose: "email-confirm",
+			token:   token,
+			wantErr: true,
+		},
+		{
+			name:    "wrong key",
+			aead:    otherAEAD,
+			purpose: "password-reset",
+			token:   token,
+			wantErr: true,
+		},
+		{
+			name:    "tampered",
+			aead:    aead,
+			purpose: "password-reset",
+			token:   token[:len(token)-2] + "AA",
+			wantErr: true,
+		},
+		{
+			name:      "expired",
+			aead:      aead,
+			purpose:   "password-reset",
+			token:     expired,
+			wantErr:   true,
+			wantErrIs: ErrURLParamExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyURLParam(tt.aead, tt.purpose, tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want err %t, got: %v", tt.wantErr, err)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("want error %v, got %v", tt.wantErrIs, err)
+			}
+			if !tt.wantErr && !bytes.Equal(got, data) {
+				t.Errorf("want data %q, got %q", data, got)
+			}
+		})
+	}
+}
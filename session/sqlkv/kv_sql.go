@@ -5,8 +5,18 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"math/rand/v2"
+	"strings"
 	"time"
+
+	"lds.li/web/session"
+)
+
+var (
+	_ session.TouchableKV       = (*SqlKV)(nil)
+	_ session.PrefixDeletableKV = (*SqlKV)(nil)
 )
 
 const (
@@ -21,9 +31,18 @@ const (
 
 	setQueryTemplate    = `INSERT INTO %s (id, data, expires_at) VALUES (?, ?, ?) %s`
 	deleteQueryTemplate = `DELETE FROM %s WHERE id = ?`
+	touchQueryTemplate  = `UPDATE %s SET expires_at = ? WHERE id = ?`
 	gcQueryTemplate     = `DELETE FROM %s WHERE expires_at < CURRENT_TIMESTAMP`
 	gcQuerySQLite       = `DELETE FROM %s WHERE datetime(expires_at) < datetime('now')`
 
+	// The escape character is chosen to avoid differing backslash handling
+	// between databases. SQLite's LIKE is case insensitive, so it uses GLOB.
+	// MySQL's LIKE follows the column's case insensitive default collation,
+	// so the pattern is compared as binary.
+	deletePrefixQueryTemplate = `DELETE FROM %s WHERE id LIKE ? ESCAPE '!'`
+	deletePrefixQueryMySQL    = `DELETE FROM %s WHERE id LIKE BINARY ? ESCAPE '!'`
+	deletePrefixQuerySQLite   = `DELETE FROM %s WHERE id GLOB ?`
+
 	// Dialects handle upsert differently
 	mysqlUpsert    = `ON DUPLICATE KEY UPDATE data = VALUES(data), expires_at = VALUES(expires_at)`
 	postgresUpsert = `ON CONFLICT(id) DO UPDATE SET data = EXCLUDED.data, expires_at = EXCLUDED.expires_at`
@@ -44,29 +63,83 @@ const (
 	SQLite
 )
 
+// DBConn is the subset of *sql.DB used for key operations. It is satisfied by
+// *sql.Tx, so they can be run in a transaction with WithTx.
+type DBConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var (
+	_ DBConn = (*sql.DB)(nil)
+	_ DBConn = (*sql.Tx)(nil)
+)
+
 // SqlKV implements the session.SqlKV interface using database/sql
 type SqlKV struct {
 	db *sql.DB
+	// conn is used for key operations. It is the db, unless bound to a
+	// transaction with WithTx.
+	conn DBConn
 
 	getQuery    string
 	setQuery    string
 	deleteQuery string
+	touchQuery  string
 	gcQuery     string
 
+	deletePrefixQuery string
+
 	dialect   Dialect
 	tableName string
+	// table is the quoted, possibly schema-qualified, table name for queries.
+	table string
+	// indexName is the quoted name for the expires_at index, and indexOn the
+	// table name used when creating it.
+	indexName string
+	indexOn   string
+
+	gcJitter     float64
+	gcLeaderLock bool
+
+	createdAtColumn bool
 }
 
 // Opts contains options for configuring the KV store
 type Opts struct {
-	// TableName is the name of the table to use for the KV store (defaults to "web_sessions")
+	// TableName is the name of the table to use for the KV store (defaults to
+	// "web_sessions"). It can be qualified with a schema, e.g.
+	// "auth.web_sessions", or for SQLite an attached database. Each part must
+	// be an identifier of letters, digits and underscores, not starting with
+	// a digit. Parts are quoted in queries. For PostgreSQL they are
+	// lower-cased first, matching how it folds unquoted names, so a
+	// mixed-case name refers to the same table it would unquoted.
 	TableName string
 	// Dialect specifies which SQL dialect to use (defaults to Generic)
 	Dialect Dialect
+	// GCJitter randomizes each RunGC interval by up to plus or minus this
+	// fraction of the interval, e.g. 0.1 for ±10%. This avoids every instance
+	// of an app running GC against the database at the same moment. Must be
+	// between 0 and 1.
+	GCJitter float64
+	// GCLeaderLock makes RunGC take a database lock before collecting, so
+	// only one instance runs GC at a time. Instances that fail to get the lock
+	// skip that run. This uses advisory locks, and is only supported for MySQL
+	// and PostgreSQL. It is ignored for other dialects.
+	GCLeaderLock bool
+	// CreatedAtColumn makes CreateTable add a created_at column, set when a
+	// key is first inserted and left alone when it is updated. This is not
+	// used by the store, but is useful for analyzing session churn, e.g.
+	// sessions created per day. The column can be added to an existing table
+	// with a default of the current timestamp, e.g. for PostgreSQL:
+	//
+	//	ALTER TABLE web_sessions ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	CreatedAtColumn bool
 }
 
-// New creates a new KV store backed by database/sql
-func New(db *sql.DB, opts *Opts) *SqlKV {
+// New creates a new KV store backed by database/sql. It returns an error if
+// the table name is invalid.
+func New(db *sql.DB, opts *Opts) (*SqlKV, error) {
 	tableName := DefaultTableName
 	dialect := Generic
 
@@ -79,14 +152,81 @@ func New(db *sql.DB, opts *Opts) *SqlKV {
 
 	kv := &SqlKV{
 		db:        db,
+		conn:      db,
 		dialect:   dialect,
 		tableName: tableName,
 	}
+	schema, table, err := parseTableName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == PostgreSQL {
+		schema, table = strings.ToLower(schema), strings.ToLower(table)
+	}
+	kv.table = quoteIdentifier(dialect, table)
+	kv.indexName = quoteIdentifier(dialect, table+"_expires_at_idx")
+	kv.indexOn = kv.table
+	if schema != "" {
+		kv.table = quoteIdentifier(dialect, schema) + "." + kv.table
+		if dialect == SQLite {
+			// SQLite qualifies the index rather than the table it is on.
+			kv.indexName = quoteIdentifier(dialect, schema) + "." + kv.indexName
+		} else {
+			kv.indexOn = kv.table
+		}
+	}
+
+	if opts != nil {
+		kv.gcJitter = min(max(opts.GCJitter, 0), 1)
+		kv.gcLeaderLock = opts.GCLeaderLock && (dialect == MySQL || dialect == PostgreSQL)
+		kv.createdAtColumn = opts.CreatedAtColumn
+	}
 
 	// Prepare queries based on dialect
 	kv.setupQueries()
 
-	return kv
+	return kv, nil
+}
+
+// parseTableName validates a table name, which may be qualified with a
+// schema, returning its parts.
+func parseTableName(name string) (schema, table string, _ error) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("table name %q has too many parts", name)
+	}
+	for _, p := range parts {
+		if !isIdentifier(p) {
+			return "", "", fmt.Errorf("table name %q: %q is not a valid identifier", name, p)
+		}
+	}
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	}
+	return "", parts[0], nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// quoteIdentifier quotes a validated identifier for the dialect.
+func quoteIdentifier(dialect Dialect, s string) string {
+	if dialect == MySQL {
+		return "`" + s + "`"
+	}
+	return `"` + s + `"`
 }
 
 // setupQueries prepares the SQL queries based on the dialect
@@ -122,20 +262,42 @@ func (k *SqlKV) setupQueries() {
 	}
 
 	// Prepare the queries
-	k.getQuery = fmt.Sprintf(getQueryTmpl, k.tableName)
-	k.setQuery = fmt.Sprintf(setQueryTmpl, k.tableName, upsertClause)
-	k.deleteQuery = fmt.Sprintf(deleteQueryTemplate, k.tableName)
-	k.gcQuery = fmt.Sprintf(gcQueryTmpl, k.tableName)
+	k.getQuery = fmt.Sprintf(getQueryTmpl, k.table)
+	k.setQuery = fmt.Sprintf(setQueryTmpl, k.table, upsertClause)
+	k.deleteQuery = fmt.Sprintf(deleteQueryTemplate, k.table)
+	k.touchQuery = fmt.Sprintf(touchQueryTemplate, k.table)
+	switch k.dialect {
+	case SQLite:
+		k.deletePrefixQuery = fmt.Sprintf(deletePrefixQuerySQLite, k.table)
+	case MySQL:
+		k.deletePrefixQuery = fmt.Sprintf(deletePrefixQueryMySQL, k.table)
+	default:
+		k.deletePrefixQuery = fmt.Sprintf(deletePrefixQueryTemplate, k.table)
+	}
+	k.gcQuery = fmt.Sprintf(gcQueryTmpl, k.table)
 
 	// Convert placeholder style if needed
 	if k.dialect == PostgreSQL {
 		k.getQuery = convertPlaceholders(k.getQuery)
 		k.setQuery = convertPlaceholders(k.setQuery)
 		k.deleteQuery = convertPlaceholders(k.deleteQuery)
+		k.touchQuery = convertPlaceholders(k.touchQuery)
+		k.deletePrefixQuery = convertPlaceholders(k.deletePrefixQuery)
 		k.gcQuery = convertPlaceholders(k.gcQuery)
 	}
 }
 
+// WithTx returns a copy of the store that runs Get, Set, Delete, Touch and
+// DeletePrefix in the given transaction, so they are committed or rolled back
+// with the caller's other changes. e.g. a user and their session can be
+// created atomically. GC, RunGC and CreateTable always use the database the
+// store was created with.
+func (k *SqlKV) WithTx(tx *sql.Tx) *SqlKV {
+	kv := *k
+	kv.conn = tx
+	return &kv
+}
+
 // convertPlaceholders converts ? placeholders to $1, $2, etc. for PostgreSQL
 func convertPlaceholders(query string) string {
 	result := ""
@@ -154,7 +316,7 @@ func convertPlaceholders(query string) string {
 // Get retrieves a value by key, checking expiration
 func (k *SqlKV) Get(ctx context.Context, key string) (_ []byte, found bool, _ error) {
 	var data []byte
-	err := k.db.QueryRowContext(ctx, k.getQuery, key).Scan(&data)
+	err := k.conn.QueryRowContext(ctx, k.getQuery, key).Scan(&data)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -173,10 +335,10 @@ func (k *SqlKV) Set(ctx context.Context, key string, expiresAt time.Time, value
 	// Special handling for SQLite timestamp format
 	if k.dialect == SQLite {
 		// Format as RFC3339/ISO8601 for SQLite compatibility, ensuring UTC timezone
-		_, err = k.db.ExecContext(ctx, k.setQuery, key, value, expiresAt.UTC().Format(time.RFC3339))
+		_, err = k.conn.ExecContext(ctx, k.setQuery, key, value, expiresAt.UTC().Format(time.RFC3339))
 	} else {
 		// For other databases, let driver handle time.Time
-		_, err = k.db.ExecContext(ctx, k.setQuery, key, value, expiresAt)
+		_, err = k.conn.ExecContext(ctx, k.setQuery, key, value, expiresAt)
 	}
 
 	if err != nil {
@@ -185,15 +347,65 @@ func (k *SqlKV) Set(ctx context.Context, key string, expiresAt time.Time, value
 	return nil
 }
 
+// Touch updates the expiration time of an existing key, without re-writing
+// its data
+func (k *SqlKV) Touch(ctx context.Context, key string, expiresAt time.Time) error {
+	var err error
+
+	if k.dialect == SQLite {
+		_, err = k.conn.ExecContext(ctx, k.touchQuery, expiresAt.UTC().Format(time.RFC3339), key)
+	} else {
+		_, err = k.conn.ExecContext(ctx, k.touchQuery, expiresAt, key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("touching %s: %w", key, err)
+	}
+	return nil
+}
+
 // Delete removes a key from the store
 func (k *SqlKV) Delete(ctx context.Context, key string) error {
-	_, err := k.db.ExecContext(ctx, k.deleteQuery, key)
+	_, err := k.conn.ExecContext(ctx, k.deleteQuery, key)
 	if err != nil {
 		return fmt.Errorf("deleting %s: %w", key, err)
 	}
 	return nil
 }
 
+// DeleteByPrefix removes all keys that start with the given prefix. This
+// requires the keys to be written with a scheme that encodes the grouping (e.g.
+// a tenant ID) in the prefix.
+func (k *SqlKV) DeleteByPrefix(ctx context.Context, prefix string) (deleted int, _ error) {
+	if prefix == "" {
+		return 0, errors.New("prefix must not be empty")
+	}
+
+	var pattern string
+	if k.dialect == SQLite {
+		pattern = globPrefixReplacer.Replace(prefix) + "*"
+	} else {
+		pattern = likePrefixReplacer.Replace(prefix) + "%"
+	}
+
+	result, err := k.conn.ExecContext(ctx, k.deletePrefixQuery, pattern)
+	if err != nil {
+		return 0, fmt.Errorf("deleting prefix %s: %w", prefix, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("getting affected rows count: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+var (
+	likePrefixReplacer = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	globPrefixReplacer = strings.NewReplacer("[", "[[]", "*", "[*]", "?", "[?]")
+)
+
 // GC performs garbage collection, removing expired keys
 func (k *SqlKV) GC(ctx context.Context) (deleted int, _ error) {
 	result, err := k.db.ExecContext(ctx, k.gcQuery)
@@ -209,11 +421,17 @@ func (k *SqlKV) GC(ctx context.Context) (deleted int, _ error) {
 	return int(rowsAffected), nil
 }
 
-// RunGC starts a background goroutine that performs garbage collection at regular intervals
-func (k *SqlKV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+// RunGC starts a background goroutine that performs garbage collection at
+// regular intervals, until the context is cancelled. A GC query in progress is
+// cancelled with the context. The returned func blocks until the goroutine has
+// exited, so graceful shutdown can confirm GC has stopped.
+func (k *SqlKV) RunGC(ctx context.Context, interval time.Duration, logger *slog.Logger) (wait func()) {
+	done := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		defer close(done)
+
+		timer := time.NewTimer(jitterInterval(interval, k.gcJitter))
+		defer timer.Stop()
 
 		for {
 			select {
@@ -222,18 +440,98 @@ func (k *SqlKV) RunGC(ctx context.Context, interval time.Duration, logger *slog.
 					logger.InfoContext(ctx, "Garbage collection stopped", "reason", ctx.Err())
 				}
 				return
-			case <-ticker.C:
-				deleted, err := k.GC(ctx)
-				if err != nil {
-					if logger != nil {
-						logger.ErrorContext(ctx, "Garbage collection failed", "error", err)
-					}
-				} else if logger != nil {
-					logger.InfoContext(ctx, "Garbage collection successful", "deleted_rows", deleted)
-				}
+			case <-timer.C:
+				k.runGCOnce(ctx, logger)
+				timer.Reset(jitterInterval(interval, k.gcJitter))
 			}
 		}
 	}()
+	return func() { <-done }
+}
+
+func (k *SqlKV) runGCOnce(ctx context.Context, logger *slog.Logger) {
+	if k.gcLeaderLock {
+		release, acquired, err := k.acquireGCLock(ctx)
+		if err != nil {
+			if logger != nil {
+				logger.ErrorContext(ctx, "Garbage collection failed to acquire lock", "error", err)
+			}
+			return
+		}
+		if !acquired {
+			if logger != nil {
+				logger.DebugContext(ctx, "Garbage collection skipped, another instance holds the lock")
+			}
+			return
+		}
+		defer release()
+	}
+
+	deleted, err := k.GC(ctx)
+	if err != nil {
+		if logger != nil {
+			logger.ErrorContext(ctx, "Garbage collection failed", "error", err)
+		}
+	} else if logger != nil {
+		logger.InfoContext(ctx, "Garbage collection successful", "deleted_rows", deleted)
+	}
+}
+
+// acquireGCLock tries to take a database advisory lock for GC on this table.
+// Advisory locks are held by a connection, so a dedicated connection is used
+// and held until the lock is released.
+func (k *SqlKV) acquireGCLock(ctx context.Context) (release func(), acquired bool, _ error) {
+	conn, err := k.db.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("getting connection: %w", err)
+	}
+
+	var lockQuery, unlockQuery string
+	var lockKey any
+	switch k.dialect {
+	case PostgreSQL:
+		lockQuery = `SELECT pg_try_advisory_lock($1)`
+		unlockQuery = `SELECT pg_advisory_unlock($1)`
+		h := fnv.New64a()
+		_, _ = h.Write([]byte("web_sessions_gc:" + k.tableName))
+		lockKey = int64(h.Sum64())
+	case MySQL:
+		lockQuery = `SELECT GET_LOCK(?, 0) = 1`
+		unlockQuery = `SELECT RELEASE_LOCK(?)`
+		lockKey = "web_sessions_gc:" + k.tableName
+	default:
+		_ = conn.Close()
+		return nil, false, fmt.Errorf("GC lock not supported for dialect %d", k.dialect)
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, lockQuery, lockKey).Scan(&ok); err != nil {
+		_ = conn.Close()
+		return nil, false, fmt.Errorf("acquiring GC lock: %w", err)
+	}
+	if !ok {
+		_ = conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		// use a fresh context, so the lock is released even if the GC context
+		// was cancelled.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		_, _ = conn.ExecContext(ctx, unlockQuery, lockKey)
+		_ = conn.Close()
+	}, true, nil
+}
+
+// jitterInterval randomizes the interval by up to plus or minus the jitter
+// fraction.
+func jitterInterval(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	delta := float64(interval) * jitter * (2*rand.Float64() - 1)
+	return interval + time.Duration(delta)
 }
 
 // CreateTable creates the sessions table if it doesn't exist
@@ -243,42 +541,56 @@ func (k *SqlKV) CreateTable(ctx context.Context) error {
 		indexQuery string
 	)
 
+	// created_at is only set by the column default on insert, the upsert
+	// leaves it alone.
+	var createdAt string
+	if k.createdAtColumn {
+		switch k.dialect {
+		case PostgreSQL:
+			createdAt = ",\n\t\t\tcreated_at TIMESTAMPTZ NOT NULL DEFAULT now()"
+		case SQLite:
+			createdAt = ",\n\t\t\tcreated_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP"
+		default:
+			createdAt = ",\n\t\t\tcreated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP"
+		}
+	}
+
 	switch k.dialect {
 	case MySQL:
 		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 			id VARCHAR(255) PRIMARY KEY,
 			data BLOB NOT NULL,
-			expires_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL%s,
 			INDEX (expires_at)
-		)`, k.tableName)
+		)`, k.table, createdAt)
 	case PostgreSQL:
 		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 			id TEXT PRIMARY KEY,
 			data BYTEA NOT NULL,
-			expires_at TIMESTAMPTZ NOT NULL
-		)`, k.tableName)
+			expires_at TIMESTAMPTZ NOT NULL%s
+		)`, k.table, createdAt)
 		// Create index in a separate statement
-		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at_idx ON %s (expires_at)`,
-			k.tableName, k.tableName)
+		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)`,
+			k.indexName, k.indexOn)
 	case SQLite:
 		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 			id TEXT PRIMARY KEY,
 			data BLOB NOT NULL,
-			expires_at TEXT NOT NULL
-		)`, k.tableName)
+			expires_at TEXT NOT NULL%s
+		)`, k.table, createdAt)
 		// Create index in a separate statement
-		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at_idx ON %s (expires_at)`,
-			k.tableName, k.tableName)
+		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)`,
+			k.indexName, k.indexOn)
 	default:
 		// Generic CREATE TABLE that should work on most systems
 		query = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 			id TEXT PRIMARY KEY,
 			data BLOB NOT NULL,
-			expires_at TIMESTAMP NOT NULL
-		)`, k.tableName)
+			expires_at TIMESTAMP NOT NULL%s
+		)`, k.table, createdAt)
 		// Create index in a separate statement
-		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_expires_at_idx ON %s (expires_at)`,
-			k.tableName, k.tableName)
+		indexQuery = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)`,
+			k.indexName, k.indexOn)
 	}
 
 	_, err := k.db.ExecContext(ctx, query)
@@ -0,0 +1,65 @@
+package sqlkv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterInterval(t *testing.T) {
+	const interval = 10 * time.Minute
+
+	if got := jitterInterval(interval, 0); got != interval {
+		t.Errorf("no jitter: want %s, got %s", interval, got)
+	}
+
+	var sawLow, sawHigh bool
+	for range 1000 {
+		got := jitterInterval(interval, 0.1)
+		if got < 9*time.Minute || got > 11*time.Minute {
+			t.Fatalf("jittered interval %s outside of ±10%% of %s", got, interval)
+		}
+		sawLow = sawLow || got < interval
+		sawHigh = sawHigh || got > interval
+	}
+	if !sawLow || !sawHigh {
+		t.Errorf("want jitter in both directions, got low=%t high=%t", sawLow, sawHigh)
+	}
+}
+
+func TestNewTableName(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		tableName string
+		dialect   Dialect
+		wantTable string
+		wantIndex string
+		wantErr   bool
+	}{
+		{name: "default", dialect: PostgreSQL, wantTable: `"web_sessions"`, wantIndex: `"web_sessions_expires_at_idx"`},
+		{name: "schema", tableName: "auth.web_sessions", dialect: PostgreSQL, wantTable: `"auth"."web_sessions"`, wantIndex: `"web_sessions_expires_at_idx"`},
+		{name: "mysql", tableName: "auth.web_sessions", dialect: MySQL, wantTable: "`auth`.`web_sessions`", wantIndex: "`web_sessions_expires_at_idx`"},
+		{name: "postgres mixed case", tableName: "Auth.WebSessions", dialect: PostgreSQL, wantTable: `"auth"."websessions"`, wantIndex: `"websessions_expires_at_idx"`},
+		{name: "sqlite schema", tableName: "auth.web_sessions", dialect: SQLite, wantTable: `"auth"."web_sessions"`, wantIndex: `"auth"."web_sessions_expires_at_idx"`},
+		{name: "injection", tableName: "sessions; DROP TABLE users", wantErr: true},
+		{name: "quote", tableName: `sessions"`, wantErr: true},
+		{name: "too many parts", tableName: "a.b.c", wantErr: true},
+		{name: "empty part", tableName: "auth.", wantErr: true},
+		{name: "leading digit", tableName: "1sessions", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kv, err := New(nil, &Opts{TableName: tc.tableName, Dialect: tc.dialect})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if kv.table != tc.wantTable {
+				t.Errorf("want table %s, got %s", tc.wantTable, kv.table)
+			}
+			if kv.indexName != tc.wantIndex {
+				t.Errorf("want index %s, got %s", tc.wantIndex, kv.indexName)
+			}
+		})
+	}
+}
@@ -29,9 +29,12 @@ func TestKV_SQLite(t *testing.T) {
 	t.Cleanup(cleanup)
 
 	// Create KV store with SQLite dialect
-	kv := sqlkv.New(db, &sqlkv.Opts{
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
 		Dialect: sqlkv.SQLite,
 	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
 
 	// Create the table
 	if err := kv.CreateTable(context.Background()); err != nil {
@@ -56,9 +59,12 @@ func TestKV_SQLite_GC(t *testing.T) {
 	defer cleanup()
 
 	// Create KV store
-	kv := sqlkv.New(db, &sqlkv.Opts{
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
 		Dialect: sqlkv.SQLite,
 	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
 
 	// Create the table
 	if err := kv.CreateTable(context.Background()); err != nil {
@@ -68,7 +74,7 @@ func TestKV_SQLite_GC(t *testing.T) {
 	ctx := context.Background()
 
 	// Insert some test data directly
-	_, err := db.Exec("INSERT INTO "+sqlkv.DefaultTableName+" (id, data, expires_at) VALUES (?, ?, ?)",
+	_, err = db.Exec("INSERT INTO "+sqlkv.DefaultTableName+" (id, data, expires_at) VALUES (?, ?, ?)",
 		"expired1", []byte(`{"test":"data1"}`), time.Now().Add(-1*time.Hour))
 	if err != nil {
 		t.Fatalf("Failed to insert test data: %v", err)
@@ -118,3 +124,175 @@ func TestKV_SQLite_GC(t *testing.T) {
 		t.Errorf("Expected valid key data to be preserved, got %s", string(data))
 	}
 }
+
+func TestKV_SQLite_WithTx(t *testing.T) {
+	db, cleanup := setupSQLiteDB(t)
+	t.Cleanup(cleanup)
+	// each in-memory connection is a separate database
+	db.SetMaxOpenConns(1)
+
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
+		Dialect: sqlkv.SQLite,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := kv.CreateTable(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		commit    bool
+		wantFound bool
+	}{
+		{name: "rollback", commit: false, wantFound: false},
+		{name: "commit", commit: true, wantFound: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := "tx-" + tc.name
+			txkv := kv.WithTx(tx)
+			if err := txkv.Set(ctx, key, time.Now().Add(time.Hour), []byte("data")); err != nil {
+				t.Fatalf("Set in transaction failed: %v", err)
+			}
+			if _, found, err := txkv.Get(ctx, key); err != nil || !found {
+				t.Fatalf("want key visible in transaction, found: %v err: %v", found, err)
+			}
+
+			if tc.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, found, err := kv.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tc.wantFound {
+				t.Errorf("want found %v, got %v", tc.wantFound, found)
+			}
+		})
+	}
+}
+
+func TestKV_SQLite_RunGCWait(t *testing.T) {
+	db, cleanup := setupSQLiteDB(t)
+	t.Cleanup(cleanup)
+	db.SetMaxOpenConns(1)
+
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
+		Dialect: sqlkv.SQLite,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if err := kv.CreateTable(context.Background()); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := kv.RunGC(ctx, time.Millisecond, nil)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GC goroutine did not stop after cancellation")
+	}
+}
+
+func TestKV_SQLite_CreatedAt(t *testing.T) {
+	db, cleanup := setupSQLiteDB(t)
+	t.Cleanup(cleanup)
+	db.SetMaxOpenConns(1)
+
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
+		Dialect:         sqlkv.SQLite,
+		CreatedAtColumn: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := kv.CreateTable(ctx); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	createdAt := func() string {
+		var v string
+		if err := db.QueryRow("SELECT created_at FROM "+sqlkv.DefaultTableName+" WHERE id = ?", "key").Scan(&v); err != nil {
+			t.Fatalf("Failed to read created_at: %v", err)
+		}
+		return v
+	}
+
+	if err := kv.Set(ctx, "key", time.Now().Add(time.Hour), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := createdAt(); got == "" {
+		t.Error("want created_at set on insert")
+	}
+	// backdate, so an update that reset it would be detected.
+	const backdated = "2020-01-01 00:00:00"
+	if _, err := db.Exec("UPDATE "+sqlkv.DefaultTableName+" SET created_at = ? WHERE id = ?", backdated, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Set(ctx, "key", time.Now().Add(2*time.Hour), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := createdAt(); got != backdated {
+		t.Errorf("want created_at to be unchanged by update, got %s", got)
+	}
+	if v, _, _ := kv.Get(ctx, "key"); string(v) != "v2" {
+		t.Errorf("want updated value, got %q", v)
+	}
+}
+
+func TestKV_SQLite_SchemaQualified(t *testing.T) {
+	db, cleanup := setupSQLiteDB(t)
+	t.Cleanup(cleanup)
+	// attached databases are per connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`ATTACH DATABASE ':memory:' AS auth`); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := sqlkv.New(db, &sqlkv.Opts{
+		Dialect:   sqlkv.SQLite,
+		TableName: "auth.sessions",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create KV store: %v", err)
+	}
+	if err := kv.CreateTable(context.Background()); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	clearFunc := func() {
+		if _, err := db.Exec("DELETE FROM auth.sessions"); err != nil {
+			t.Fatalf("Failed to clear table: %v", err)
+		}
+	}
+	t.Cleanup(clearFunc)
+
+	kvtest.RunComplianceTest(t, kv, clearFunc)
+}
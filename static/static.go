@@ -13,15 +13,26 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"lds.li/web/httperror"
 )
 
 const sumLength = 8
 
 type FileHandler struct {
+	// DefaultCacheControl is the Cache-Control header sent for requests for
+	// unversioned paths, e.g. "public, max-age=300". Versioned paths, as
+	// returned by PathFor, are always cached for a year. If empty, no
+	// Cache-Control header is sent for unversioned paths, so they are
+	// revalidated with their ETag.
+	DefaultCacheControl string
+
 	fs        fs.FS
 	checksums map[string]string
 	prefix    string
 	mtime     time.Time
+	// modTimes tracks the modification time of files, if the FS provides it.
+	modTimes map[string]time.Time
 }
 
 func NewFileHandler(f fs.FS, prefix string) (*FileHandler, error) {
@@ -29,6 +40,7 @@ func NewFileHandler(f fs.FS, prefix string) (*FileHandler, error) {
 		fs:        f,
 		checksums: make(map[string]string),
 		prefix:    prefix,
+		modTimes:  make(map[string]time.Time),
 	}
 
 	mt, err := embedModTime()
@@ -60,6 +72,12 @@ func NewFileHandler(f fs.FS, prefix string) (*FileHandler, error) {
 			return err
 		}
 		h.checksums[path] = hex.EncodeToString(hasher.Sum(nil))
+
+		// embed.FS has no modification times, these files fall back to the
+		// build time.
+		if info, err := d.Info(); err == nil && !info.ModTime().IsZero() {
+			h.modTimes[path] = info.ModTime()
+		}
 		return nil
 	}); err != nil {
 		return nil, err
@@ -69,6 +87,10 @@ func NewFileHandler(f fs.FS, prefix string) (*FileHandler, error) {
 }
 
 func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// files can be large, so they are streamed rather than held in memory by
+	// the error handler.
+	httperror.DisableBuffering(w)
+
 	p := strings.TrimPrefix(r.URL.Path, h.prefix)
 	if p == "" || p == "/" {
 		http.NotFound(w, r)
@@ -118,9 +140,15 @@ func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if useMaxAge {
 		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable") // 1 year
+	} else if h.DefaultCacheControl != "" {
+		w.Header().Set("Cache-Control", h.DefaultCacheControl)
 	}
 
-	http.ServeContent(w, r, path.Base(filePath), h.mtime, f.(io.ReadSeeker))
+	modTime, ok := h.modTimes[filePath]
+	if !ok {
+		modTime = h.mtime
+	}
+	http.ServeContent(w, r, path.Base(filePath), modTime, f.(io.ReadSeeker))
 }
 
 func (h *FileHandler) PathFor(filePath string) (string, error) {
@@ -6,6 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"lds.li/web/httperror"
 )
 
 //go:embed testdata
@@ -105,5 +109,89 @@ func TestStaticFileHandler(t *testing.T) {
 		if rr.Code != http.StatusOK {
 			t.Errorf("want response code %d, got: %d", http.StatusOK, rr.Code)
 		}
+		if got := rr.Header().Get("Cache-Control"); got != "" {
+			t.Errorf("want no cache-control, got: %s", got)
+		}
+	})
+
+	t.Run("unversioned request with default cache control", func(t *testing.T) {
+		h, err := NewFileHandler(testfs, "/static/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.DefaultCacheControl = "public, max-age=300"
+
+		req, _ := http.NewRequest("GET", "/static/file1.txt", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Cache-Control"); got != "public, max-age=300" {
+			t.Errorf("want default cache-control, got: %s", got)
+		}
+
+		versioned, err := h.PathFor("file1.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ = http.NewRequest("GET", versioned, nil)
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+			t.Errorf("want immutable cache-control for versioned path, got: %s", got)
+		}
 	})
 }
+
+func TestStaticFileHandlerModTime(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mfs := fstest.MapFS{
+		"file.txt": &fstest.MapFile{Data: []byte("hello"), ModTime: modTime},
+	}
+
+	h, err := NewFileHandler(mfs, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/static/file.txt", nil))
+	if got := rr.Header().Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("want last-modified %s, got: %s", modTime.Format(http.TimeFormat), got)
+	}
+
+	req := httptest.NewRequest("GET", "/static/file.txt", nil)
+	req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("want response code %d, got: %d", http.StatusNotModified, rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/static/file.txt", nil)
+	req.Header.Set("If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("want response code %d, got: %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestStaticFileHandlerNotBuffered(t *testing.T) {
+	fh, err := NewFileHandler(testfs, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	eh := &httperror.Handler{BufferResponse: true}
+	h := eh.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fh.ServeHTTP(w, r)
+		if rec.Body.Len() == 0 {
+			t.Error("file was buffered by the error handler, want it streamed")
+		}
+	}))
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/static/file1.txt", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"lds.li/web/static"
+)
+
+// staticMount is a static handler served at a prefix.
+type staticMount struct {
+	prefix  string
+	handler *static.FileHandler
+}
+
+// staticMounts resolves paths for templates across the default static handler
+// and any additional mounts. Paths starting with a mount's prefix resolve
+// against that mount, all other paths resolve against the default handler.
+type staticMounts struct {
+	def    *static.FileHandler
+	mounts []staticMount
+}
+
+func newStaticMounts(def *static.FileHandler, mounts []staticMount) *staticMounts {
+	mounts = slices.Clone(mounts)
+	// longest prefix first, so the most specific mount wins.
+	slices.SortFunc(mounts, func(a, b staticMount) int {
+		return cmp.Compare(len(b.prefix), len(a.prefix))
+	})
+	return &staticMounts{def: def, mounts: mounts}
+}
+
+func (s *staticMounts) PathFor(filePath string) (string, error) {
+	for _, m := range s.mounts {
+		if p, ok := strings.CutPrefix(filePath, m.prefix); ok {
+			return m.handler.PathFor(p)
+		}
+	}
+	return s.def.PathFor(filePath)
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"html/template"
-
 	"maps"
+	"strings"
 
 	"lds.li/web/csp"
 	"lds.li/web/internal/ctxkeys"
@@ -25,6 +25,13 @@ func TemplateFuncs(ctx context.Context, addlFuncs template.FuncMap) template.Fun
 			}
 			return template.HTMLAttr(`nonce="` + nonce + `"`)
 		},
+		"InlineScript": func(js template.JS) (template.HTML, error) {
+			nonce, ok := csp.GetScriptNonce(ctx)
+			if !ok {
+				return "", fmt.Errorf("InlineScript requires script nonces to be enabled")
+			}
+			return template.HTML(`<script nonce="` + nonce + `">` + escapeInlineScript(string(js)) + `</script>`), nil
+		},
 		"StyleNonceAttr": func() template.HTMLAttr {
 			nonce, ok := csp.GetStyleNonce(ctx)
 			if !ok {
@@ -45,12 +52,22 @@ func TemplateFuncs(ctx context.Context, addlFuncs template.FuncMap) template.Fun
 			}
 			return sess.FlashIsError(), nil
 		},
+		// FlashMessage returns a string rather than template.HTML, so the
+		// message is always escaped.
 		"FlashMessage": func() (string, error) {
 			if !sessOk {
 				return "", fmt.Errorf("session not found")
 			}
 			return sess.FlashMessage(), nil
 		},
+		// URLs
+		"AbsoluteURL": func(path string) (string, error) {
+			base, ok := ctxkeys.BaseURLFromContext(ctx)
+			if !ok {
+				return "", fmt.Errorf("base URL not found")
+			}
+			return absoluteURL(base, path), nil
+		},
 		// Static
 		"StaticPath": func(file string) (string, error) {
 			if !shOk {
@@ -64,3 +81,23 @@ func TemplateFuncs(ctx context.Context, addlFuncs template.FuncMap) template.Fun
 
 	return fm
 }
+
+// escapeInlineScript makes JS safe to place inside a script element, by
+// escaping the "</script" and "<!--" sequences that would end it or change how
+// it is parsed. The escaped forms are equivalent inside JS strings and
+// regexps, where these sequences legitimately occur.
+func escapeInlineScript(js string) string {
+	var b strings.Builder
+	for i := 0; i < len(js); i++ {
+		if js[i] == '<' && (hasPrefixFold(js[i:], "</script") || strings.HasPrefix(js[i:], "<!--")) {
+			b.WriteString(`<\`)
+			continue
+		}
+		b.WriteByte(js[i])
+	}
+	return b.String()
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -14,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"lds.li/web/csp"
 	"lds.li/web/internal/ctxkeys"
 	"lds.li/web/session"
 	"lds.li/web/static"
@@ -35,6 +36,7 @@ FlashIsError: {{FlashIsError}}
 FlashMessage: {{FlashMessage}}
 StaticPath: {{StaticPath "subdir/file2.txt"}}
 ScriptNonceAttr: {{ScriptNonceAttr}}
+AbsoluteURL: {{AbsoluteURL "/callback?a=b"}}
 {{end}}`)
 	if err != nil {
 		t.Fatal(err)
@@ -71,6 +73,7 @@ FlashIsError: false
 FlashMessage:
 StaticPath: /static/subdir/file2.687830f0.txt
 ScriptNonceAttr: %s
+AbsoluteURL: https://example.com/callback?a=b
 `,
 		},
 		{
@@ -85,6 +88,22 @@ ScriptNonceAttr: %s
 		FlashMessage: an error occurred
 		StaticPath: /static/subdir/file2.687830f0.txt
 		ScriptNonceAttr: %s
+		AbsoluteURL: https://example.com/callback?a=b
+		`,
+		},
+		{
+			name: "flash is escaped",
+			session: func(s *session.Session) *session.Session {
+				s.SetFlashMessage(`<script>alert("hi")</script>`)
+				return s
+			},
+			want: `
+		HasFlash: true
+		FlashIsError: false
+		FlashMessage: &lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt;
+		StaticPath: /static/subdir/file2.687830f0.txt
+		ScriptNonceAttr: %s
+		AbsoluteURL: https://example.com/callback?a=b
 		`,
 		},
 	}
@@ -146,3 +165,62 @@ func cleanString(input string) string {
 
 	return decoded
 }
+
+func TestTemplateFuncsInlineScript(t *testing.T) {
+	tmpl := `{{InlineScript .}}`
+
+	for _, tc := range []struct {
+		name    string
+		nonce   bool
+		js      template.JS
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "script",
+			nonce: true,
+			js:    `window.config = {"a": 1};`,
+			want:  `<script nonce="%s">window.config = {"a": 1};</script>`,
+		},
+		{
+			name:  "escapes closing tag",
+			nonce: true,
+			js:    `var s = "</ScRiPt><script>alert(1)</script><!--";`,
+			want:  `<script nonce="%s">var s = "<\/ScRiPt><script>alert(1)<\/script><\!--";</script>`,
+		},
+		{
+			name:    "nonces disabled",
+			js:      `window.config = {};`,
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []csp.HandlerOpt
+			if tc.nonce {
+				opts = append(opts, csp.WithScriptNonce())
+			}
+			h := csp.NewHandler(url.URL{Scheme: "https", Host: "example.com"}, opts...)
+
+			var (
+				got   strings.Builder
+				nonce string
+				err   error
+			)
+			h.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nonce, _ = csp.GetScriptNonce(r.Context())
+				tpl := template.Must(template.New("").Funcs(TemplateFuncs(r.Context(), nil)).Parse(tmpl))
+				err = tpl.Execute(&got, tc.js)
+			})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr {
+				return
+			}
+			if diff := cmp.Diff(fmt.Sprintf(tc.want, nonce), got.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
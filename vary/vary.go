@@ -0,0 +1,63 @@
+// Package vary manages the Vary response header. Multiple layers of a request
+// may produce a response that differs based on request headers, each needs to
+// be reflected in Vary to avoid shared caches serving the wrong response. This
+// package merges those in to a single, de-duplicated header.
+package vary
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Add adds the given request header names to the Vary header, merging with any
+// existing values. Names are de-duplicated case-insensitively, and the result
+// is written as a single header value. If the header is already "*", it is
+// left as is.
+func Add(h http.Header, names ...string) {
+	existing := Values(h)
+	if len(existing) == 1 && existing[0] == "*" {
+		return
+	}
+
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if n == "*" {
+			existing = []string{"*"}
+			break
+		}
+		if !contains(existing, n) {
+			existing = append(existing, http.CanonicalHeaderKey(n))
+		}
+	}
+
+	if len(existing) == 0 {
+		return
+	}
+	h.Set("Vary", strings.Join(existing, ", "))
+}
+
+// Values returns the header names in the Vary header, across all header lines.
+func Values(h http.Header) []string {
+	var ret []string
+	for _, line := range h.Values("Vary") {
+		for _, v := range strings.Split(line, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" && !contains(ret, v) {
+				ret = append(ret, v)
+			}
+		}
+	}
+	return ret
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
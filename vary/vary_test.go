@@ -0,0 +1,77 @@
+package vary
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		add      []string
+		want     []string
+	}{
+		{
+			name: "empty",
+			add:  []string{"Accept"},
+			want: []string{"Accept"},
+		},
+		{
+			name:     "merges",
+			existing: []string{"Accept-Encoding"},
+			add:      []string{"Accept"},
+			want:     []string{"Accept-Encoding, Accept"},
+		},
+		{
+			name:     "dedupes case insensitively",
+			existing: []string{"accept"},
+			add:      []string{"Accept", "Cookie"},
+			want:     []string{"accept, Cookie"},
+		},
+		{
+			name:     "collapses multiple lines",
+			existing: []string{"Accept", "Cookie, Accept"},
+			add:      []string{"Origin"},
+			want:     []string{"Accept, Cookie, Origin"},
+		},
+		{
+			name: "canonicalizes",
+			add:  []string{"accept-encoding"},
+			want: []string{"Accept-Encoding"},
+		},
+		{
+			name:     "star wins",
+			existing: []string{"Accept"},
+			add:      []string{"*"},
+			want:     []string{"*"},
+		},
+		{
+			name:     "star is kept",
+			existing: []string{"*"},
+			add:      []string{"Accept"},
+			want:     []string{"*"},
+		},
+		{
+			name: "nothing to add",
+			add:  []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for _, e := range tt.existing {
+				h.Add("Vary", e)
+			}
+
+			Add(h, tt.add...)
+
+			if diff := cmp.Diff(tt.want, h.Values("Vary")); diff != "" {
+				t.Errorf("Vary mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}